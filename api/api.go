@@ -1,5 +1,10 @@
 package api
 
+import (
+	"encoding/json"
+	"net/http"
+)
+
 // json communication
 // {action: crud, objects: just like yaml object}
 
@@ -11,6 +16,7 @@ package api
 // GET /ingress
 // DELETE /ingress/{name}
 // PUT /ingress add
+// DELETE /ingress/{name}/users/{user} revoke user of ingress
 
 // ingress group
 // GET /ingressgroup
@@ -33,3 +39,29 @@ package api
 // log
 // GET /logs get logs records
 // PUT /logs update log configuration
+
+// dns
+// GET /dns/queries recent queries, oldest first
+// GET /dns/clients query counters of recently seen clients
+// GET /dns/blocks hit counters of block lists
+
+// Handler routes requests of restful api
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dns/queries", getDNSQueries)
+	mux.HandleFunc("/dns/clients", getDNSClients)
+	mux.HandleFunc("/dns/blocks", getDNSBlocks)
+	mux.HandleFunc("/ingress/", handleIngress)
+	return mux
+}
+
+// writeJSON answers GET request with v encoded in json
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -1 +1,31 @@
 package api
+
+import (
+	"net/http"
+
+	"github.com/intxff/rdcross/dns"
+)
+
+func getDNSQueries(w http.ResponseWriter, r *http.Request) {
+	q := dns.Queries()
+	if q == nil {
+		q = []dns.QueryEntry{}
+	}
+	writeJSON(w, r, q)
+}
+
+func getDNSClients(w http.ResponseWriter, r *http.Request) {
+	c := dns.ClientStats()
+	if c == nil {
+		c = map[string]dns.ClientStat{}
+	}
+	writeJSON(w, r, c)
+}
+
+func getDNSBlocks(w http.ResponseWriter, r *http.Request) {
+	b := dns.BlockStats()
+	if b == nil {
+		b = map[string]uint64{}
+	}
+	writeJSON(w, r, b)
+}
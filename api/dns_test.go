@@ -0,0 +1,32 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDNS(t *testing.T) {
+	h := Handler()
+	for _, path := range []string{"/dns/queries", "/dns/clients", "/dns/blocks"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %v: %v", path, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("GET %v: content type %v", path, ct)
+		}
+		var v any
+		if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil || v == nil {
+			t.Errorf("GET %v: body %q", path, w.Body.String())
+		}
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, path, nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("PUT %v: %v", path, w.Code)
+		}
+	}
+}
@@ -1 +1,44 @@
 package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/intxff/rdcross/component/proxy"
+	"github.com/intxff/rdcross/global"
+)
+
+// handleIngress serves paths under /ingress/
+func handleIngress(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/ingress/"), "/")
+	if len(path) == 3 && path[1] == "users" {
+		removeUser(w, r, path[0], path[2])
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// removeUser revokes user of ingress, new connections of the user are
+// refused while others go on
+func removeUser(w http.ResponseWriter, r *http.Request, name, user string) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodDelete)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	ing, exist := global.New().GetIngress(name)
+	if !exist {
+		http.Error(w, "ingress not found", http.StatusNotFound)
+		return
+	}
+	p, ok := ing.Proxy().(proxy.UserRemover)
+	if !ok {
+		http.Error(w, "proxy of ingress has no users", http.StatusBadRequest)
+		return
+	}
+	if !p.RemoveUser(user) {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
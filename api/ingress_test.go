@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/intxff/rdcross/component/proxy"
+	"github.com/intxff/rdcross/component/proxy/none"
+	"github.com/intxff/rdcross/component/proxy/socks"
+	"github.com/intxff/rdcross/global"
+	"github.com/intxff/rdcross/ingress"
+	"github.com/intxff/rdcross/ingress/general"
+)
+
+func TestRemoveUser(t *testing.T) {
+	s := socks.NewProxySocks(proxy.ModeServer)
+	s.AddUser("alice", "secret")
+	err := global.Register(global.Ingress, map[string]ingress.Ingress{
+		"socks": general.NewGeneral("socks", s),
+		"none":  general.NewGeneral("none", none.NewProxyNone(proxy.ModeServer)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := Handler()
+	tests := []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodGet, "/ingress/socks/users/alice", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/ingress/socks/users/alice", http.StatusNoContent},
+		// already revoked
+		{http.MethodDelete, "/ingress/socks/users/alice", http.StatusNotFound},
+		{http.MethodDelete, "/ingress/nothing/users/alice", http.StatusNotFound},
+		{http.MethodDelete, "/ingress/none/users/alice", http.StatusBadRequest},
+		{http.MethodDelete, "/ingress/socks", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("%v %v: %v, want %v", tt.method, tt.path, w.Code, tt.code)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/intxff/rdcross/api"
 	"github.com/intxff/rdcross/config"
 	"github.com/intxff/rdcross/global"
 	"github.com/intxff/rdcross/log"
@@ -72,12 +73,25 @@ func Run() {
 	}
 
 	// restful api
+	var apiServer *http.Server
+	if g.API != "" {
+		apiServer = &http.Server{Addr: g.API, Handler: api.Handler()}
+		go func() {
+			if err := apiServer.ListenAndServe(); err != http.ErrServerClosed {
+				log.Error("[API] failed to serve", zap.Error(err))
+			}
+		}()
+	}
 
 	<-ctx.Done()
 	log.Info("[EXIT] Closing")
 	//close all
 	closeall := func() <-chan struct{} {
+		if apiServer != nil {
+			apiServer.Close()
+		}
 		g.DNS.Shutdown()
+		g.CloseFakeIP()
 		ch := make(chan struct{}, 1)
 		for _, v := range g.Ingress {
 			<-v.Close()
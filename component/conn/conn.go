@@ -33,3 +33,9 @@ type ProxyStreamConn interface {
 	// only one conn, metadata info should be stored
 	Metadata() *message.Metadata
 }
+
+// PacketStreamConn is implemented by stream connections which may turn
+// out to carry udp packets, PacketConn is nil if it does not
+type PacketStreamConn interface {
+	PacketConn() ProxyPacketConn
+}
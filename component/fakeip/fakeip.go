@@ -6,9 +6,14 @@ import (
 	"net"
 	"sync"
 
+	"github.com/intxff/rdcross/log"
 	"github.com/intxff/rdcross/util/lru"
+	"go.uber.org/zap"
 )
 
+// at most 2^maxRooms addresses of range are used
+const maxRooms = 20
+
 type FakeIP struct {
 	lru           *lru.LRU
 	ipRange       *net.IPNet
@@ -17,6 +22,7 @@ type FakeIP struct {
 	networkAddr   net.IP
 	broadcastAddr net.IP
 	ifaceAddr     net.IP
+	store         *store
 }
 
 func New(ipCIDR string) (*FakeIP, error) {
@@ -27,6 +33,10 @@ func New(ipCIDR string) (*FakeIP, error) {
 
 	ones, bits := ipRange.Mask.Size()
 	rooms := bits - ones
+	// ipv6 range is too large to be kept in memory
+	if rooms > maxRooms {
+		rooms = maxRooms
+	}
     lruSize := int(math.Pow(2, float64(rooms)))-3
 
 	nextIP := big.NewInt(0).SetBytes(ipRange.IP)
@@ -77,7 +87,9 @@ func (f *FakeIP) Put(domain string) net.IP {
 	if f.lru.IsFull() {
         ips := f.lru.GetLastValue().(string)
 		f.lru.Put(domain, ips)
-		return net.ParseIP(ips)
+		ip = net.ParseIP(ips)
+		f.persist(domain, ip)
+		return ip
 	}
 	for {
 		f.nextIP = f.nextIP.Add(f.nextIP, big.NewInt(1))
@@ -95,5 +107,12 @@ func (f *FakeIP) Put(domain string) net.IP {
 		}
 	}
 	f.lru.Put(domain, ip.String())
+	f.persist(domain, ip)
 	return ip
 }
+
+func (f *FakeIP) persist(domain string, ip net.IP) {
+	if err := f.record(domain, ip); err != nil {
+		log.Error("[FakeIP] failed to persist mapping", zap.Error(err))
+	}
+}
@@ -0,0 +1,143 @@
+package fakeip
+
+import (
+	"bufio"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+)
+
+// least appended records before compaction
+const minCompact = 1024
+
+// store keeps domain and ip mapping in an append-only file. Every line
+// is "ip domain", later lines override earlier ones. File is rewritten
+// with entries alive in pool when too many lines have been appended.
+type store struct {
+	path     string
+	f        *os.File
+	appended int
+}
+
+// Persist restores mapping from file at path then records every new
+// mapping into it
+func (f *FakeIP) Persist(path string) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if err := f.load(path); err != nil {
+		return err
+	}
+	f.store = &store{path: path}
+	return f.compact()
+}
+
+func (f *FakeIP) load(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := strings.Fields(scanner.Text())
+		if len(entry) != 2 {
+			continue
+		}
+		ip := net.ParseIP(entry[0])
+		// cidr may be changed since last run
+		if ip == nil || !f.ipRange.Contains(ip) {
+			continue
+		}
+		if f.ifaceAddr.Equal(ip) || f.broadcastAddr.Equal(ip) {
+			continue
+		}
+		if v4 := ip.To4(); v4 != nil {
+			ip = v4
+		}
+		// ip allocated again after evicted, domain before loses it
+		if domain, exist := f.lru.GetKeyFromValue(ip.String()); exist && domain != entry[1] {
+			f.lru.Delete(domain)
+		}
+		f.lru.Put(entry[1], ip.String())
+		// continue allocating after last one
+		f.nextIP = big.NewInt(0).SetBytes(ip)
+	}
+	return scanner.Err()
+}
+
+// compact rewrites file with mapping in pool, from least to most
+// recently used so that order is kept after restored
+func (f *FakeIP) compact() error {
+	s := f.store
+	tmp := s.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	f.lru.Range(func(key, value interface{}) bool {
+		_, err = fmt.Fprintf(w, "%v %v\n", value, key)
+		return err == nil
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	if s.f != nil {
+		s.f.Close()
+	}
+	s.f, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	s.appended = 0
+	return err
+}
+
+// record appends a new mapping, must be called with f.m held
+func (f *FakeIP) record(domain string, ip net.IP) error {
+	s := f.store
+	if s == nil || s.f == nil {
+		return nil
+	}
+	if _, err := fmt.Fprintf(s.f, "%v %v\n", ip, domain); err != nil {
+		return err
+	}
+	s.appended++
+	if s.appended > minCompact && s.appended > f.lru.Len() {
+		return f.compact()
+	}
+	return nil
+}
+
+// Close compacts file with mapping alive in pool and stops recording
+func (f *FakeIP) Close() error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.store == nil || f.store.f == nil {
+		return nil
+	}
+	err := f.compact()
+	if f.store.f != nil {
+		if cerr := f.store.f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	f.store.f = nil
+	return err
+}
@@ -0,0 +1,46 @@
+package fakeip
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fakeip.cache")
+	// 198.18.0.2 is given to b.com after a.com is evicted
+	content := "198.18.0.2 a.com\n198.18.0.3 c.com\n198.18.0.2 b.com\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := New("198.18.0.1/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Persist(path); err != nil {
+		t.Fatal(err)
+	}
+	if ip, exist := f.GetIPByDomain("a.com"); exist {
+		t.Errorf("evicted a.com restored with %v", ip)
+	}
+	if ip, _ := f.GetIPByDomain("b.com"); ip.String() != "198.18.0.2" {
+		t.Errorf("b.com restored with %v", ip)
+	}
+	ip := f.Put("d.com")
+	if ip.String() != "198.18.0.4" {
+		t.Errorf("allocation continued with %v", ip)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 3 || lines[2] != "198.18.0.4 d.com" {
+		t.Errorf("file not compacted on close: %q", lines)
+	}
+}
@@ -0,0 +1,347 @@
+package faketcp
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// segments queued for a client not reading, more are dropped as udp
+const connQueue = 256
+
+type received struct {
+	s    *segment
+	from *net.TCPAddr
+}
+
+// rawMux is raw socket of a local ip shared by clients, segments read
+// are dispatched to clients by port
+type rawMux struct {
+	conn *net.IPConn
+	key  string
+	refs int
+
+	mu    sync.Mutex
+	ports map[int]chan received
+}
+
+var (
+	muxMu sync.Mutex
+	muxes = make(map[string]*rawMux)
+)
+
+// getMux gets raw socket of ip, it is opened for the first client
+func getMux(ip net.IP, zone string) (*rawMux, error) {
+	key := (&net.IPAddr{IP: ip, Zone: zone}).String()
+	muxMu.Lock()
+	defer muxMu.Unlock()
+	if m, exist := muxes[key]; exist {
+		m.refs++
+		return m, nil
+	}
+	c, err := openRaw(ip, zone)
+	if err != nil {
+		return nil, err
+	}
+	m := &rawMux{conn: c, key: key, refs: 1, ports: make(map[int]chan received)}
+	muxes[key] = m
+	go m.loop()
+	return m, nil
+}
+
+// release closes raw socket after the last client leaves
+func (m *rawMux) release() {
+	muxMu.Lock()
+	defer muxMu.Unlock()
+	if m.refs--; m.refs == 0 {
+		delete(muxes, m.key)
+		m.conn.Close()
+	}
+}
+
+func (m *rawMux) register(port int) <-chan received {
+	ch := make(chan received, connQueue)
+	m.mu.Lock()
+	m.ports[port] = ch
+	m.mu.Unlock()
+	return ch
+}
+
+func (m *rawMux) unregister(port int) {
+	m.mu.Lock()
+	delete(m.ports, port)
+	m.mu.Unlock()
+}
+
+func (m *rawMux) loop() {
+	b := make([]byte, maxSegment)
+	defer func() {
+		m.mu.Lock()
+		for port, ch := range m.ports {
+			close(ch)
+			delete(m.ports, port)
+		}
+		m.mu.Unlock()
+	}()
+	for {
+		n, addr, err := m.conn.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		s, err := parseSegment(b[:n])
+		if err != nil {
+			continue
+		}
+		m.mu.Lock()
+		ch, exist := m.ports[s.dstPort]
+		m.mu.Unlock()
+		if !exist {
+			continue
+		}
+		// buffer is reused
+		s.payload = append([]byte(nil), s.payload...)
+		ip := addr.(*net.IPAddr)
+		select {
+		case ch <- received{s: s, from: &net.TCPAddr{IP: ip.IP, Port: s.srcPort, Zone: ip.Zone}}:
+		default:
+		}
+	}
+}
+
+// Dialer fakes connections to remote. RST sent by kernel to remote is
+// dropped by one rule installed at the first dial, which is removed by
+// Close.
+type Dialer struct {
+	remote *net.TCPAddr
+	once   sync.Once
+	undo   func()
+}
+
+func NewDialer(remote *net.TCPAddr) *Dialer {
+	return &Dialer{remote: remote, undo: func() {}}
+}
+
+// Dial fakes connection from laddr to remote of d
+func (d *Dialer) Dial(laddr *net.TCPAddr) (*Conn, error) {
+	d.once.Do(func() {
+		d.undo = dropRST(d.remote.IP, "-d", d.remote.IP.String(),
+			"--dport", strconv.Itoa(d.remote.Port))
+	})
+	return Dial(laddr, d.remote)
+}
+
+// Close removes rule dropping RST, connections are not affected
+func (d *Dialer) Close() error {
+	d.once.Do(func() {})
+	d.undo()
+	return nil
+}
+
+// Conn is client of a faked connection, every datagram written is a
+// segment to remote
+type Conn struct {
+	mux      *rawMux
+	local    *net.TCPAddr
+	remote   *net.TCPAddr
+	segments <-chan received
+	// keeps port from being taken by kernel
+	reserved int
+
+	mu sync.Mutex
+	// next sequence number to send and to receive
+	seq, ack uint32
+
+	readDeadline atomic.Value
+	die          chan struct{}
+	dieOnce      sync.Once
+}
+
+// Dial fakes connection from laddr to raddr by handshake, ip of laddr
+// is chosen by route if nil, port is taken by kernel. RST of kernel is
+// not dropped, see Dialer.
+func Dial(laddr, raddr *net.TCPAddr) (*Conn, error) {
+	local := &net.TCPAddr{}
+	if laddr != nil {
+		*local = *laddr
+	}
+	if local.IP == nil {
+		ip, err := localIP(raddr)
+		if err != nil {
+			return nil, err
+		}
+		local.IP = ip
+	}
+	fd, port, err := reservePort(local.IP)
+	if err != nil {
+		return nil, err
+	}
+	local.Port = port
+	mux, err := getMux(local.IP, local.Zone)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	c := &Conn{
+		mux:      mux,
+		local:    local,
+		remote:   raddr,
+		segments: mux.register(port),
+		reserved: fd,
+		seq:      randUint32(),
+		die:      make(chan struct{}),
+	}
+	if err := c.handshake(); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Conn) send(s *segment) error {
+	return sendSegment(c.mux.conn, s, c.local.IP, c.local.Port, c.remote)
+}
+
+// recv waits for segment from remote till read deadline
+func (c *Conn) recv() (*segment, error) {
+	var timeout <-chan time.Time
+	if d, ok := c.readDeadline.Load().(time.Time); ok && !d.IsZero() {
+		timer := time.NewTimer(time.Until(d))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		select {
+		case r, ok := <-c.segments:
+			if !ok {
+				return nil, ErrClosed
+			}
+			if !r.from.IP.Equal(c.remote.IP) || r.from.Port != c.remote.Port {
+				continue
+			}
+			return r.s, nil
+		case <-c.die:
+			return nil, ErrClosed
+		case <-timeout:
+			return nil, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (c *Conn) handshake() error {
+	defer c.SetReadDeadline(time.Time{})
+	for i := 0; i < synRetries; i++ {
+		if err := c.send(&segment{seq: c.seq, flags: flagSYN}); err != nil {
+			return err
+		}
+		c.SetReadDeadline(time.Now().Add(synRetry))
+		for {
+			s, err := c.recv()
+			if err != nil {
+				if errors.Is(err, os.ErrDeadlineExceeded) {
+					break
+				}
+				return err
+			}
+			if s.flags&(flagSYN|flagACK) != flagSYN|flagACK || s.ack != c.seq+1 {
+				continue
+			}
+			c.seq, c.ack = c.seq+1, s.seq+1
+			return c.send(&segment{seq: c.seq, ack: c.ack, flags: flagACK})
+		}
+	}
+	return ErrHandshake
+}
+
+// ReadFrom reads payload of segment from remote
+func (c *Conn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		s, err := c.recv()
+		if err != nil {
+			return 0, nil, err
+		}
+		if s.flags&flagACK == 0 || len(s.payload) == 0 {
+			continue
+		}
+		c.mu.Lock()
+		if end := s.seq + uint32(len(s.payload)); int32(end-c.ack) > 0 {
+			c.ack = end
+		}
+		c.mu.Unlock()
+		return copy(b, s.payload), udpAddr(c.remote), nil
+	}
+}
+
+// WriteTo writes b as a segment to remote, addr is ignored
+func (c *Conn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.die:
+		return 0, ErrClosed
+	default:
+	}
+	c.mu.Lock()
+	s := &segment{seq: c.seq, ack: c.ack, flags: flagPSH | flagACK, payload: b}
+	c.seq += uint32(len(b))
+	c.mu.Unlock()
+	if err := c.send(s); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close tells remote by FIN, as RST is also sent by kernel
+func (c *Conn) Close() error {
+	select {
+	case <-c.die:
+		return ErrClosed
+	default:
+	}
+	c.mu.Lock()
+	s := &segment{seq: c.seq, ack: c.ack, flags: flagFIN | flagACK}
+	c.mu.Unlock()
+	c.send(s)
+	return c.close()
+}
+
+func (c *Conn) close() error {
+	closed := false
+	c.dieOnce.Do(func() {
+		close(c.die)
+		closed = true
+	})
+	if !closed {
+		return ErrClosed
+	}
+	c.mux.unregister(c.local.Port)
+	c.mux.release()
+	return unix.Close(c.reserved)
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return udpAddr(c.local)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return udpAddr(c.remote)
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Store(t)
+	return nil
+}
+
+// SetWriteDeadline does nothing, raw socket is shared and writes of it
+// do not block
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,174 @@
+// Carry udp datagrams in tcp segments by raw sockets, for networks
+// throttling or dropping udp. The kernel knows nothing of the faked
+// connections and answers them with RST, which is ignored by peers but
+// may break middleboxes, so RST from port of listener or to remote of
+// dialer is dropped by iptables if it is found.
+package faketcp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// SYN is sent again after so long without SYN-ACK
+	synRetry   = time.Second
+	synRetries = 5
+	// flows of listener idle for so long are dropped
+	flowTimeout = 5 * time.Minute
+	// flows kept by listener, SYNs of new clients are dropped beyond
+	maxFlows   = 4096
+	maxSegment = 65535
+)
+
+var (
+	ErrHandshake = errors.New("faketcp handshake failed")
+	ErrNoFlow    = errors.New("faketcp flow not established")
+	ErrClosed    = errors.New("faketcp closed")
+)
+
+func randUint32() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// rawConn sends and receives tcp segments of a local address
+type rawConn struct {
+	*net.IPConn
+	local *net.TCPAddr
+	// undo iptables rule
+	undo func()
+}
+
+func listenRaw(local *net.TCPAddr) (*rawConn, error) {
+	c, err := openRaw(local.IP, local.Zone)
+	if err != nil {
+		return nil, err
+	}
+	undo := dropRST(local.IP, "--sport", strconv.Itoa(local.Port))
+	return &rawConn{IPConn: c, local: local, undo: undo}, nil
+}
+
+func openRaw(ip net.IP, zone string) (*net.IPConn, error) {
+	network := "ip4:tcp"
+	if ip.To4() == nil {
+		network = "ip6:tcp"
+	}
+	return net.ListenIP(network, &net.IPAddr{IP: ip, Zone: zone})
+}
+
+// send sends segment from src, which is the ip kernel puts in ip header
+func (r *rawConn) send(s *segment, src net.IP, to *net.TCPAddr) error {
+	return sendSegment(r.IPConn, s, src, r.local.Port, to)
+}
+
+func sendSegment(c *net.IPConn, s *segment, src net.IP, port int, to *net.TCPAddr) error {
+	s.srcPort, s.dstPort = port, to.Port
+	_, err := c.WriteTo(s.marshal(src, to.IP), &net.IPAddr{IP: to.IP, Zone: to.Zone})
+	return err
+}
+
+// recv reads segment to local port, returns it with address of peer
+func (r *rawConn) recv(b []byte) (*segment, *net.TCPAddr, error) {
+	for {
+		n, addr, err := r.ReadFrom(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		s, err := parseSegment(b[:n])
+		if err != nil || s.dstPort != r.local.Port {
+			continue
+		}
+		ip := addr.(*net.IPAddr)
+		return s, &net.TCPAddr{IP: ip.IP, Port: s.srcPort, Zone: ip.Zone}, nil
+	}
+}
+
+func (r *rawConn) Close() error {
+	r.undo()
+	return r.IPConn.Close()
+}
+
+// dropRST adds iptables rule dropping RST sent by kernel, which matches
+// tcp segments of ip family by match, it does nothing if iptables is not
+// found
+func dropRST(ip net.IP, match ...string) func() {
+	cmd := "iptables"
+	if ip.To4() == nil {
+		cmd = "ip6tables"
+	}
+	path, err := exec.LookPath(cmd)
+	if err != nil {
+		return func() {}
+	}
+	rule := append([]string{"OUTPUT", "-p", "tcp"}, match...)
+	rule = append(rule, "--tcp-flags", "RST", "RST", "-j", "DROP")
+	if exec.Command(path, append([]string{"-I"}, rule...)...).Run() != nil {
+		return func() {}
+	}
+	return func() {
+		exec.Command(path, append([]string{"-D"}, rule...)...).Run()
+	}
+}
+
+// reservePort binds a tcp socket without listening, so that kernel does
+// not give the port to others
+func reservePort(ip net.IP) (int, int, error) {
+	var (
+		fd  int
+		err error
+		sa  unix.Sockaddr
+	)
+	if ip4 := ip.To4(); ip4 != nil {
+		fd, err = unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+		addr := &unix.SockaddrInet4{}
+		copy(addr.Addr[:], ip4)
+		sa = addr
+	} else {
+		fd, err = unix.Socket(unix.AF_INET6, unix.SOCK_STREAM, 0)
+		addr := &unix.SockaddrInet6{}
+		copy(addr.Addr[:], ip.To16())
+		sa = addr
+	}
+	if err != nil {
+		return -1, 0, err
+	}
+	if err = unix.Bind(fd, sa); err != nil {
+		unix.Close(fd)
+		return -1, 0, err
+	}
+	if sa, err = unix.Getsockname(fd); err != nil {
+		unix.Close(fd)
+		return -1, 0, err
+	}
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return fd, sa.Port, nil
+	case *unix.SockaddrInet6:
+		return fd, sa.Port, nil
+	}
+	unix.Close(fd)
+	return -1, 0, errors.New("unknown socket address")
+}
+
+// localIP gets ip kernel chooses to reach remote
+func localIP(remote *net.TCPAddr) (net.IP, error) {
+	c, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: remote.IP, Port: remote.Port, Zone: remote.Zone})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+func udpAddr(a *net.TCPAddr) *net.UDPAddr {
+	return &net.UDPAddr{IP: a.IP, Port: a.Port, Zone: a.Zone}
+}
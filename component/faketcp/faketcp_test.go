@@ -0,0 +1,174 @@
+package faketcp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFakeTCP(t *testing.T) {
+	for _, ip := range []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback} {
+		// raw socket does not take port, get a free one for test
+		tl, err := net.ListenTCP("tcp", &net.TCPAddr{IP: ip})
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := tl.Addr().(*net.TCPAddr)
+		tl.Close()
+
+		l, err := Listen(addr)
+		if err != nil {
+			// raw sockets need CAP_NET_RAW
+			t.Skip(err)
+		}
+		type result struct {
+			payload string
+			addr    net.Addr
+		}
+		results := make(chan result, 1)
+		go func() {
+			b := make([]byte, 1500)
+			for {
+				n, from, err := l.ReadFrom(b)
+				if err != nil {
+					return
+				}
+				results <- result{string(b[:n]), from}
+			}
+		}()
+
+		c, err := Dial(nil, addr)
+		if err != nil {
+			t.Fatalf("%v: %v", ip, err)
+		}
+		if _, err := c.WriteTo([]byte("ping"), nil); err != nil {
+			t.Fatal(err)
+		}
+		var r result
+		select {
+		case r = <-results:
+		case <-time.After(time.Second):
+			t.Fatalf("%v: no packet received", ip)
+		}
+		if r.payload != "ping" || r.addr.String() != c.LocalAddr().String() {
+			t.Errorf("%v: got %q from %v", ip, r.payload, r.addr)
+		}
+
+		if _, err := l.WriteTo([]byte("pong"), r.addr); err != nil {
+			t.Fatal(err)
+		}
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		b := make([]byte, 1500)
+		n, from, err := c.ReadFrom(b)
+		if err != nil || string(b[:n]) != "pong" || from.String() != udpAddr(addr).String() {
+			t.Errorf("%v: got %q from %v: %v", ip, b[:n], from, err)
+		}
+
+		// flow is gone once client closes
+		c.Close()
+		time.Sleep(50 * time.Millisecond)
+		if _, err := l.WriteTo([]byte("pong"), r.addr); err != ErrNoFlow {
+			t.Errorf("%v: write after close: %v", ip, err)
+		}
+		l.Close()
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	src, dst := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+	s := &segment{srcPort: 1234, dstPort: 80, seq: 1, flags: flagSYN}
+	b := s.marshal(src, dst)
+	// sum over segment with its checksum is zero
+	if checksum(src, dst, b) != 0 {
+		t.Error("checksum mismatch")
+	}
+	p, err := parseSegment(b)
+	if err != nil || p.srcPort != 1234 || p.dstPort != 80 || p.flags != flagSYN {
+		t.Errorf("parsed %+v %v", p, err)
+	}
+}
+
+func TestSharedRaw(t *testing.T) {
+	ip := net.IPv4(127, 0, 0, 1)
+	tl, err := net.ListenTCP("tcp", &net.TCPAddr{IP: ip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := tl.Addr().(*net.TCPAddr)
+	tl.Close()
+	l, err := Listen(addr)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	go func() {
+		b := make([]byte, 1500)
+		for {
+			n, from, err := l.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			l.WriteTo(b[:n], from)
+		}
+	}()
+
+	// clients of a local ip share one raw socket, told apart by port
+	d := NewDialer(addr)
+	defer d.Close()
+	conns := make([]*Conn, 3)
+	for i := range conns {
+		if conns[i], err = d.Dial(&net.TCPAddr{IP: ip}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(muxes) != 1 || conns[0].mux.refs != len(conns) {
+		t.Fatalf("%v raw sockets for %v clients", len(muxes), len(conns))
+	}
+	for i, c := range conns {
+		msg := []byte{byte('a' + i)}
+		c.WriteTo(msg, nil)
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		b := make([]byte, 16)
+		n, _, err := c.ReadFrom(b)
+		if err != nil || string(b[:n]) != string(msg) {
+			t.Errorf("client %v: got %q %v", i, b[:n], err)
+		}
+	}
+	for _, c := range conns {
+		c.Close()
+	}
+	if len(muxes) != 0 {
+		t.Error("raw socket kept after clients closed")
+	}
+}
+
+func TestMaxFlows(t *testing.T) {
+	l, err := Listen(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	syn := func(i int) *net.TCPAddr {
+		from := &net.TCPAddr{IP: net.IPv4(127, 1, byte(i>>8), byte(i)), Port: 1000}
+		l.handle(&segment{seq: 1, flags: flagSYN}, from, nil)
+		return from
+	}
+	// spoofed SYNs are never followed by ACK
+	for i := 0; i < maxFlows; i++ {
+		syn(i)
+	}
+	if from := syn(maxFlows); l.flows[from.String()] != nil {
+		t.Fatal("flow added beyond limit")
+	}
+	if len(l.flows) != maxFlows {
+		t.Fatalf("%v flows", len(l.flows))
+	}
+
+	// half-open flows give way once SYN retries of client are over
+	for _, f := range l.flows {
+		f.lastSeen = f.lastSeen.Add(-2 * synRetry)
+	}
+	if from := syn(maxFlows); l.flows[from.String()] == nil {
+		t.Error("new flow refused after half-open ones expired")
+	}
+}
@@ -0,0 +1,189 @@
+package faketcp
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+type flow struct {
+	// next sequence number to send and to receive
+	seq, ack    uint32
+	established bool
+	lastSeen    time.Time
+	// ip client connects to
+	local net.IP
+}
+
+// Listener accepts faked connections of clients, every datagram read
+// is payload of a segment from a client
+type Listener struct {
+	raw   *rawConn
+	mu    sync.Mutex
+	flows map[string]*flow
+	clean time.Time
+	// reads are serialized, rBuffer is shared
+	rMu     sync.Mutex
+	rBuffer []byte
+}
+
+// Listen listens for faked connections on laddr
+func Listen(laddr *net.TCPAddr) (*Listener, error) {
+	if laddr.IP == nil {
+		laddr = &net.TCPAddr{IP: net.IPv4zero, Port: laddr.Port}
+	}
+	raw, err := listenRaw(laddr)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{
+		raw:     raw,
+		flows:   make(map[string]*flow),
+		rBuffer: make([]byte, maxSegment),
+	}, nil
+}
+
+// ReadFrom handles handshakes and reads payload of established flows,
+// address is that of client
+func (l *Listener) ReadFrom(b []byte) (int, net.Addr, error) {
+	l.rMu.Lock()
+	defer l.rMu.Unlock()
+	for {
+		s, from, err := l.raw.recv(l.rBuffer)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n, ok := l.handle(s, from, b); ok {
+			return n, udpAddr(from), nil
+		}
+	}
+}
+
+// handle updates flow by segment, true if payload is copied to b
+func (l *Listener) handle(s *segment, from *net.TCPAddr, b []byte) (int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.After(l.clean) {
+		for k, f := range l.flows {
+			if now.Sub(f.lastSeen) > flowTimeout {
+				delete(l.flows, k)
+			}
+		}
+		l.clean = now.Add(flowTimeout)
+	}
+
+	key := from.String()
+	f, exist := l.flows[key]
+	switch {
+	case s.flags&flagRST != 0:
+		// kernel of client may answer SYN-ACK by RST
+		return 0, false
+	case s.flags&flagSYN != 0 && s.flags&flagACK == 0:
+		// SYN sent again keeps sequence number of flow
+		if !exist || f.established || f.ack != s.seq+1 {
+			if !exist && !l.admit(now) {
+				return 0, false
+			}
+			f = &flow{seq: randUint32(), ack: s.seq + 1, local: l.raw.local.IP}
+			if f.local.IsUnspecified() {
+				// kernel chooses source by route
+				ip, err := localIP(from)
+				if err != nil {
+					return 0, false
+				}
+				f.local = ip
+			}
+			l.flows[key] = f
+		}
+		f.lastSeen = now
+		l.raw.send(&segment{seq: f.seq, ack: f.ack, flags: flagSYN | flagACK}, f.local, from)
+		return 0, false
+	case !exist:
+		return 0, false
+	case s.flags&flagFIN != 0:
+		delete(l.flows, key)
+		return 0, false
+	case s.flags&flagACK == 0:
+		return 0, false
+	}
+
+	if !f.established {
+		// data of client may come before the last ACK of handshake
+		if s.ack != f.seq+1 {
+			return 0, false
+		}
+		f.seq++
+		f.established = true
+	}
+	f.lastSeen = now
+	if len(s.payload) == 0 {
+		return 0, false
+	}
+	if end := s.seq + uint32(len(s.payload)); int32(end-f.ack) > 0 {
+		f.ack = end
+	}
+	return copy(b, s.payload), true
+}
+
+// admit tells whether a flow can be added. When flows are full, those
+// never established after SYN retries of client are dropped first, so
+// spoofed SYNs can't take all.
+func (l *Listener) admit(now time.Time) bool {
+	if len(l.flows) < maxFlows {
+		return true
+	}
+	for k, f := range l.flows {
+		if !f.established && now.Sub(f.lastSeen) > synRetry {
+			delete(l.flows, k)
+		}
+	}
+	return len(l.flows) < maxFlows
+}
+
+// WriteTo writes b as a segment to client at addr
+func (l *Listener) WriteTo(b []byte, addr net.Addr) (int, error) {
+	var to *net.TCPAddr
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		to = &net.TCPAddr{IP: a.IP, Port: a.Port, Zone: a.Zone}
+	case *net.TCPAddr:
+		to = a
+	default:
+		return 0, ErrNoFlow
+	}
+	l.mu.Lock()
+	f, exist := l.flows[to.String()]
+	if !exist || !f.established {
+		l.mu.Unlock()
+		return 0, ErrNoFlow
+	}
+	s := &segment{seq: f.seq, ack: f.ack, flags: flagPSH | flagACK, payload: b}
+	f.seq += uint32(len(b))
+	src := f.local
+	l.mu.Unlock()
+	if err := l.raw.send(s, src, to); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (l *Listener) Close() error {
+	return l.raw.Close()
+}
+
+func (l *Listener) LocalAddr() net.Addr {
+	return udpAddr(l.raw.local)
+}
+
+func (l *Listener) SetDeadline(t time.Time) error {
+	return l.raw.SetDeadline(t)
+}
+
+func (l *Listener) SetReadDeadline(t time.Time) error {
+	return l.raw.SetReadDeadline(t)
+}
+
+func (l *Listener) SetWriteDeadline(t time.Time) error {
+	return l.raw.SetWriteDeadline(t)
+}
@@ -0,0 +1,106 @@
+package faketcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+/*
+ 0                   1                   2                   3
+ 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|          Source Port          |       Destination Port        |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|                        Sequence Number                        |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|                    Acknowledgment Number                      |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|  Data |           |U|A|P|R|S|F|                               |
+| Offset| Reserved  |R|C|S|S|Y|I|            Window             |
+|       |           |G|K|H|T|N|N|                               |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|           Checksum            |         Urgent Pointer        |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+|                             data                              |
++-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+ */
+
+const (
+	flagFIN = byte(0x01)
+	flagSYN = byte(0x02)
+	flagRST = byte(0x04)
+	flagPSH = byte(0x08)
+	flagACK = byte(0x10)
+
+	headerSize = 20
+	window     = 65535
+)
+
+var errBadSegment = errors.New("bad tcp segment")
+
+type segment struct {
+	srcPort, dstPort int
+	seq, ack         uint32
+	flags            byte
+	payload          []byte
+}
+
+// marshal builds segment from src to dst with checksum
+func (s *segment) marshal(src, dst net.IP) []byte {
+	b := make([]byte, headerSize, headerSize+len(s.payload))
+	binary.BigEndian.PutUint16(b, uint16(s.srcPort))
+	binary.BigEndian.PutUint16(b[2:], uint16(s.dstPort))
+	binary.BigEndian.PutUint32(b[4:], s.seq)
+	binary.BigEndian.PutUint32(b[8:], s.ack)
+	b[12] = headerSize / 4 << 4
+	b[13] = s.flags
+	binary.BigEndian.PutUint16(b[14:], window)
+	b = append(b, s.payload...)
+	binary.BigEndian.PutUint16(b[16:], checksum(src, dst, b))
+	return b
+}
+
+func parseSegment(b []byte) (*segment, error) {
+	if len(b) < headerSize {
+		return nil, errBadSegment
+	}
+	off := int(b[12]>>4) * 4
+	if off < headerSize || off > len(b) {
+		return nil, errBadSegment
+	}
+	return &segment{
+		srcPort: int(binary.BigEndian.Uint16(b)),
+		dstPort: int(binary.BigEndian.Uint16(b[2:])),
+		seq:     binary.BigEndian.Uint32(b[4:]),
+		ack:     binary.BigEndian.Uint32(b[8:]),
+		flags:   b[13],
+		payload: b[off:],
+	}, nil
+}
+
+// checksum of tcp over pseudo header of ipv4 or ipv6
+func checksum(src, dst net.IP, b []byte) uint16 {
+	var sum uint32
+	if src.To4() != nil {
+		sum = sum16(src.To4()) + sum16(dst.To4())
+	} else {
+		sum = sum16(src.To16()) + sum16(dst.To16())
+	}
+	sum += 6 + uint32(len(b))
+	sum += sum16(b)
+	for sum>>16 != 0 {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+func sum16(b []byte) uint32 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
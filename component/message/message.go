@@ -24,6 +24,8 @@ type Metadata struct {
 	ProcessPath string
 	// take from ingress
 	Ingress string
+	// user authenticated by ingress
+	User string
 }
 
 func NewMetadata() *Metadata {
@@ -61,6 +63,10 @@ func (m *Metadata) WithIngress(d string) *Metadata {
     m.Ingress = d
     return m
 }
+func (m *Metadata) WithUser(d string) *Metadata {
+    m.User = d
+    return m
+}
 
 type Message interface {
     Payload() []byte
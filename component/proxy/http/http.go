@@ -0,0 +1,102 @@
+package http
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/intxff/rdcross/component/conn"
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+var (
+	ErrAuthFailed    error = errors.New("auth failed")
+	ErrBadRequest    error = errors.New("bad request")
+	ErrConnectFailed error = errors.New("connect failed")
+)
+
+type HTTP struct {
+	mode int
+	// server mode, users allowed
+	users *userList
+	// client mode, credential to authenticate with
+	username string
+	password string
+}
+
+type userList struct {
+	mu    sync.RWMutex
+	users map[string]string
+}
+
+func NewProxyHTTP(mode int) *HTTP {
+	return &HTTP{
+		mode:  mode,
+		users: &userList{users: make(map[string]string)},
+	}
+}
+
+// AddUser adds or replaces user of server, clients have to authenticate
+// by basic auth once any user is added
+func (h *HTTP) AddUser(name, password string) {
+	h.users.mu.Lock()
+	defer h.users.mu.Unlock()
+	h.users.users[name] = password
+}
+
+// RemoveUser deletes credential of name, requests authorized by it are
+// answered with 407 from then on
+func (h *HTTP) RemoveUser(name string) bool {
+	h.users.mu.Lock()
+	defer h.users.mu.Unlock()
+	if _, exist := h.users.users[name]; !exist {
+		return false
+	}
+	delete(h.users.users, name)
+	return true
+}
+
+// WithCredential sets username and password client authenticates with
+func (h *HTTP) WithCredential(username, password string) *HTTP {
+	h.username, h.password = username, password
+	return h
+}
+
+func (h *HTTP) needAuth() bool {
+	h.users.mu.RLock()
+	defer h.users.mu.RUnlock()
+	return len(h.users.users) != 0
+}
+
+func (h *HTTP) verify(name, password string) bool {
+	h.users.mu.RLock()
+	defer h.users.mu.RUnlock()
+	p, exist := h.users.users[name]
+	return exist && p == password
+}
+
+func (h *HTTP) Cipher() proxy.Cipher {
+	return nil
+}
+
+func (h *HTTP) ShadowStreamConn(c net.Conn, extra ...any) (conn.ProxyStreamConn, error) {
+	if h.mode == proxy.ModeServer {
+		m := message.NewMetadata().WithIngress(extra[0].(string))
+		return NewStreamHTTP(*h, c, m)
+	}
+	return NewStreamHTTP(*h, c, extra[0].(*message.Metadata))
+}
+
+// ShadowPacketConn is not supported, http proxy carries tcp only
+func (h *HTTP) ShadowPacketConn(c net.PacketConn, extra ...any) (conn.ProxyPacketConn, error) {
+	return nil, proxy.ErrNotSupported
+}
+
+func (h *HTTP) Type() proxy.ProxyType {
+	return proxy.TypeHTTP
+}
+
+func (h *HTTP) TcpMux() bool {
+	return false
+}
@@ -0,0 +1,139 @@
+package http
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	nethttp "net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+// pair gets both ends of a tcp connection
+func pair(t *testing.T) (client, server net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if client, err = net.Dial("tcp", l.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	if server, err = l.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func basic(user, password string) string {
+	return "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password)) + "\r\n"
+}
+
+func TestServerHandShake(t *testing.T) {
+	cases := []struct {
+		name    string
+		users   bool
+		request string
+		// remote expected, empty if refused with status
+		remote string
+		user   string
+		status int
+		// request forwarded in origin form
+		forward string
+	}{
+		{name: "connect domain", request: "CONNECT example.org:8443 HTTP/1.1\r\nHost: example.org:8443\r\n\r\n",
+			remote: "example.org:8443"},
+		{name: "connect ipv6", request: "CONNECT [::1]:8443 HTTP/1.1\r\nHost: [::1]:8443\r\n\r\n",
+			remote: "[::1]:8443"},
+		{name: "connect ipv6 no port", request: "CONNECT [::1] HTTP/1.1\r\nHost: [::1]\r\n\r\n",
+			remote: "[::1]:443"},
+		{name: "absolute uri", request: "GET http://example.org/index.html HTTP/1.1\r\nHost: example.org\r\nProxy-Connection: keep-alive\r\n\r\n",
+			remote: "example.org:80", forward: "GET /index.html HTTP/1.1"},
+		{name: "absolute uri ipv6", request: "GET http://[2001:db8::1]/ HTTP/1.1\r\nHost: [2001:db8::1]\r\n\r\n",
+			remote: "[2001:db8::1]:80", forward: "GET / HTTP/1.1"},
+		{name: "connect auth", users: true,
+			request: "CONNECT example.org:443 HTTP/1.1\r\nHost: example.org:443\r\n" + basic("alice", "secret") + "\r\n",
+			remote:  "example.org:443", user: "alice"},
+		{name: "absolute uri auth", users: true,
+			request: "GET http://example.org:8080/ HTTP/1.1\r\nHost: example.org:8080\r\n" + basic("alice", "secret") + "\r\n",
+			remote:  "example.org:8080", user: "alice", forward: "GET / HTTP/1.1"},
+		{name: "wrong password", users: true,
+			request: "CONNECT example.org:443 HTTP/1.1\r\nHost: example.org:443\r\n" + basic("alice", "wrong") + "\r\n",
+			status:  nethttp.StatusProxyAuthRequired},
+		{name: "no auth", users: true,
+			request: "GET http://example.org/ HTTP/1.1\r\nHost: example.org\r\n\r\n",
+			status:  nethttp.StatusProxyAuthRequired},
+		{name: "origin form", request: "GET / HTTP/1.1\r\nHost: example.org\r\n\r\n",
+			status: nethttp.StatusBadRequest},
+	}
+	for _, c := range cases {
+		server := NewProxyHTTP(proxy.ModeServer)
+		if c.users {
+			server.AddUser("alice", "secret")
+		}
+		cc, sc := pair(t)
+		io.WriteString(cc, c.request)
+		s, err := server.ShadowStreamConn(sc, "in")
+		if c.remote == "" {
+			resp, _ := nethttp.ReadResponse(bufio.NewReader(cc), nil)
+			if err == nil || resp == nil || resp.StatusCode != c.status {
+				t.Errorf("%v: %v %v", c.name, resp, err)
+			}
+			cc.Close()
+			sc.Close()
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", c.name, err)
+			cc.Close()
+			continue
+		}
+		m := s.Metadata()
+		host := m.Domain
+		if host == "" {
+			host = m.RemoteIP.String()
+		}
+		if remote := net.JoinHostPort(host, strconv.Itoa(m.RemotePort)); remote != c.remote || m.User != c.user {
+			t.Errorf("%v: remote %v user %q", c.name, remote, m.User)
+		}
+		if c.forward == "" {
+			resp, err := nethttp.ReadResponse(bufio.NewReader(cc), nil)
+			if err != nil || resp.StatusCode != nethttp.StatusOK {
+				t.Errorf("%v: connect %v %v", c.name, resp, err)
+			}
+		} else {
+			line, err := bufio.NewReader(s).ReadString('\n')
+			if err != nil || strings.TrimSpace(line) != c.forward {
+				t.Errorf("%v: forwarded %q %v", c.name, line, err)
+			}
+		}
+		s.Close()
+		cc.Close()
+	}
+}
+
+func TestClientHandShake(t *testing.T) {
+	for _, password := range []string{"secret", "wrong"} {
+		server := NewProxyHTTP(proxy.ModeServer)
+		server.AddUser("alice", "secret")
+		client := NewProxyHTTP(proxy.ModeClient).WithCredential("alice", password)
+		cc, sc := pair(t)
+		done := make(chan error, 1)
+		go func() {
+			_, err := server.ShadowStreamConn(sc, "in")
+			done <- err
+		}()
+		m := message.NewMetadata().WithRemoteIP(net.ParseIP("2001:db8::1")).WithRemotePort(443)
+		_, err := client.ShadowStreamConn(cc, m)
+		if serr := <-done; (err == nil) != (password == "secret") || (serr == nil) != (err == nil) {
+			t.Errorf("%v: client %v server %v", password, err, serr)
+		}
+		cc.Close()
+		sc.Close()
+	}
+}
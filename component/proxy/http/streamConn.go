@@ -0,0 +1,226 @@
+package http
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+// headers meaningful between client and proxy only
+var hopHeaders = []string{
+	"Proxy-Connection",
+	"Proxy-Authorization",
+	"Proxy-Authenticate",
+	"Keep-Alive",
+}
+
+type StreamHTTP struct {
+	HTTP
+	net.Conn
+	metadata *message.Metadata
+	reader   *bufio.Reader
+	// server mode of plain http, requests rewritten to origin form
+	forward *io.PipeReader
+}
+
+func NewStreamHTTP(h HTTP, c net.Conn, m *message.Metadata) (*StreamHTTP, error) {
+	sh := &StreamHTTP{
+		HTTP:     h,
+		Conn:     c,
+		metadata: m,
+		reader:   bufio.NewReader(c),
+	}
+	var err error
+	if h.mode == proxy.ModeServer {
+		err = sh.serverHandShake()
+	} else {
+		err = sh.clientHandShake()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sh, nil
+}
+
+func (s *StreamHTTP) Metadata() *message.Metadata {
+	return s.metadata
+}
+
+func (s *StreamHTTP) serverHandShake() error {
+	req, err := nethttp.ReadRequest(s.reader)
+	if err != nil {
+		return err
+	}
+	user, err := s.authenticate(req)
+	if err != nil {
+		return err
+	}
+
+	host := req.Host
+	if req.Method != nethttp.MethodConnect {
+		if req.URL.Host == "" {
+			s.reply(nethttp.StatusBadRequest, nil)
+			return ErrBadRequest
+		}
+		host = req.URL.Host
+	}
+	port := "80"
+	if req.Method == nethttp.MethodConnect || req.URL.Scheme == "https" {
+		port = "443"
+	}
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		// ipv6 literal without port
+		host = host[1 : len(host)-1]
+	}
+	nPort, err := strconv.Atoi(port)
+	if err != nil {
+		s.reply(nethttp.StatusBadRequest, nil)
+		return ErrBadRequest
+	}
+
+	cAddr := s.RemoteAddr().(*net.TCPAddr)
+	m := message.NewMetadata().WithClientIP(cAddr.IP).
+		WithClientPort(cAddr.Port).
+		WithRemotePort(nPort).
+		WithIngress(s.metadata.Ingress).
+		WithUser(user)
+	if ip := net.ParseIP(host); ip != nil {
+		m.WithRemoteIP(ip)
+	} else {
+		m.WithDomain(host)
+	}
+	s.metadata = m
+
+	if req.Method == nethttp.MethodConnect {
+		_, err := io.WriteString(s.Conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		return err
+	}
+	pr, pw := io.Pipe()
+	s.forward = pr
+	go s.forwardRequests(req, req.URL.Host, pw)
+	return nil
+}
+
+// authenticate checks basic auth in Proxy-Authorization, returns user
+func (s *StreamHTTP) authenticate(req *nethttp.Request) (string, error) {
+	if !s.needAuth() {
+		return "", nil
+	}
+	auth := req.Header.Get("Proxy-Authorization")
+	const prefix = "Basic "
+	if len(auth) > len(prefix) && strings.EqualFold(auth[:len(prefix)], prefix) {
+		if b, err := base64.StdEncoding.DecodeString(auth[len(prefix):]); err == nil {
+			name, password, ok := strings.Cut(string(b), ":")
+			if ok && s.verify(name, password) {
+				return name, nil
+			}
+		}
+	}
+	s.reply(nethttp.StatusProxyAuthRequired, nethttp.Header{
+		"Proxy-Authenticate": {`Basic realm="rdcross"`},
+	})
+	return "", ErrAuthFailed
+}
+
+func (s *StreamHTTP) reply(code int, header nethttp.Header) {
+	resp := &nethttp.Response{
+		StatusCode: code,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Close:      true,
+	}
+	resp.Write(s.Conn)
+}
+
+// forwardRequests rewrites requests of the connection to origin form
+// until one is for another host, which ends the connection
+func (s *StreamHTTP) forwardRequests(req *nethttp.Request, host string, pw *io.PipeWriter) {
+	for {
+		for _, h := range hopHeaders {
+			req.Header.Del(h)
+		}
+		// no default user agent added
+		if _, exist := req.Header["User-Agent"]; !exist {
+			req.Header.Set("User-Agent", "")
+		}
+		if err := req.Write(pw); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		var err error
+		if req, err = nethttp.ReadRequest(s.reader); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if req.URL.Host != host {
+			pw.Close()
+			return
+		}
+	}
+}
+
+func (s *StreamHTTP) clientHandShake() error {
+	host := s.metadata.Domain
+	if host == "" {
+		host = s.metadata.RemoteIP.String()
+	}
+	host = net.JoinHostPort(host, strconv.Itoa(s.metadata.RemotePort))
+	req := &nethttp.Request{
+		Method: nethttp.MethodConnect,
+		URL:    &url.URL{Opaque: host},
+		Host:   host,
+		Header: make(nethttp.Header),
+	}
+	if s.username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(s.username + ":" + s.password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	req.Header.Set("User-Agent", "")
+	if err := req.Write(s.Conn); err != nil {
+		return err
+	}
+	resp, err := nethttp.ReadResponse(s.reader, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != nethttp.StatusOK {
+		return fmt.Errorf("%w: %v", ErrConnectFailed, resp.Status)
+	}
+	return nil
+}
+
+func (s *StreamHTTP) ReadMux() (message.Message, error) {
+	return nil, proxy.ErrNotSupported
+}
+
+func (s *StreamHTTP) WriteMux(msg message.Message) error {
+	return proxy.ErrNotSupported
+}
+
+func (s *StreamHTTP) Read(b []byte) (int, error) {
+	if s.forward != nil {
+		return s.forward.Read(b)
+	}
+	return s.reader.Read(b)
+}
+
+func (s *StreamHTTP) Close() error {
+	if s.forward != nil {
+		s.forward.Close()
+	}
+	return s.Conn.Close()
+}
@@ -0,0 +1,78 @@
+package mixed
+
+import (
+	"bufio"
+	"net"
+
+	"github.com/intxff/rdcross/component/conn"
+	"github.com/intxff/rdcross/component/proxy"
+	"github.com/intxff/rdcross/component/proxy/http"
+	"github.com/intxff/rdcross/component/proxy/socks"
+)
+
+// Mixed serves socks5, socks4/4a and http on one port, protocol is told
+// by the first byte of connection
+type Mixed struct {
+	socks *socks.Socks
+	http  *http.HTTP
+}
+
+// NewProxyMixed creates mixed proxy, which is server mode only
+func NewProxyMixed() *Mixed {
+	return &Mixed{
+		socks: socks.NewProxySocks(proxy.ModeServer),
+		http:  http.NewProxyHTTP(proxy.ModeServer),
+	}
+}
+
+// AddUser adds user to both socks and http
+func (m *Mixed) AddUser(name, password string) {
+	m.socks.AddUser(name, password)
+	m.http.AddUser(name, password)
+}
+
+// RemoveUser revokes user of both socks and http
+func (m *Mixed) RemoveUser(name string) bool {
+	ok := m.socks.RemoveUser(name)
+	return m.http.RemoveUser(name) || ok
+}
+
+func (m *Mixed) Cipher() proxy.Cipher {
+	return nil
+}
+
+func (m *Mixed) ShadowStreamConn(c net.Conn, extra ...any) (conn.ProxyStreamConn, error) {
+	pc := &peekConn{Conn: c, r: bufio.NewReader(c)}
+	b, err := pc.r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch b[0] {
+	case socks.Ver, socks.Ver4:
+		return m.socks.ShadowStreamConn(pc, extra...)
+	default:
+		return m.http.ShadowStreamConn(pc, extra...)
+	}
+}
+
+func (m *Mixed) ShadowPacketConn(c net.PacketConn, extra ...any) (conn.ProxyPacketConn, error) {
+	return m.socks.ShadowPacketConn(c, extra...)
+}
+
+func (m *Mixed) Type() proxy.ProxyType {
+	return proxy.TypeMixed
+}
+
+func (m *Mixed) TcpMux() bool {
+	return false
+}
+
+// peekConn reads through buffer holding the peeked byte
+type peekConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (p *peekConn) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
@@ -0,0 +1,62 @@
+package mixed
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/intxff/rdcross/component/proxy/socks"
+)
+
+func TestDispatch(t *testing.T) {
+	cases := []struct {
+		name    string
+		request string
+		// length of reply to read
+		reply int
+		remote string
+	}{
+		{"socks5", string([]byte{socks.Ver, 1, 0, socks.Ver, socks.CmdConnect, 0, socks.AtypIPv4, 192, 0, 2, 1, 0, 80}),
+			2 + 10, "192.0.2.1"},
+		{"socks4a", string([]byte{socks.Ver4, socks.CmdConnect, 0, 80, 0, 0, 0, 1, 0}) + "example.org\x00",
+			8, "example.org"},
+		{"http", "CONNECT example.org:80 HTTP/1.1\r\nHost: example.org:80\r\n\r\n",
+			len("HTTP/1.1 200 Connection established\r\n\r\n"), "example.org"},
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	m := NewProxyMixed()
+	for _, c := range cases {
+		cc, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc, _ := l.Accept()
+		// the first byte peeked is still read by handshake
+		io.WriteString(cc, c.request+"hello")
+		s, err := m.ShadowStreamConn(sc, "mixed")
+		if err != nil {
+			t.Fatalf("%v: %v", c.name, err)
+		}
+		if _, err := io.ReadFull(cc, make([]byte, c.reply)); err != nil {
+			t.Fatalf("%v: reply %v", c.name, err)
+		}
+		md := s.Metadata()
+		remote := md.Domain
+		if remote == "" {
+			remote = md.RemoteIP.String()
+		}
+		if remote != c.remote || md.RemotePort != 80 {
+			t.Errorf("%v: remote %v:%v", c.name, remote, md.RemotePort)
+		}
+		data := make([]byte, 5)
+		if _, err := io.ReadFull(s, data); err != nil || string(data) != "hello" {
+			t.Errorf("%v: data %q %v", c.name, data, err)
+		}
+		cc.Close()
+		s.Close()
+	}
+}
@@ -13,6 +13,11 @@ const (
 	TypeNone        ProxyType = "NONE"
 	TypeShadowsocks ProxyType = "SHADOWSOCKS"
 	TypeSocks       ProxyType = "SOCKS"
+	TypeHTTP        ProxyType = "HTTP"
+	TypeMixed       ProxyType = "MIXED"
+	TypeTrojan      ProxyType = "TROJAN"
+	TypeVMess       ProxyType = "VMESS"
+	TypeVLESS       ProxyType = "VLESS"
 )
 
 const (
@@ -45,3 +50,14 @@ type Proxy interface {
 	// tcpmux implys whether many different msgs within a connection
 	TcpMux() bool
 }
+
+// PacketOverStream is implemented by proxies carrying udp packets in a
+// stream connection, e.g. udp associate of trojan
+type PacketOverStream interface {
+	StreamPacketConn(c net.Conn, extra ...any) (conn.ProxyPacketConn, error)
+}
+
+// UserRemover is implemented by servers revoking users at runtime
+type UserRemover interface {
+	RemoveUser(name string) bool
+}
@@ -0,0 +1,145 @@
+package shadowsocks
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/intxff/rdcross/component/proxy"
+	"golang.org/x/crypto/chacha20poly1305"
+	"lukechampine.com/blake3"
+)
+
+const (
+	aead2022Aes128Gcm        = "2022-BLAKE3-AES-128-GCM"
+	aead2022Aes256Gcm        = "2022-BLAKE3-AES-256-GCM"
+	aead2022Chacha20Poly1305 = "2022-BLAKE3-CHACHA20-POLY1305"
+)
+
+// List of AEAD-2022 ciphers: key size in bytes and constructor
+var aead2022List = map[string]struct {
+	KeySize int
+	New     func([]byte) (cipher.AEAD, error)
+}{
+	aead2022Aes128Gcm:        {16, aesGCM},
+	aead2022Aes256Gcm:        {32, aesGCM},
+	aead2022Chacha20Poly1305: {32, chacha20poly1305.New},
+}
+
+func is2022(cipher string) bool {
+	_, ok := aead2022List[strings.ToUpper(cipher)]
+	return ok
+}
+
+// blake3Cipher derives session subkey by BLAKE3 instead of HKDF_SHA1
+type blake3Cipher struct {
+	psk     []byte
+	creator func([]byte) (cipher.AEAD, error)
+	// udp packets of chacha20 are sealed by psk directly
+	chacha  bool
+	xchacha cipher.AEAD
+	// separate header of udp packets
+	block cipher.Block
+	// identity of psk in extensible identity headers
+	hash [16]byte
+	// client mode, identity psks of servers on the way to the one
+	// holding psk
+	identities []*blake3Cipher
+}
+
+// newBlake3Cipher takes psk in base64 from key, or from password if
+// key is empty. Psk like iPSK:uPSK gets identity headers of SIP023.
+func newBlake3Cipher(password, key []byte, name string) (*blake3Cipher, []byte, error) {
+	name = strings.ToUpper(name)
+	choice, ok := aead2022List[name]
+	if !ok {
+		return nil, nil, ErrCipherNotSupported
+	}
+	if len(key) == 0 {
+		key = password
+	}
+
+	psks := strings.Split(string(key), ":")
+	ciphers := make([]*blake3Cipher, 0, len(psks))
+	chacha := name == aead2022Chacha20Poly1305
+	if chacha && len(psks) > 1 {
+		return nil, nil, fmt.Errorf("%v has no identity header", name)
+	}
+	for _, v := range psks {
+		psk, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("psk of %v must be base64: %w", name, err)
+		}
+		if len(psk) != choice.KeySize {
+			return nil, nil, fmt.Errorf("key size error: need %v byte", choice.KeySize)
+		}
+		c := &blake3Cipher{
+			psk:     psk,
+			creator: choice.New,
+			chacha:  chacha,
+		}
+		hash := blake3.Sum256(psk)
+		copy(c.hash[:], hash[:])
+		if chacha {
+			c.xchacha, err = chacha20poly1305.NewX(psk)
+		} else {
+			c.block, err = aes.NewCipher(psk)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		ciphers = append(ciphers, c)
+	}
+	c := ciphers[len(ciphers)-1]
+	c.identities = ciphers[:len(ciphers)-1]
+	return c, c.psk, nil
+}
+
+func (b *blake3Cipher) subkey(salt []byte) []byte {
+	material := make([]byte, 0, len(b.psk)+len(salt))
+	material = append(append(material, b.psk...), salt...)
+	subkey := make([]byte, len(b.psk))
+	blake3.DeriveKey(subkey, "shadowsocks 2022 session subkey", material)
+	return subkey
+}
+
+func (b *blake3Cipher) aead(salt []byte) (cipher.AEAD, error) {
+	return b.creator(b.subkey(salt))
+}
+
+// identityBlock gets cipher of identity header in tcp request
+func (b *blake3Cipher) identityBlock(salt []byte) (cipher.Block, error) {
+	material := make([]byte, 0, len(b.psk)+len(salt))
+	material = append(append(material, b.psk...), salt...)
+	subkey := make([]byte, len(b.psk))
+	blake3.DeriveKey(subkey, "shadowsocks 2022 identity subkey", material)
+	return aes.NewCipher(subkey)
+}
+
+// next gets psk identity headers are for, the one after b in chain
+func (b *blake3Cipher) next(i int) *blake3Cipher {
+	if i+1 < len(b.identities) {
+		return b.identities[i+1]
+	}
+	return b
+}
+
+// implement Cipher.Encrypter
+func (b *blake3Cipher) Encrypter(extra ...any) (proxy.Encrypter, error) {
+	aead, err := b.aead(extra[0].([]byte))
+	if err != nil {
+		return nil, err
+	}
+	return &aeadEncryter{nonce: make([]byte, aead.NonceSize()), AEAD: aead}, nil
+}
+
+// implement Cipher.Decrypter
+func (b *blake3Cipher) Decrypter(extra ...any) (proxy.Decrypter, error) {
+	aead, err := b.aead(extra[0].([]byte))
+	if err != nil {
+		return nil, err
+	}
+	return &aeadDecryter{nonce: make([]byte, aead.NonceSize()), AEAD: aead}, nil
+}
@@ -0,0 +1,331 @@
+package shadowsocks
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// sessions idle for so long are dropped
+const sessionTimeout = 5 * time.Minute
+
+var errNoSession = errors.New("no udp session")
+
+type udpSession struct {
+	id uint64
+	// next packet id to send
+	packetID uint64
+	// aead of AES ciphers, derived by session id
+	aead     cipher.AEAD
+	window   slidingWindow
+	lastSeen time.Time
+	// server mode, own session answering this client session
+	reply *udpSession
+	// psk of the session and user it belongs to
+	c    *blake3Cipher
+	user string
+}
+
+// PacketShadowsocks2022 is udp of AEAD-2022 ciphers. A client holds
+// one session of its own, a server holds one per client session.
+type PacketShadowsocks2022 struct {
+	Shadowsocks
+	net.PacketConn
+	b3 *blake3Cipher
+
+	mu sync.Mutex
+	// client mode
+	local *udpSession
+	// sessions of peers by session id
+	remote map[uint64]*udpSession
+	// server mode, client sessions by address
+	addrs map[string]*udpSession
+	clean time.Time
+
+	rBuffer []byte
+}
+
+func newPacketShadowsocks2022(s Shadowsocks, c net.PacketConn) (*PacketShadowsocks2022, error) {
+	p := &PacketShadowsocks2022{
+		Shadowsocks: s,
+		PacketConn:  c,
+		b3:          s.cipher.(*blake3Cipher),
+		remote:      make(map[uint64]*udpSession),
+		addrs:       make(map[string]*udpSession),
+		rBuffer:     make([]byte, _PayloadMaxSize2022),
+	}
+	if s.mode == proxy.ModeClient {
+		local, err := p.newSession(randUint64(), p.b3, "")
+		if err != nil {
+			return nil, err
+		}
+		p.local = local
+	}
+	return p, nil
+}
+
+func randUint64() uint64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint64(b[:])
+}
+
+func (p *PacketShadowsocks2022) newSession(id uint64, c *blake3Cipher, user string) (*udpSession, error) {
+	s := &udpSession{id: id, lastSeen: time.Now(), c: c, user: user}
+	if !c.chacha {
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], id)
+		aead, err := c.aead(b[:])
+		if err != nil {
+			return nil, err
+		}
+		s.aead = aead
+	}
+	return s, nil
+}
+
+func (p *PacketShadowsocks2022) Metadata() *message.Metadata {
+	return nil
+}
+
+// open decrypts packet, returns session id, packet id and body
+func (p *PacketShadowsocks2022) open(b []byte) (*udpSession, uint64, []byte, error) {
+	if p.b3.chacha {
+		return p.openChacha(b)
+	}
+	if len(b) < 16+16 {
+		return nil, 0, nil, errBadHeader
+	}
+	header, body := b[:16], b[16:]
+	p.b3.block.Decrypt(header, header)
+	sid, pid := binary.BigEndian.Uint64(header), binary.BigEndian.Uint64(header[8:])
+
+	c, user := p.b3, ""
+	if p.mode == proxy.ModeServer && p.eih() {
+		// hash of user psk xor plain separate header
+		if len(body) < 16+16 {
+			return nil, 0, nil, errBadHeader
+		}
+		eih := body[:16]
+		p.b3.block.Decrypt(eih, eih)
+		for i := range eih {
+			eih[i] ^= header[i]
+		}
+		u, err := p.identify(eih)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		c, user, body = u.cipher.(*blake3Cipher), u.name, body[16:]
+	}
+	sess, err := p.session(sid, c, user)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if body, err = sess.aead.Open(body[:0], header[4:16], body, nil); err != nil {
+		return nil, 0, nil, err
+	}
+	return sess, pid, body, nil
+}
+
+// openChacha decrypts packet sealed by psk, psk of every user is tried
+// in server mode
+func (p *PacketShadowsocks2022) openChacha(b []byte) (*udpSession, uint64, []byte, error) {
+	nonceSize := chacha20poly1305.NonceSizeX
+	if len(b) < nonceSize+16+16 {
+		return nil, 0, nil, errBadHeader
+	}
+	candidates := []*ssUser{{cipher: p.b3}}
+	if p.mode == proxy.ModeServer && p.multiUser() {
+		candidates = p.candidates()
+	}
+	plain := make([]byte, len(b)-nonceSize-16)
+	for _, u := range candidates {
+		c := u.cipher.(*blake3Cipher)
+		body, err := c.xchacha.Open(plain[:0], b[:nonceSize], b[nonceSize:], nil)
+		if err != nil {
+			continue
+		}
+		sid, pid := binary.BigEndian.Uint64(body), binary.BigEndian.Uint64(body[8:])
+		sess, err := p.session(sid, c, u.name)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		return sess, pid, body[16:], nil
+	}
+	return nil, 0, nil, errNoUser
+}
+
+// session gets session of peer, a new one is not kept until packet is
+// authenticated
+func (p *PacketShadowsocks2022) session(id uint64, c *blake3Cipher, user string) (*udpSession, error) {
+	p.mu.Lock()
+	s, exist := p.remote[id]
+	p.mu.Unlock()
+	if exist {
+		// session of another user
+		if s.c != c {
+			return nil, errBadHeader
+		}
+		return s, nil
+	}
+	return p.newSession(id, c, user)
+}
+
+func (p *PacketShadowsocks2022) ReadMsgFrom() (message.Message, net.Addr, error) {
+	n, rAddr, err := p.ReadFrom(p.rBuffer)
+	if err != nil {
+		return nil, rAddr, err
+	}
+	sess, pid, body, err := p.open(p.rBuffer[:n])
+	if err != nil {
+		return nil, rAddr, err
+	}
+
+	// type, timestamp, [client session id], padding length
+	want, l := byte(headerTypeClient), 1+8+2
+	if p.mode == proxy.ModeClient {
+		want, l = headerTypeServer, 1+8+8+2
+	}
+	if len(body) < l || body[0] != want {
+		return nil, rAddr, errBadHeader
+	}
+	if err := checkTimestamp(binary.BigEndian.Uint64(body[1:])); err != nil {
+		return nil, rAddr, err
+	}
+	if p.mode == proxy.ModeClient && binary.BigEndian.Uint64(body[9:]) != p.local.id {
+		return nil, rAddr, errBadHeader
+	}
+	l += int(binary.BigEndian.Uint16(body[l-2:]))
+	if len(body) < l {
+		return nil, rAddr, errBadHeader
+	}
+	m := &udpMsg{metadata: message.NewMetadata()}
+	na, err := parseAddr(body[l:], m.metadata)
+	if err != nil {
+		return nil, rAddr, err
+	}
+	m.payload = append([]byte(nil), body[l+na:]...)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !sess.window.check(pid) {
+		return nil, rAddr, errReplay
+	}
+	now := time.Now()
+	sess.lastSeen = now
+	p.remote[sess.id] = sess
+	if p.mode == proxy.ModeServer {
+		p.addrs[rAddr.String()] = sess
+		m.metadata.WithClientIP(rAddr.(*net.UDPAddr).IP).
+			WithClientPort(rAddr.(*net.UDPAddr).Port).
+			WithUser(sess.user)
+	}
+	if now.After(p.clean) {
+		for k, v := range p.remote {
+			if now.Sub(v.lastSeen) > sessionTimeout {
+				delete(p.remote, k)
+			}
+		}
+		for k, v := range p.addrs {
+			if now.Sub(v.lastSeen) > sessionTimeout {
+				delete(p.addrs, k)
+			}
+		}
+		p.clean = now.Add(sessionTimeout)
+	}
+	return m, rAddr, nil
+}
+
+func (p *PacketShadowsocks2022) WriteMsgTo(msg message.Message, addr net.Addr) error {
+	var (
+		own    *udpSession
+		client uint64
+		err    error
+		c      = p.b3
+	)
+	p.mu.Lock()
+	if p.mode == proxy.ModeServer {
+		peer, exist := p.addrs[addr.String()]
+		if !exist {
+			p.mu.Unlock()
+			return errNoSession
+		}
+		if peer.reply == nil {
+			if peer.reply, err = p.newSession(randUint64(), peer.c, peer.user); err != nil {
+				p.mu.Unlock()
+				return err
+			}
+		}
+		own, client, c = peer.reply, peer.id, peer.c
+	} else {
+		own = p.local
+	}
+	pid := own.packetID
+	own.packetID++
+	p.mu.Unlock()
+
+	m := msg.Metadata()
+	padding := 0
+	// dns is easy to be identified by length
+	if m.RemotePort == 53 {
+		padding = randPadding(maxPaddingLength)
+	}
+
+	// room for nonce or separate header and identity headers
+	headerSize := 16 + aes.BlockSize*len(c.identities)
+	if c.chacha {
+		headerSize = chacha20poly1305.NonceSizeX + 16
+	}
+	buf := make([]byte, headerSize, headerSize+1+8+8+2+padding+1+1+255+2+len(msg.Payload())+16)
+	if p.mode == proxy.ModeServer {
+		buf = append(buf, headerTypeServer)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(time.Now().Unix()))
+		buf = binary.BigEndian.AppendUint64(buf, client)
+	} else {
+		buf = append(buf, headerTypeClient)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(time.Now().Unix()))
+	}
+	buf = binary.BigEndian.AppendUint16(buf, uint16(padding))
+	buf = append(buf, make([]byte, padding)...)
+	buf = appendAddr(buf, m)
+	buf = append(buf, msg.Payload()...)
+
+	if c.chacha {
+		nonceSize := chacha20poly1305.NonceSizeX
+		if _, err := rand.Read(buf[:nonceSize]); err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint64(buf[nonceSize:], own.id)
+		binary.BigEndian.PutUint64(buf[nonceSize+8:], pid)
+		buf = c.xchacha.Seal(buf[:nonceSize], buf[:nonceSize], buf[nonceSize:], nil)
+	} else {
+		binary.BigEndian.PutUint64(buf, own.id)
+		binary.BigEndian.PutUint64(buf[8:], pid)
+		buf = own.aead.Seal(buf[:headerSize], buf[4:16], buf[headerSize:], nil)
+		// separate header is encrypted by the first identity psk
+		block := c.block
+		for i, id := range c.identities {
+			if i == 0 {
+				block = id.block
+			}
+			eih := buf[16+i*aes.BlockSize : 16+(i+1)*aes.BlockSize]
+			hash := c.next(i).hash
+			for j := range eih {
+				eih[j] = hash[j] ^ buf[j]
+			}
+			id.block.Encrypt(eih, eih)
+		}
+		block.Encrypt(buf[:16], buf[:16])
+	}
+	_, err = p.WriteTo(buf, addr)
+	return err
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"io"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/intxff/rdcross/component/message"
 	"github.com/intxff/rdcross/component/proxy"
@@ -33,6 +35,16 @@ type PacketShadowsocks struct {
 	net.PacketConn
 	rBuffer []byte
 	wBuffer []byte
+
+	// server mode with users, user of client by address
+	mu    sync.Mutex
+	peers map[string]*udpPeer
+	clean time.Time
+}
+
+type udpPeer struct {
+	user     *ssUser
+	lastSeen time.Time
 }
 
 func newPacketShadowsocks(s Shadowsocks, c net.PacketConn) *PacketShadowsocks {
@@ -41,7 +53,53 @@ func newPacketShadowsocks(s Shadowsocks, c net.PacketConn) *PacketShadowsocks {
 		PacketConn:  c,
 		rBuffer:     make([]byte, _PayloadMaxSize+40),
 		wBuffer:     make([]byte, _PayloadMaxSize+40),
+		peers:       make(map[string]*udpPeer),
+	}
+}
+
+// decrypt opens packet with key of every user, remembers user of
+// client to encrypt replies
+func (p *PacketShadowsocks) decrypt(salt, ciphertext []byte, rAddr net.Addr) ([]byte, *ssUser, error) {
+	planetext := make([]byte, len(ciphertext))
+	for _, u := range p.candidates() {
+		decrypter, err := u.cipher.Decrypter(salt)
+		if err != nil {
+			return nil, nil, err
+		}
+		plain, err := decrypter.Decrypt(ciphertext, "packet", planetext)
+		if err != nil {
+			continue
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		now := time.Now()
+		p.peers[rAddr.String()] = &udpPeer{user: u, lastSeen: now}
+		if now.After(p.clean) {
+			for k, v := range p.peers {
+				if now.Sub(v.lastSeen) > sessionTimeout {
+					delete(p.peers, k)
+				}
+			}
+			p.clean = now.Add(sessionTimeout)
+		}
+		return plain, u, nil
+	}
+	return nil, nil, errNoUser
+}
+
+// replyCipher gets cipher of user the client at addr is authenticated as
+func (p *PacketShadowsocks) replyCipher(addr net.Addr) (proxy.Cipher, error) {
+	if p.mode != proxy.ModeServer || !p.multiUser() {
+		return p.cipher, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peer, exist := p.peers[addr.String()]
+	if !exist {
+		return nil, errNoSession
 	}
+	return peer.user.cipher, nil
 }
 
 func (p *PacketShadowsocks) Metadata() *message.Metadata {
@@ -61,14 +119,26 @@ func (p *PacketShadowsocks) ReadMsgFrom() (message.Message, net.Addr, error) {
     }
 	salt := p.rBuffer[:saltSize]
 	// decrypt
-	decrypter, err := p.Cipher().Decrypter(salt)
-	if err != nil {
-		return nil, rAddr, err
+	var planetext []byte
+	if p.mode == proxy.ModeServer && p.multiUser() {
+		var u *ssUser
+		if planetext, u, err = p.decrypt(salt, p.rBuffer[saltSize:n], rAddr); err != nil {
+			return nil, rAddr, err
+		}
+		m.metadata.WithUser(u.name)
+	} else {
+		decrypter, err := p.Cipher().Decrypter(salt)
+		if err != nil {
+			return nil, rAddr, err
+		}
+		planetext = make([]byte, n-saltSize)
+		planetext, err = decrypter.Decrypt(p.rBuffer[saltSize:n], "packet", planetext)
+		if err != nil {
+			return nil, rAddr, err
+		}
 	}
-	planetext := make([]byte, n-saltSize)
-	planetext, err = decrypter.Decrypt(p.rBuffer[saltSize:n], "packet", planetext)
-	if err != nil {
-		return nil, rAddr, err
+	if p.mode == proxy.ModeServer && p.history.TestAndAdd(salt) {
+		return nil, rAddr, errReplay
 	}
 	// get remote address and update metadata
 	var (
@@ -114,8 +184,15 @@ func (p *PacketShadowsocks) WriteMsgTo(msg message.Message, addr net.Addr) error
 	if _, err := io.ReadFull(rand.Reader, p.wBuffer[:saltSize]); err != nil {
 		return err
 	}
+	if p.mode == proxy.ModeServer {
+		p.history.Add(p.wBuffer[:saltSize])
+	}
 	// encrypter
-	encrypter, err := p.cipher.Encrypter(p.wBuffer[:saltSize])
+	c, err := p.replyCipher(addr)
+	if err != nil {
+		return err
+	}
+	encrypter, err := c.Encrypter(p.wBuffer[:saltSize])
 	if err != nil {
 		return err
 	}
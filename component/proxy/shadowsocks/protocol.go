@@ -1,5 +1,16 @@
 package shadowsocks
 
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy/socks"
+)
+
 /*
  * Spec: http://shadowsocks.org/en/spec/AEAD-Ciphers.html
  *
@@ -44,10 +55,133 @@ package shadowsocks
  * The salt is used to derive the per-session subkey and must be generated randomly to ensure uniqueness. Each UDP
  * packet is encrypted/decrypted independently, using the derived subkey and a nonce with all zero bytes.
  *
+ * Spec of AEAD-2022: https://github.com/Shadowsocks-NET/shadowsocks-specs/blob/main/2022-1-shadowsocks-2022-edition.md
+ *
+ * SIP022 takes a base64 encoded PSK as key, session subkey is derived by
+ *
+ *      BLAKE3_DERIVE_KEY("shadowsocks 2022 session subkey", PSK || salt) => subkey
+ *
+ * A TCP request is salt followed by a fixed-length header chunk
+ *
+ *      [type = 0][timestamp u64][length of variable-length header u16]
+ *
+ * and a variable-length header chunk
+ *
+ *      [socks address][padding length u16][padding][initial payload]
+ *
+ * A TCP response is salt followed by header chunk
+ *
+ *      [type = 1][timestamp u64][request salt][length of first payload chunk u16]
+ *
+ * then chunks as AEAD ciphers, but payload is limited to 0xFFFF bytes.
+ * Timestamps out of 30 seconds and salts seen before are rejected.
+ *
+ * A UDP packet of AES ciphers is
+ *
+ *      [AES-ECB(PSK, session id u64 || packet id u64)][AEAD(subkey of session id, header[4:16], body)]
+ *
+ * and of chacha20 cipher is
+ *
+ *      [nonce 24 bytes][XChaCha20-Poly1305(PSK, nonce, session id || packet id || body)]
+ *
+ * where body of client is
+ *
+ *      [type = 0][timestamp u64][padding length u16][padding][socks address][payload]
+ *
+ * and body of server is
+ *
+ *      [type = 1][timestamp u64][client session id u64][padding length u16][padding][socks address][payload]
+ *
  */
 
 const (
 	_PayloadMaxSize = 0x3FFF
+	// payload limit of AEAD-2022 chunks
+	_PayloadMaxSize2022 = 0xFFFF
+)
+
+// header type of AEAD-2022
+const (
+	headerTypeClient = 0
+	headerTypeServer = 1
+)
+
+const (
+	maxTimeDiff      = 30 * time.Second
+	maxPaddingLength = 900
+)
+
+var (
+	errBadHeader  = errors.New("bad header")
+	errTimestamp  = errors.New("timestamp out of range")
+	errReplay     = errors.New("replayed")
+	errBadAddress = errors.New("bad address")
 )
 
 var zeroNonce [128]byte
+
+// appendAddr appends remote address of m in socks format
+func appendAddr(b []byte, m *message.Metadata) []byte {
+	switch {
+	case m.Domain != "":
+		b = append(b, socks.AtypDomain, byte(len(m.Domain)))
+		b = append(b, m.Domain...)
+	case m.RemoteIP.To4() != nil:
+		b = append(b, socks.AtypIPv4)
+		b = append(b, m.RemoteIP.To4()...)
+	default:
+		b = append(b, socks.AtypIPv6)
+		b = append(b, m.RemoteIP.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(m.RemotePort))
+}
+
+// parseAddr reads socks address in b into m, returns bytes read
+func parseAddr(b []byte, m *message.Metadata) (int, error) {
+	if len(b) < 1 {
+		return 0, errBadAddress
+	}
+	var n int
+	switch b[0] {
+	case socks.AtypIPv4:
+		n = 1 + net.IPv4len
+		if len(b) < n+2 {
+			return 0, errBadAddress
+		}
+		m.WithRemoteIP(net.IP(append([]byte(nil), b[1:n]...)))
+	case socks.AtypIPv6:
+		n = 1 + net.IPv6len
+		if len(b) < n+2 {
+			return 0, errBadAddress
+		}
+		m.WithRemoteIP(net.IP(append([]byte(nil), b[1:n]...)))
+	case socks.AtypDomain:
+		if len(b) < 2 {
+			return 0, errBadAddress
+		}
+		n = 2 + int(b[1])
+		if len(b) < n+2 {
+			return 0, errBadAddress
+		}
+		m.WithDomain(string(b[2:n]))
+	default:
+		return 0, errBadAddress
+	}
+	m.WithRemotePort(int(binary.BigEndian.Uint16(b[n:])))
+	return n + 2, nil
+}
+
+func checkTimestamp(ts uint64) error {
+	diff := time.Since(time.Unix(int64(ts), 0))
+	if diff > maxTimeDiff || diff < -maxTimeDiff {
+		return errTimestamp
+	}
+	return nil
+}
+
+// randPadding gets padding length in [1, max]
+func randPadding(max int) int {
+	var b [2]byte
+	rand.Read(b[:])
+	return 1 + int(binary.BigEndian.Uint16(b[:]))%max
+}
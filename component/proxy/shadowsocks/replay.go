@@ -0,0 +1,94 @@
+package shadowsocks
+
+import (
+	"io"
+	mrand "math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// salts are kept twice as long as timestamps are accepted, replayed
+// requests older than that fail the timestamp check
+const saltTTL = 2 * maxTimeDiff
+
+// saltPool remembers salts of AEAD-2022 requests
+type saltPool struct {
+	mu    sync.Mutex
+	salts map[string]time.Time
+	clean time.Time
+}
+
+func newSaltPool() *saltPool {
+	return &saltPool{salts: make(map[string]time.Time)}
+}
+
+// check adds salt to pool, false if it is seen before
+func (p *saltPool) check(salt []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if now.After(p.clean) {
+		for k, v := range p.salts {
+			if now.After(v) {
+				delete(p.salts, k)
+			}
+		}
+		p.clean = now.Add(saltTTL)
+	}
+
+	if expire, exist := p.salts[string(salt)]; exist && now.Before(expire) {
+		return false
+	}
+	p.salts[string(salt)] = now.Add(saltTTL)
+	return true
+}
+
+// size of sliding window in packets
+const windowSize = 1024
+
+// slidingWindow filters replayed packet ids of a udp session
+type slidingWindow struct {
+	last uint64
+	ring [windowSize / 64]uint64
+}
+
+// check accepts id and marks it, false if it is replayed or too old
+func (w *slidingWindow) check(id uint64) bool {
+	switch {
+	case id+windowSize <= w.last:
+		return false
+	case id > w.last:
+		// clear bits slid over
+		for i := w.last + 1; i <= id && i <= w.last+windowSize; i++ {
+			w.ring[(i/64)%uint64(len(w.ring))] &^= 1 << (i % 64)
+		}
+		w.last = id
+	}
+	word, bit := &w.ring[(id/64)%uint64(len(w.ring))], uint64(1)<<(id%64)
+	if *word&bit != 0 {
+		return false
+	}
+	*word |= bit
+	return true
+}
+
+// salts of AEAD ciphers are remembered in bloom filters, at least so
+// many are kept
+const (
+	historyCapacity = 1000000
+	historyFPRate   = 1e-6
+)
+
+// connections failed to handshake are drained for a random time up to
+// this before closed, so probes can not tell server by how soon the
+// connection is closed
+const maxDrainDelay = 10 * time.Second
+
+func drain(c net.Conn) {
+	delay := time.Duration(mrand.Int63n(int64(maxDrainDelay)))
+	c.SetReadDeadline(time.Now().Add(delay))
+	io.Copy(io.Discard, c)
+	c.Close()
+}
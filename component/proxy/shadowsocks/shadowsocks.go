@@ -6,6 +6,7 @@ import (
 	"github.com/intxff/rdcross/component/conn"
 	"github.com/intxff/rdcross/component/message"
 	"github.com/intxff/rdcross/component/proxy"
+	"github.com/intxff/rdcross/util/bloom"
 )
 
 type Shadowsocks struct {
@@ -13,22 +14,49 @@ type Shadowsocks struct {
 	password []byte
 	udp      bool
 	key      []byte
+	method   string
 	cipher   proxy.Cipher
+	// AEAD-2022 of SIP022
+	aead2022 bool
+	salts    *saltPool
+	// server mode, salts of AEAD ciphers seen
+	history *bloom.Rotating
+	// server mode, users besides password
+	users *userList
 }
 
+// NewProxyShadowsocks creates shadowsocks proxy. Ciphers of AEAD-2022
+// take base64 psk from key, or from password if key is empty.
 func NewProxyShadowsocks(mode int, password, cipher, key string, udp bool) (*Shadowsocks, error) {
 	p, k := []byte(password), []byte(key)
-	c, k, err := newAeadCipher(p, k, cipher)
+	c, k, aead2022, err := newCipher(p, k, cipher)
 	if err != nil {
 		return nil, err
 	}
-	return &Shadowsocks{
+	s := &Shadowsocks{
 		mode:     mode,
 		password: p,
 		udp:      udp,
 		key:      k,
+		method:   cipher,
 		cipher:   c,
-	}, nil
+		aead2022: aead2022,
+		salts:    newSaltPool(),
+		users:    newUserList(),
+	}
+	if mode == proxy.ModeServer && !aead2022 {
+		s.history = bloom.NewRotating(historyCapacity, historyFPRate)
+	}
+	return s, nil
+}
+
+func newCipher(password, key []byte, cipher string) (proxy.Cipher, []byte, bool, error) {
+	if is2022(cipher) {
+		c, k, err := newBlake3Cipher(password, key, cipher)
+		return c, k, true, err
+	}
+	c, k, err := newAeadCipher(password, key, cipher)
+	return c, k, false, err
 }
 
 func (s *Shadowsocks) Type() proxy.ProxyType {
@@ -44,9 +72,29 @@ func (s *Shadowsocks) Cipher() proxy.Cipher {
 }
 
 func (s *Shadowsocks) ShadowStreamConn(c net.Conn, extra ...any) (conn.ProxyStreamConn, error) {
-	m := extra[0].(*message.Metadata)
-	return newStreamShadowsocks(*s, c, m)
+	// server gets metadata from client
+	m, _ := extra[0].(*message.Metadata)
+	var (
+		sc  conn.ProxyStreamConn
+		err error
+	)
+	if s.aead2022 {
+		sc, err = newStreamShadowsocks2022(*s, c, m)
+	} else {
+		sc, err = newStreamShadowsocks(*s, c, m)
+	}
+	if err != nil {
+		if s.mode == proxy.ModeServer {
+			drain(c)
+		}
+		return nil, err
+	}
+	return sc, nil
 }
+
 func (s *Shadowsocks) ShadowPacketConn(c net.PacketConn, extra ...any) (conn.ProxyPacketConn, error) {
+	if s.aead2022 {
+		return newPacketShadowsocks2022(*s, c)
+	}
 	return newPacketShadowsocks(*s, c), nil
 }
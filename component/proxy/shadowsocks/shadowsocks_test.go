@@ -0,0 +1,336 @@
+package shadowsocks
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+func newTestPair(t *testing.T, cipher string, keySize int) (server, client *Shadowsocks) {
+	key := make([]byte, keySize)
+	rand.Read(key)
+	psk := base64.StdEncoding.EncodeToString(key)
+	server, err := NewProxyShadowsocks(proxy.ModeServer, psk, cipher, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = NewProxyShadowsocks(proxy.ModeClient, psk, cipher, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func Test2022Stream(t *testing.T) {
+	for cipher, v := range aead2022List {
+		server, client := newTestPair(t, cipher, v.KeySize)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		request := make([]byte, 3*_PayloadMaxSize2022)
+		response := make([]byte, 100)
+		rand.Read(request)
+		rand.Read(response)
+
+		done := make(chan error, 1)
+		go func() {
+			c, err := l.Accept()
+			if err != nil {
+				done <- err
+				return
+			}
+			defer c.Close()
+			sc, err := server.ShadowStreamConn(c, "test")
+			if err != nil {
+				done <- err
+				return
+			}
+			if m := sc.Metadata(); m.Domain != "example.com" || m.RemotePort != 443 {
+				t.Errorf("%v: metadata %+v", cipher, m)
+			}
+			got := make([]byte, len(request))
+			if _, err := io.ReadFull(sc, got); err != nil {
+				done <- err
+				return
+			}
+			if !bytes.Equal(got, request) {
+				t.Errorf("%v: request mismatch", cipher)
+			}
+			_, err = sc.Write(response)
+			done <- err
+		}()
+
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := message.NewMetadata().WithDomain("example.com").WithRemotePort(443)
+		cc, err := client.ShadowStreamConn(c, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cc.Write(request); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len(response))
+		if _, err := io.ReadFull(cc, got); err != nil {
+			t.Fatalf("%v: %v", cipher, err)
+		}
+		if !bytes.Equal(got, response) {
+			t.Errorf("%v: response mismatch", cipher)
+		}
+		if err := <-done; err != nil {
+			t.Errorf("%v: %v", cipher, err)
+		}
+		c.Close()
+		l.Close()
+	}
+}
+
+// replayConn keeps every packet written
+type replayConn struct {
+	net.PacketConn
+	last []byte
+}
+
+func (r *replayConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	r.last = append(r.last[:0], b...)
+	return r.PacketConn.WriteTo(b, addr)
+}
+
+func Test2022Packet(t *testing.T) {
+	for cipher, v := range aead2022List {
+		server, client := newTestPair(t, cipher, v.KeySize)
+		sl, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cl, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc := &replayConn{PacketConn: cl}
+		ss, err := server.ShadowPacketConn(sl, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs, err := client.ShadowPacketConn(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m := message.NewMetadata().WithRemoteIP(net.IPv4(192, 0, 2, 1)).WithRemotePort(53)
+		if err := cs.WriteMsgTo(&udpMsg{payload: []byte("query"), metadata: m}, sl.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		msg, addr, err := ss.ReadMsgFrom()
+		if err != nil {
+			t.Fatalf("%v: %v", cipher, err)
+		}
+		if string(msg.Payload()) != "query" || !msg.Metadata().RemoteIP.Equal(m.RemoteIP) {
+			t.Errorf("%v: got %q %+v", cipher, msg.Payload(), msg.Metadata())
+		}
+
+		// same packet again is dropped
+		if _, err := cl.WriteTo(rc.last, sl.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := ss.ReadMsgFrom(); err != errReplay {
+			t.Errorf("%v: replayed packet: %v", cipher, err)
+		}
+
+		if err := ss.WriteMsgTo(&udpMsg{payload: []byte("answer"), metadata: m}, addr); err != nil {
+			t.Fatal(err)
+		}
+		msg, _, err = cs.ReadMsgFrom()
+		if err != nil {
+			t.Fatalf("%v: %v", cipher, err)
+		}
+		if string(msg.Payload()) != "answer" {
+			t.Errorf("%v: got %q", cipher, msg.Payload())
+		}
+		sl.Close()
+		cl.Close()
+	}
+}
+
+func randPSK(size int) string {
+	key := make([]byte, size)
+	rand.Read(key)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestMultiUser(t *testing.T) {
+	ciphers := map[string]int{"AES-128-GCM": 16, "CHACHA20-IETF-POLY1305": 32}
+	for cipher, v := range aead2022List {
+		ciphers[cipher] = v.KeySize
+	}
+	for cipher, size := range ciphers {
+		spsk, upsk := randPSK(size), randPSK(size)
+		server, err := NewProxyShadowsocks(proxy.ModeServer, spsk, cipher, "", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := server.AddUser("other", randPSK(size)); err != nil {
+			t.Fatal(err)
+		}
+		if err := server.AddUser("alice", upsk); err != nil {
+			t.Fatal(err)
+		}
+		// identity header of AES ciphers is built from server psk
+		key := upsk
+		if is2022(cipher) && cipher != aead2022Chacha20Poly1305 {
+			key = spsk + ":" + upsk
+		}
+		client, err := NewProxyShadowsocks(proxy.ModeClient, key, cipher, "", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users := make(chan string, 2)
+		go func() {
+			for {
+				c, err := l.Accept()
+				if err != nil {
+					return
+				}
+				sc, err := server.ShadowStreamConn(c, "test")
+				if err != nil {
+					users <- ""
+					c.Close()
+					continue
+				}
+				users <- sc.Metadata().User
+				io.Copy(sc, sc)
+				c.Close()
+			}
+		}()
+		dial := func() {
+			c, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			m := message.NewMetadata().WithDomain("example.com").WithRemotePort(80)
+			cc, err := client.ShadowStreamConn(c, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.Write([]byte("hello"))
+		}
+		dial()
+		if u := <-users; u != "alice" {
+			t.Errorf("%v: tcp user %q", cipher, u)
+		}
+
+		sl, _ := net.ListenPacket("udp", "127.0.0.1:0")
+		cl, _ := net.ListenPacket("udp", "127.0.0.1:0")
+		ss, _ := server.ShadowPacketConn(sl, "test")
+		cs, _ := client.ShadowPacketConn(cl)
+		m := message.NewMetadata().WithRemoteIP(net.IPv4(192, 0, 2, 1)).WithRemotePort(53)
+		if err := cs.WriteMsgTo(&udpMsg{payload: []byte("query"), metadata: m}, sl.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		msg, addr, err := ss.ReadMsgFrom()
+		if err != nil {
+			t.Fatalf("%v: %v", cipher, err)
+		}
+		if msg.Metadata().User != "alice" {
+			t.Errorf("%v: udp user %q", cipher, msg.Metadata().User)
+		}
+		if err := ss.WriteMsgTo(&udpMsg{payload: []byte("answer"), metadata: m}, addr); err != nil {
+			t.Fatal(err)
+		}
+		if msg, _, err = cs.ReadMsgFrom(); err != nil || string(msg.Payload()) != "answer" {
+			t.Errorf("%v: udp answer %v", cipher, err)
+		}
+
+		// revoked user is refused
+		if !server.RemoveUser("alice") {
+			t.Fatalf("%v: alice not removed", cipher)
+		}
+		dial()
+		if u := <-users; u != "" {
+			t.Errorf("%v: revoked user accepted as %q", cipher, u)
+		}
+		l.Close()
+		sl.Close()
+		cl.Close()
+	}
+}
+
+// recordConn keeps everything written
+type recordConn struct {
+	net.Conn
+	written []byte
+}
+
+func (r *recordConn) Write(b []byte) (int, error) {
+	r.written = append(r.written, b...)
+	return r.Conn.Write(b)
+}
+
+func TestReplay(t *testing.T) {
+	server, err := NewProxyShadowsocks(proxy.ModeServer, "password", "AES-128-GCM", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewProxyShadowsocks(proxy.ModeClient, "password", "AES-128-GCM", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	results := make(chan error, 2)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, err = server.ShadowStreamConn(c, "test")
+			results <- err
+			c.Close()
+		}
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := &recordConn{Conn: c}
+	m := message.NewMetadata().WithDomain("example.com").WithRemotePort(80)
+	if _, err := client.ShadowStreamConn(rc, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-results; err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	// the same request again
+	c, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Write(rc.written)
+	c.Close()
+	if err := <-results; err != errReplay {
+		t.Errorf("replayed request: %v", err)
+	}
+}
@@ -0,0 +1,342 @@
+package shadowsocks
+
+import (
+	"crypto/aes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+// StreamShadowsocks2022 is tcp of AEAD-2022 ciphers
+type StreamShadowsocks2022 struct {
+	Shadowsocks
+	net.Conn
+	encrypter *aeadEncryter
+	decrypter *aeadDecryter
+	metadata  *message.Metadata
+	// salt of request, sent back by server in response header
+	reqSalt []byte
+	// server mode, user the client is authenticated as
+	user    string
+	rBuffer []byte
+	wBuffer []byte
+	// left planetext in rBuffer[lLeft, rLeft)
+	rLeft int
+	lLeft int
+}
+
+func newStreamShadowsocks2022(p Shadowsocks, c net.Conn, m *message.Metadata) (*StreamShadowsocks2022, error) {
+	tagSize := 16
+	b3 := p.cipher.(*blake3Cipher)
+	sss := &StreamShadowsocks2022{
+		Shadowsocks: p,
+		Conn:        c,
+		metadata:    m,
+		rBuffer:     make([]byte, _PayloadMaxSize2022+tagSize),
+		wBuffer:     make([]byte, 2*len(p.key)+aes.BlockSize*len(b3.identities)+11+2*tagSize+_PayloadMaxSize2022+tagSize),
+	}
+	var err error
+	if p.mode == proxy.ModeServer {
+		err = sss.serverHandshake()
+	} else {
+		err = sss.clientHandshake()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sss, nil
+}
+
+// readChunk reads and decrypts a chunk of l bytes into rBuffer
+func (s *StreamShadowsocks2022) readChunk(l int) ([]byte, error) {
+	buf := s.rBuffer[:l+s.decrypter.Overhead()]
+	if _, err := io.ReadFull(s.Conn, buf); err != nil {
+		return nil, err
+	}
+	return s.decrypter.Decrypt(buf)
+}
+
+func (s *StreamShadowsocks2022) serverHandshake() error {
+	salt := make([]byte, len(s.key))
+	if _, err := io.ReadFull(s.Conn, salt); err != nil {
+		return err
+	}
+
+	// fixed-length header
+	var (
+		header []byte
+		err    error
+	)
+	switch {
+	case s.eih():
+		header, err = s.readIdentity(salt)
+	case s.multiUser():
+		header, err = s.identifyUser(salt)
+	default:
+		var decrypter proxy.Decrypter
+		if decrypter, err = s.Cipher().Decrypter(salt); err != nil {
+			return err
+		}
+		s.decrypter = decrypter.(*aeadDecryter)
+		header, err = s.readChunk(1 + 8 + 2)
+	}
+	if err != nil {
+		return err
+	}
+	if header[0] != headerTypeClient {
+		return errBadHeader
+	}
+	if err := checkTimestamp(binary.BigEndian.Uint64(header[1:])); err != nil {
+		return err
+	}
+	// salt is checked after authenticated, or anyone can fill the pool
+	if !s.salts.check(salt) {
+		return errReplay
+	}
+	s.reqSalt = salt
+
+	// variable-length header
+	header, err = s.readChunk(int(binary.BigEndian.Uint16(header[9:])))
+	if err != nil {
+		return err
+	}
+	client := s.Conn.RemoteAddr().(*net.TCPAddr)
+	m := message.NewMetadata().WithClientIP(client.IP).
+		WithClientPort(client.Port).WithUser(s.user)
+	n, err := parseAddr(header, m)
+	if err != nil {
+		return err
+	}
+	if len(header) < n+2 {
+		return errBadHeader
+	}
+	padding := int(binary.BigEndian.Uint16(header[n:]))
+	n += 2 + padding
+	if len(header) < n || (padding == 0 && len(header) == n) {
+		// neither padding nor payload
+		return errBadHeader
+	}
+	s.metadata = m
+	// initial payload is left to read
+	s.lLeft, s.rLeft = n, len(header)
+	return nil
+}
+
+// readIdentity gets user from identity header, which is hash of user
+// psk encrypted by subkey of identity psk, then reads fixed-length
+// header with user psk
+func (s *StreamShadowsocks2022) readIdentity(salt []byte) ([]byte, error) {
+	eih := s.rBuffer[:aes.BlockSize]
+	if _, err := io.ReadFull(s.Conn, eih); err != nil {
+		return nil, err
+	}
+	block, err := s.cipher.(*blake3Cipher).identityBlock(salt)
+	if err != nil {
+		return nil, err
+	}
+	block.Decrypt(eih, eih)
+	u, err := s.identify(eih)
+	if err != nil {
+		return nil, err
+	}
+	s.cipher, s.key, s.user = u.cipher, u.key, u.name
+
+	decrypter, err := s.Cipher().Decrypter(salt)
+	if err != nil {
+		return nil, err
+	}
+	s.decrypter = decrypter.(*aeadDecryter)
+	return s.readChunk(1 + 8 + 2)
+}
+
+// identifyUser finds user by decrypting fixed-length header with psk
+// of every user, for ciphers without identity header
+func (s *StreamShadowsocks2022) identifyUser(salt []byte) ([]byte, error) {
+	chunk := s.rBuffer[:1+8+2+16]
+	if _, err := io.ReadFull(s.Conn, chunk); err != nil {
+		return nil, err
+	}
+	trial := make([]byte, len(chunk))
+	for _, u := range s.candidates() {
+		decrypter, err := u.cipher.Decrypter(salt)
+		if err != nil {
+			return nil, err
+		}
+		copy(trial, chunk)
+		header, err := decrypter.Decrypt(trial)
+		if err != nil {
+			continue
+		}
+		s.cipher, s.key, s.user = u.cipher, u.key, u.name
+		s.decrypter = decrypter.(*aeadDecryter)
+		return header, nil
+	}
+	return nil, errNoUser
+}
+
+func (s *StreamShadowsocks2022) clientHandshake() error {
+	salt := make([]byte, len(s.key))
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+	encrypter, err := s.Cipher().Encrypter(salt)
+	if err != nil {
+		return err
+	}
+	s.encrypter = encrypter.(*aeadEncryter)
+	s.reqSalt = salt
+
+	// variable-length header without payload has to be padded
+	vHeader := appendAddr(make([]byte, 0, 1+1+255+2+2+maxPaddingLength), s.metadata)
+	padding := randPadding(maxPaddingLength)
+	vHeader = binary.BigEndian.AppendUint16(vHeader, uint16(padding))
+	vHeader = append(vHeader, make([]byte, padding)...)
+
+	buf := append(s.wBuffer[:0], salt...)
+	// identity headers, each for the next server on the way
+	b3 := s.cipher.(*blake3Cipher)
+	for i, id := range b3.identities {
+		block, err := id.identityBlock(salt)
+		if err != nil {
+			return err
+		}
+		eih := b3.next(i).hash
+		block.Encrypt(eih[:], eih[:])
+		buf = append(buf, eih[:]...)
+	}
+	start := len(buf)
+	buf = append(buf, headerTypeClient)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(time.Now().Unix()))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(vHeader)))
+	buf = s.seal(buf, start)
+	start = len(buf)
+	buf = append(buf, vHeader...)
+	buf = s.seal(buf, start)
+	_, err = s.Conn.Write(buf)
+	return err
+}
+
+// seal encrypts buf[start:] in place, buf is extended with tag
+func (s *StreamShadowsocks2022) seal(buf []byte, start int) []byte {
+	sealed := s.encrypter.Encrypt(buf[start:])
+	return buf[:start+len(sealed)]
+}
+
+// readResponseHeader reads salt and header of response, then the
+// first payload chunk
+func (s *StreamShadowsocks2022) readResponseHeader() error {
+	salt := s.rBuffer[:len(s.key)]
+	if _, err := io.ReadFull(s.Conn, salt); err != nil {
+		return err
+	}
+	decrypter, err := s.Cipher().Decrypter(salt)
+	if err != nil {
+		return err
+	}
+	s.decrypter = decrypter.(*aeadDecryter)
+
+	header, err := s.readChunk(1 + 8 + len(s.key) + 2)
+	if err != nil {
+		return err
+	}
+	if header[0] != headerTypeServer {
+		return errBadHeader
+	}
+	if err := checkTimestamp(binary.BigEndian.Uint64(header[1:])); err != nil {
+		return err
+	}
+	if string(header[9:9+len(s.key)]) != string(s.reqSalt) {
+		return errBadHeader
+	}
+	l := int(binary.BigEndian.Uint16(header[9+len(s.key):]))
+	payload, err := s.readChunk(l)
+	if err != nil {
+		return err
+	}
+	s.lLeft, s.rLeft = 0, len(payload)
+	return nil
+}
+
+func (s *StreamShadowsocks2022) ReadMux() (message.Message, error) {
+	return nil, proxy.ErrNotSupported
+}
+
+func (s *StreamShadowsocks2022) WriteMux(msg message.Message) error {
+	return proxy.ErrNotSupported
+}
+
+func (s *StreamShadowsocks2022) Read(b []byte) (int, error) {
+	for s.lLeft == s.rLeft {
+		if s.decrypter == nil {
+			if err := s.readResponseHeader(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		// payload length, then payload
+		buf, err := s.readChunk(2)
+		if err != nil {
+			return 0, err
+		}
+		l := int(binary.BigEndian.Uint16(buf))
+		payload, err := s.readChunk(l)
+		if err != nil {
+			return 0, err
+		}
+		s.lLeft, s.rLeft = 0, len(payload)
+	}
+	n := copy(b, s.rBuffer[s.lLeft:s.rLeft])
+	s.lLeft += n
+	return n, nil
+}
+
+func (s *StreamShadowsocks2022) Write(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		buf := s.wBuffer[:0]
+		start := 0
+		nc := len(b) - n
+		if nc > _PayloadMaxSize2022 {
+			nc = _PayloadMaxSize2022
+		}
+
+		if s.encrypter == nil {
+			// response header carries length of the first chunk
+			salt := make([]byte, len(s.key))
+			if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+				return n, err
+			}
+			encrypter, err := s.Cipher().Encrypter(salt)
+			if err != nil {
+				return n, err
+			}
+			s.encrypter = encrypter.(*aeadEncryter)
+			buf = append(buf, salt...)
+			buf = append(buf, headerTypeServer)
+			buf = binary.BigEndian.AppendUint64(buf, uint64(time.Now().Unix()))
+			buf = append(buf, s.reqSalt...)
+			start = len(salt)
+		}
+		buf = binary.BigEndian.AppendUint16(buf, uint16(nc))
+		buf = s.seal(buf, start)
+
+		start = len(buf)
+		buf = append(buf, b[n:n+nc]...)
+		buf = s.seal(buf, start)
+
+		if _, err := s.Conn.Write(buf); err != nil {
+			return n, err
+		}
+		n += nc
+	}
+	return n, nil
+}
+
+func (s *StreamShadowsocks2022) Metadata() *message.Metadata {
+	return s.metadata
+}
@@ -21,6 +21,8 @@ type StreamShadowsocks struct {
 	// left planetext in rBuffer[lLeft, rLeft)
 	rLeft int
 	lLeft int
+	// server mode, user the client is authenticated as
+	user string
 }
 
 func newStreamShadowsocks(p Shadowsocks, c net.Conn, m *message.Metadata) (*StreamShadowsocks, error) {
@@ -52,15 +54,11 @@ func (s *StreamShadowsocks) serverHandshake() error {
 	salt := make([]byte, keySize)
 
 	// get client's salt
-	if _, err := s.Conn.Read(salt); err != nil {
+	if _, err := io.ReadFull(s.Conn, salt); err != nil {
 		return err
 	}
-	decrypter, err := s.Cipher().Decrypter(salt)
-	if err != nil {
-		return err
-	}
-	s.decrypter = decrypter.(*aeadDecryter)
-	l := 2 + s.decrypter.Overhead() + _PayloadMaxSize + s.decrypter.Overhead()
+	tagSize := 16
+	l := 2 + tagSize + _PayloadMaxSize + tagSize
 	s.rBuffer = make([]byte, l)
 	s.wBuffer = make([]byte, l)
 
@@ -69,9 +67,27 @@ func (s *StreamShadowsocks) serverHandshake() error {
 	m := message.NewMetadata().WithClientIP(client.IP).
 		WithClientPort(client.Port)
 	buf := make([]byte, 260)
-	n, err := s.Read(buf)
-	if err != nil {
-		return err
+	var n int
+	if s.multiUser() {
+		first, err := s.identifyUser(salt)
+		if err != nil {
+			return err
+		}
+		m.WithUser(s.user)
+		n = copy(buf, first)
+	} else {
+		decrypter, err := s.Cipher().Decrypter(salt)
+		if err != nil {
+			return err
+		}
+		s.decrypter = decrypter.(*aeadDecryter)
+		if n, err = s.Read(buf); err != nil {
+			return err
+		}
+	}
+	// salt is checked after authenticated, or anyone can fill the history
+	if s.history.TestAndAdd(salt) {
+		return errReplay
 	}
 	switch atyp := buf[0]; atyp {
 	case socks.AtypDomain:
@@ -89,10 +105,11 @@ func (s *StreamShadowsocks) serverHandshake() error {
 	m.RemotePort = int(port[0])<<8 + int(port[1])
 	s.metadata = m
 
-	// send server's salt
+	// send server's salt, which is never accepted from clients
 	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
 		return err
 	}
+	s.history.Add(salt)
 	if _, err := s.Conn.Write(salt); err != nil {
 		return err
 	}
@@ -106,6 +123,37 @@ func (s *StreamShadowsocks) serverHandshake() error {
 	return nil
 }
 
+// identifyUser finds user by decrypting the first chunk with key of
+// every user, returns planetext of the chunk
+func (s *StreamShadowsocks) identifyUser(salt []byte) ([]byte, error) {
+	tagSize := 16
+	lenSize := 2 + tagSize
+	buf := s.rBuffer
+	if _, err := io.ReadFull(s.Conn, buf[:lenSize]); err != nil {
+		return nil, err
+	}
+	trial := make([]byte, lenSize)
+	for _, u := range s.candidates() {
+		decrypter, err := u.cipher.Decrypter(salt)
+		if err != nil {
+			return nil, err
+		}
+		copy(trial, buf[:lenSize])
+		if _, err := decrypter.Decrypt(trial); err != nil {
+			continue
+		}
+		s.cipher, s.key, s.user = u.cipher, u.key, u.name
+		s.decrypter = decrypter.(*aeadDecryter)
+
+		l := (int(trial[0])<<8 + int(trial[1])) & _PayloadMaxSize
+		if _, err := io.ReadFull(s.Conn, buf[:l+tagSize]); err != nil {
+			return nil, err
+		}
+		return s.decrypter.Decrypt(buf[:l+tagSize])
+	}
+	return nil, errNoUser
+}
+
 func (s *StreamShadowsocks) clientHandShake() error {
 	salt := make([]byte, len(s.key))
 
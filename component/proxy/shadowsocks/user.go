@@ -0,0 +1,113 @@
+package shadowsocks
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+var errNoUser = errors.New("no user matched")
+
+// ssUser is a user of multi-user server
+type ssUser struct {
+	name   string
+	key    []byte
+	cipher proxy.Cipher
+}
+
+type userList struct {
+	mu    sync.RWMutex
+	users map[string]*ssUser
+	// users of AEAD-2022 by hash of psk in identity header
+	hashes map[[16]byte]*ssUser
+}
+
+func newUserList() *userList {
+	return &userList{
+		users:  make(map[string]*ssUser),
+		hashes: make(map[[16]byte]*ssUser),
+	}
+}
+
+// AddUser adds or replaces user of server, password is taken as
+// password of the proxy
+func (s *Shadowsocks) AddUser(name, password string) error {
+	c, k, _, err := newCipher([]byte(password), nil, s.method)
+	if err != nil {
+		return err
+	}
+	if b, ok := c.(*blake3Cipher); ok && len(b.identities) != 0 {
+		return fmt.Errorf("psk of user %v has identity psk", name)
+	}
+	u := &ssUser{name: name, key: k, cipher: c}
+
+	s.users.mu.Lock()
+	defer s.users.mu.Unlock()
+	if old, exist := s.users.users[name]; exist {
+		if b, ok := old.cipher.(*blake3Cipher); ok {
+			delete(s.users.hashes, b.hash)
+		}
+	}
+	s.users.users[name] = u
+	if b, ok := c.(*blake3Cipher); ok {
+		s.users.hashes[b.hash] = u
+	}
+	return nil
+}
+
+// RemoveUser drops key and identity hash of name, sessions decrypted by
+// it before keep going
+func (s *Shadowsocks) RemoveUser(name string) bool {
+	s.users.mu.Lock()
+	defer s.users.mu.Unlock()
+	u, exist := s.users.users[name]
+	if !exist {
+		return false
+	}
+	delete(s.users.users, name)
+	if b, ok := u.cipher.(*blake3Cipher); ok {
+		delete(s.users.hashes, b.hash)
+	}
+	return true
+}
+
+func (s *Shadowsocks) multiUser() bool {
+	s.users.mu.RLock()
+	defer s.users.mu.RUnlock()
+	return len(s.users.users) != 0
+}
+
+// eih reports whether users are identified by identity header, which
+// AES ciphers of AEAD-2022 have. The psk of server is identity psk.
+func (s *Shadowsocks) eih() bool {
+	b, ok := s.cipher.(*blake3Cipher)
+	return ok && !b.chacha && s.multiUser()
+}
+
+// candidates gets users to be tried to decrypt, password of server is
+// also accepted as an anonymous user
+func (s *Shadowsocks) candidates() []*ssUser {
+	s.users.mu.RLock()
+	defer s.users.mu.RUnlock()
+	out := make([]*ssUser, 0, len(s.users.users)+1)
+	out = append(out, &ssUser{key: s.key, cipher: s.cipher})
+	for _, u := range s.users.users {
+		out = append(out, u)
+	}
+	return out
+}
+
+// identify finds user of AEAD-2022 by hash in identity header
+func (s *Shadowsocks) identify(hash []byte) (*ssUser, error) {
+	var h [16]byte
+	copy(h[:], hash)
+	s.users.mu.RLock()
+	defer s.users.mu.RUnlock()
+	u, exist := s.users.hashes[h]
+	if !exist {
+		return nil, errNoUser
+	}
+	return u, nil
+}
@@ -0,0 +1,58 @@
+package socks
+
+import (
+	"net"
+	"sync"
+)
+
+// associations keeps clients whose udp associate is alive. Once users
+// are set, only datagrams from them are relayed.
+type associations struct {
+	mu      sync.RWMutex
+	clients map[string]*association
+}
+
+type association struct {
+	user string
+	// udp associate connections of the client
+	refs int
+}
+
+func newAssociations() *associations {
+	return &associations{clients: make(map[string]*association)}
+}
+
+func (a *associations) add(ip net.IP, user string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c, exist := a.clients[ip.String()]
+	if !exist {
+		c = &association{}
+		a.clients[ip.String()] = c
+	}
+	c.user = user
+	c.refs++
+}
+
+func (a *associations) remove(ip net.IP) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c, exist := a.clients[ip.String()]
+	if !exist {
+		return
+	}
+	if c.refs--; c.refs == 0 {
+		delete(a.clients, ip.String())
+	}
+}
+
+// lookup gets user who associated udp from ip
+func (a *associations) lookup(ip net.IP) (string, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	c, exist := a.clients[ip.String()]
+	if !exist {
+		return "", false
+	}
+	return c.user, true
+}
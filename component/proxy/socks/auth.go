@@ -0,0 +1,125 @@
+package socks
+
+import (
+	"io"
+	"sync"
+)
+
+// username/password authentication of RFC 1929
+// +----+------+----------+------+----------+
+// |VER | ULEN |  UNAME   | PLEN |  PASSWD  |
+// +----+------+----------+------+----------+
+// | 1  |  1   | 1 to 255 |  1   | 1 to 255 |
+// +----+------+----------+------+----------+
+//
+//        +----+--------+
+//        |VER | STATUS |
+//        +----+--------+
+//        | 1  |   1    |
+//        +----+--------+
+
+const (
+	AuthVer     = byte(0x01)
+	AuthSuccess = byte(0x00)
+	AuthFailure = byte(0x01)
+)
+
+type userList struct {
+	mu    sync.RWMutex
+	users map[string]string
+}
+
+// AddUser adds or replaces user of server, clients have to authenticate
+// once any user is added
+func (s *Socks) AddUser(name, password string) {
+	s.users.mu.Lock()
+	defer s.users.mu.Unlock()
+	s.users.users[name] = password
+}
+
+// RemoveUser deletes credential of name, later handshakes with it fail
+// authentication while established connections are kept
+func (s *Socks) RemoveUser(name string) bool {
+	s.users.mu.Lock()
+	defer s.users.mu.Unlock()
+	if _, exist := s.users.users[name]; !exist {
+		return false
+	}
+	delete(s.users.users, name)
+	return true
+}
+
+// WithCredential sets username and password client authenticates with
+func (s *Socks) WithCredential(username, password string) *Socks {
+	s.username, s.password = username, password
+	return s
+}
+
+func (s *Socks) needAuth() bool {
+	s.users.mu.RLock()
+	defer s.users.mu.RUnlock()
+	return len(s.users.users) != 0
+}
+
+func (s *Socks) verify(name, password string) bool {
+	s.users.mu.RLock()
+	defer s.users.mu.RUnlock()
+	p, exist := s.users.users[name]
+	return exist && p == password
+}
+
+// serverAuth reads username and password of client, returns user
+func (s *StreamSocks) serverAuth() (string, error) {
+	buffer := make([]byte, 255)
+	if _, err := io.ReadFull(s.Conn, buffer[:2]); err != nil {
+		return "", err
+	}
+	if buffer[0] != AuthVer {
+		return "", ErrAuthFailed
+	}
+	l := buffer[1]
+	if _, err := io.ReadFull(s.Conn, buffer[:l]); err != nil {
+		return "", err
+	}
+	name := string(buffer[:l])
+	if _, err := io.ReadFull(s.Conn, buffer[:1]); err != nil {
+		return "", err
+	}
+	l = buffer[0]
+	if _, err := io.ReadFull(s.Conn, buffer[:l]); err != nil {
+		return "", err
+	}
+	password := string(buffer[:l])
+
+	if !s.verify(name, password) {
+		s.Conn.Write([]byte{AuthVer, AuthFailure})
+		return "", ErrAuthFailed
+	}
+	if _, err := s.Conn.Write([]byte{AuthVer, AuthSuccess}); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// clientAuth sends username and password to server
+func (s *StreamSocks) clientAuth() error {
+	if len(s.username) > 255 || len(s.password) > 255 {
+		return ErrAuthFailed
+	}
+	req := make([]byte, 0, 3+len(s.username)+len(s.password))
+	req = append(req, AuthVer, byte(len(s.username)))
+	req = append(req, s.username...)
+	req = append(req, byte(len(s.password)))
+	req = append(req, s.password...)
+	if _, err := s.Conn.Write(req); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(s.Conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != AuthSuccess {
+		return ErrAuthFailed
+	}
+	return nil
+}
@@ -39,21 +39,37 @@ func (p *PacketSocks) Metadata() *message.Metadata {
 	return nil
 }
 
+// ReadMsgFrom reads next datagram. With users set, server drops those
+// not from clients of alive udp associate.
 func (p *PacketSocks) ReadMsgFrom() (message.Message, net.Addr, error) {
 	buf := make([]byte, udpBufferSize)
-	n, rAddr, err := p.ReadFrom(buf)
-	if err != nil {
-		return nil, rAddr, err
-	}
-	m, err := p.deserialize(buf[:n])
-	if err != nil {
-		return m, rAddr, err
-	}
-	if p.mode == proxy.ModeServer {
-		m.Metadata().WithClientIP(rAddr.(*net.UDPAddr).IP).
-			WithClientPort(rAddr.(*net.UDPAddr).Port)
+	for {
+		n, rAddr, err := p.ReadFrom(buf)
+		if err != nil {
+			return nil, rAddr, err
+		}
+		if p.mode != proxy.ModeServer {
+			m, err := p.deserialize(buf[:n])
+			return m, rAddr, err
+		}
+
+		cAddr := rAddr.(*net.UDPAddr)
+		var user string
+		if p.needAuth() {
+			var ok bool
+			if user, ok = p.assoc.lookup(cAddr.IP); !ok {
+				continue
+			}
+		}
+		m, err := p.deserialize(buf[:n])
+		if err != nil {
+			return m, rAddr, err
+		}
+		m.Metadata().WithClientIP(cAddr.IP).
+			WithClientPort(cAddr.Port).
+			WithUser(user)
+		return m, rAddr, nil
 	}
-	return m, rAddr, nil
 }
 
 func (p *PacketSocks) WriteMsgTo(m message.Message, addr net.Addr) error {
@@ -18,17 +18,29 @@ const (
 
 var (
 	ErrNotSupported    error = errors.New("not supported")
-	ErrVerNotSupported error = errors.New("need socks verion 5")
+	ErrVerNotSupported error = errors.New("need socks verion 4 or 5")
 	ErrAuthFailed      error = errors.New("auth failed")
 	ErrCmdNotSupported error = errors.New("cmd not supported")
+	ErrAssociateEnded  error = errors.New("udp associate ended")
 )
 
 type Socks struct {
 	mode int
+	// server mode, users allowed
+	users *userList
+	// server mode, clients udp is associated for
+	assoc *associations
+	// client mode, credential to authenticate with
+	username string
+	password string
 }
 
 func NewProxySocks(mode int) *Socks {
-	return &Socks{mode: mode}
+	return &Socks{
+		mode:  mode,
+		users: &userList{users: make(map[string]string)},
+		assoc: newAssociations(),
+	}
 }
 
 func (s *Socks) Cipher() proxy.Cipher {
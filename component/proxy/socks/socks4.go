@@ -0,0 +1,98 @@
+package socks
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/intxff/rdcross/component/message"
+)
+
+// socks4 request, socks4a sets DSTIP to 0.0.0.x and appends domain
+// ended by null after USERID
+// +----+----+---------+-------+----------+------+
+// | VN | CD | DSTPORT | DSTIP |  USERID  | NULL |
+// +----+----+---------+-------+----------+------+
+// | 1  | 1  |    2    |   4   | variable |  1   |
+// +----+----+---------+-------+----------+------+
+//
+// reply
+// +----+----+---------+-------+
+// | VN | CD | DSTPORT | DSTIP |
+// +----+----+---------+-------+
+// | 1  | 1  |    2    |   4   |
+// +----+----+---------+-------+
+
+const (
+	Ver4 = byte(0x04)
+	// version of reply
+	Ver4Reply = byte(0x00)
+	// reply
+	Reply4Granted  = byte(0x5a)
+	Reply4Rejected = byte(0x5b)
+	// max length of userid and domain
+	maxField4 = 255
+)
+
+// serverHandShake4 handles socks4 and socks4a connect, the version and
+// command are read already
+func (s *StreamSocks) serverHandShake4(cmd byte) error {
+	reply := []byte{Ver4Reply, Reply4Rejected, 0, 0, 0, 0, 0, 0}
+	buffer := make([]byte, PortSize+Ipv4Size)
+	if _, err := io.ReadFull(s.Conn, buffer); err != nil {
+		return err
+	}
+	if _, err := s.readNullEnded(); err != nil {
+		return err
+	}
+	// socks4 has no password, so is refused once users are set
+	if s.needAuth() {
+		s.Conn.Write(reply)
+		return ErrAuthFailed
+	}
+	if cmd != CmdConnect {
+		s.Conn.Write(reply)
+		return ErrCmdNotSupported
+	}
+
+	m := message.NewMetadata().
+		WithRemotePort(int(binary.BigEndian.Uint16(buffer)))
+	ip := buffer[PortSize:]
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		domain, err := s.readNullEnded()
+		if err != nil {
+			return err
+		}
+		m.WithDomain(domain)
+	} else {
+		m.WithRemoteIP(net.IPv4(ip[0], ip[1], ip[2], ip[3]))
+	}
+
+	reply[1] = Reply4Granted
+	if _, err := s.Conn.Write(reply); err != nil {
+		return err
+	}
+	cAddr := s.RemoteAddr().(*net.TCPAddr)
+	s.metadata = m.WithClientIP(cAddr.IP).WithClientPort(cAddr.Port)
+	s.status = StatusConnected
+	return nil
+}
+
+// readNullEnded reads byte by byte, or data after request may be
+// consumed
+func (s *StreamSocks) readNullEnded() (string, error) {
+	b := make([]byte, 0, 16)
+	c := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(s.Conn, c); err != nil {
+			return "", err
+		}
+		if c[0] == 0 {
+			return string(b), nil
+		}
+		if len(b) == maxField4 {
+			return "", ErrNotSupported
+		}
+		b = append(b, c[0])
+	}
+}
@@ -0,0 +1,174 @@
+package socks
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+// queueConn gives datagrams in order then fails
+type queueConn struct {
+	net.PacketConn
+	queue []datagram
+}
+
+type datagram struct {
+	from    *net.UDPAddr
+	payload string
+}
+
+func (q *queueConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if len(q.queue) == 0 {
+		return 0, nil, io.EOF
+	}
+	d := q.queue[0]
+	q.queue = q.queue[1:]
+	data := append([]byte{0, 0, 0, AtypIPv4, 192, 0, 2, 1, 0, 53}, d.payload...)
+	return copy(b, data), d.from, nil
+}
+
+func TestUDPAssociate(t *testing.T) {
+	server := NewProxySocks(proxy.ModeServer)
+	server.AddUser("alice", "secret")
+	client := NewProxySocks(proxy.ModeClient).WithCredential("alice", "secret")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ended := make(chan error, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			ended <- err
+			return
+		}
+		_, err = server.ShadowStreamConn(c, "socks")
+		ended <- err
+	}()
+
+	// greeting, auth and udp associate
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &StreamSocks{Socks: *client, Conn: c, status: StatusFree}
+	c.Write([]byte{Ver, 0x01, MethodPassword})
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(c, reply[:2]); err != nil || reply[1] != MethodPassword {
+		t.Fatalf("method %v: %v", reply[:2], err)
+	}
+	if err := s.clientAuth(); err != nil {
+		t.Fatal(err)
+	}
+	c.Write([]byte{Ver, CmdUdpAssociate, Rsv, AtypIPv4, 0, 0, 0, 0, 0, 0})
+	if _, err := io.ReadFull(c, reply); err != nil || reply[ReplyPos] != ReplySucceeded {
+		t.Fatalf("associate %v: %v", reply, err)
+	}
+
+	local := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000}
+	stranger := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 9), Port: 5000}
+	pc, _ := server.ShadowPacketConn(&queueConn{queue: []datagram{
+		{stranger, "spoofed"},
+		{local, "query"},
+	}})
+	m, _, err := pc.ReadMsgFrom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Payload()) != "query" || m.Metadata().User != "alice" {
+		t.Errorf("got %q of user %q", m.Payload(), m.Metadata().User)
+	}
+
+	// nothing is relayed once associate ends
+	c.Close()
+	select {
+	case err := <-ended:
+		if err != ErrAssociateEnded {
+			t.Errorf("associate ended with %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("associate not ended")
+	}
+	pc, _ = server.ShadowPacketConn(&queueConn{queue: []datagram{{local, "late"}}})
+	if m, _, err := pc.ReadMsgFrom(); err != io.EOF {
+		t.Errorf("late datagram relayed: %v %v", m, err)
+	}
+}
+
+func TestSocks4(t *testing.T) {
+	// request and data following it
+	request := func(ip []byte, userid, domain string) []byte {
+		b := append([]byte{Ver4, CmdConnect, 0x01, 0xbb}, ip...)
+		b = append(append(b, userid...), 0)
+		if domain != "" {
+			b = append(append(b, domain...), 0)
+		}
+		return append(b, "hello"...)
+	}
+	cases := []struct {
+		name    string
+		users   bool
+		request []byte
+		remote  string
+		err     error
+	}{
+		{"socks4", false, request([]byte{192, 0, 2, 1}, "", ""), "192.0.2.1", nil},
+		{"socks4 userid", false, request([]byte{192, 0, 2, 1}, "bob", ""), "192.0.2.1", nil},
+		{"socks4a", false, request([]byte{0, 0, 0, 1}, "bob", "example.org"), "example.org", nil},
+		{"users set", true, request([]byte{192, 0, 2, 1}, "alice", ""), "", ErrAuthFailed},
+		{"bind", false, append([]byte{Ver4, CmdBind, 0, 80, 192, 0, 2, 1}, 0), "", ErrCmdNotSupported},
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for _, c := range cases {
+		server := NewProxySocks(proxy.ModeServer)
+		if c.users {
+			server.AddUser("alice", "secret")
+		}
+		cc, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sc, _ := l.Accept()
+		cc.Write(c.request)
+		s, err := server.ShadowStreamConn(sc, "socks")
+		reply := make([]byte, 8)
+		if _, rerr := io.ReadFull(cc, reply); rerr != nil {
+			t.Fatalf("%v: reply %v", c.name, rerr)
+		}
+		if c.err != nil {
+			if err != c.err || reply[1] != Reply4Rejected {
+				t.Errorf("%v: %v %v", c.name, err, reply)
+			}
+			cc.Close()
+			sc.Close()
+			continue
+		}
+		if err != nil || reply[1] != Reply4Granted {
+			t.Fatalf("%v: %v %v", c.name, err, reply)
+		}
+		m := s.Metadata()
+		remote := m.Domain
+		if remote == "" {
+			remote = m.RemoteIP.String()
+		}
+		if remote != c.remote || m.RemotePort != 443 {
+			t.Errorf("%v: remote %v:%v", c.name, remote, m.RemotePort)
+		}
+		// data after request is left for relay
+		data := make([]byte, 5)
+		if _, err := io.ReadFull(s, data); err != nil || string(data) != "hello" {
+			t.Errorf("%v: data %q %v", c.name, data, err)
+		}
+		cc.Close()
+		s.Close()
+	}
+}
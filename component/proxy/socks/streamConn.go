@@ -1,6 +1,8 @@
 package socks
 
 import (
+	"bytes"
+	"io"
 	"net"
 
 	"github.com/intxff/rdcross/component/message"
@@ -43,6 +45,8 @@ func (s *StreamSocks) serverHandShake() error {
 		atyp byte
 		addr string
 		port int
+		user string
+		err  error
 	)
 	buffer := make([]byte, 300)
 	switch s.status {
@@ -52,6 +56,9 @@ func (s *StreamSocks) serverHandShake() error {
 		if err != nil {
 			return err
 		}
+		if buffer[VerPos] == Ver4 {
+			return s.serverHandShake4(buffer[CmdPos])
+		}
 		if buffer[VerPos] != Ver {
 			return ErrVerNotSupported
 		}
@@ -60,12 +67,25 @@ func (s *StreamSocks) serverHandShake() error {
 	case StatusHandshaking:
 		// select method
 		nmethods := int(buffer[NMethodsPos])
-		if _, err := s.Conn.Read(buffer[:nmethods]); err != nil {
+		if _, err := io.ReadFull(s.Conn, buffer[:nmethods]); err != nil {
 			s.status = StatusFree
 			return err
 		}
-		// no check then return method no auth
-		if _, err := s.Write([]byte{Ver, MethodNoAuth}); err != nil {
+		if s.needAuth() {
+			if bytes.IndexByte(buffer[:nmethods], MethodPassword) == -1 {
+				s.Write([]byte{Ver, MethodNoAcceptable})
+				return ErrAuthFailed
+			}
+			if _, err := s.Write([]byte{Ver, MethodPassword}); err != nil {
+				s.status = StatusFree
+				return err
+			}
+			if user, err = s.serverAuth(); err != nil {
+				s.status = StatusFree
+				return err
+			}
+		} else if _, err := s.Write([]byte{Ver, MethodNoAuth}); err != nil {
+			// no users then return method no auth
 			s.status = StatusFree
 			return err
 		}
@@ -80,18 +100,7 @@ func (s *StreamSocks) serverHandShake() error {
 				s.Write([]byte{Ver, ReplyCmdNotSupported, Rsv, AtypIPv4, 0, 0, 0, 0, 0, 0})
 				return ErrCmdNotSupported
 			}
-            ip := s.LocalAddr().(*net.TCPAddr).IP
-            port := s.LocalAddr().(*net.TCPAddr).Port
-            rep := append([]byte{Ver, ReplyCmdNotSupported, Rsv, AtypIPv4}, ip...)
-            rep = append(rep, byte(port>>8), byte(port))
-			s.Write(rep)
-            // stay here
-            for {
-                _, err := s.Read(make([]byte, 10))
-                if err != nil {
-                    return nil
-                }
-            }
+			return s.associate(user)
 		}
 		// directly return succeed
 		if _, err := s.Write([]byte{Ver, ReplySucceeded, Rsv, AtypIPv4, 0, 0, 0, 0, 0, 0}); err != nil {
@@ -138,7 +147,8 @@ func (s *StreamSocks) serverHandShake() error {
 		cAddr := s.RemoteAddr().(*net.TCPAddr)
 		s.metadata.WithClientIP(cAddr.IP).
 			WithClientPort(cAddr.Port).
-			WithRemotePort(port)
+			WithRemotePort(port).
+			WithUser(user)
 		s.status = StatusConnected
 		fallthrough
 	case StatusConnected:
@@ -152,12 +162,28 @@ func (s *StreamSocks) clientHandShake() error {
 	switch s.status {
 	case StatusFree:
 		// start handshaking
-		if _, err := s.Conn.Write([]byte{Ver, 0x01, MethodNoAuth}); err != nil {
+		methods := []byte{Ver, 0x01, MethodNoAuth}
+		if s.username != "" {
+			methods = []byte{Ver, 0x02, MethodNoAuth, MethodPassword}
+		}
+		if _, err := s.Conn.Write(methods); err != nil {
 			return err
 		}
-		if _, err := s.Conn.Read(buffer[:2]); err != nil {
+		if _, err := io.ReadFull(s.Conn, buffer[:2]); err != nil {
 			return err
 		}
+		switch buffer[ServerMethodsPos] {
+		case MethodNoAuth:
+		case MethodPassword:
+			if s.username == "" {
+				return ErrAuthFailed
+			}
+			if err := s.clientAuth(); err != nil {
+				return err
+			}
+		default:
+			return ErrAuthFailed
+		}
 		s.status = StatusHandshaking
 		fallthrough
 	case StatusHandshaking:
@@ -235,3 +261,31 @@ func (s *StreamSocks) ReadMux() (message.Message, error) {
 func (s *StreamSocks) WriteMux(msg message.Message) error {
 	return ErrNotSupported
 }
+
+// associate accepts udp from client while the connection of udp
+// associate is kept, relay address is the one connection arrives at
+func (s *StreamSocks) associate(user string) error {
+	cIP := s.RemoteAddr().(*net.TCPAddr).IP
+	lAddr := s.LocalAddr().(*net.TCPAddr)
+	rep := []byte{Ver, ReplySucceeded, Rsv, AtypIPv6}
+	if ip := lAddr.IP.To4(); ip != nil {
+		rep[AtypPos] = AtypIPv4
+		rep = append(rep, ip...)
+	} else {
+		rep = append(rep, lAddr.IP.To16()...)
+	}
+	rep = append(rep, byte(lAddr.Port>>8), byte(lAddr.Port))
+	if _, err := s.Write(rep); err != nil {
+		return err
+	}
+
+	s.assoc.add(cIP, user)
+	defer s.assoc.remove(cIP)
+	// stay here till client closes
+	buf := make([]byte, 256)
+	for {
+		if _, err := s.Read(buf); err != nil {
+			return ErrAssociateEnded
+		}
+	}
+}
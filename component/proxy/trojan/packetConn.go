@@ -0,0 +1,122 @@
+package trojan
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+type udpMsg struct {
+	payload  []byte
+	metadata *message.Metadata
+}
+
+func (u *udpMsg) Metadata() *message.Metadata {
+	return u.metadata
+}
+
+func (u *udpMsg) Others() any {
+	return nil
+}
+
+func (u *udpMsg) Payload() []byte {
+	return u.payload
+}
+
+// PacketTrojan carries udp packets in a stream connection
+type PacketTrojan struct {
+	Trojan
+	net.Conn
+	reader *bufio.Reader
+	// server mode, metadata of udp associate request
+	metadata *message.Metadata
+}
+
+func newPacketTrojan(t Trojan, c net.Conn, m *message.Metadata) *PacketTrojan {
+	return &PacketTrojan{
+		Trojan:   t,
+		Conn:     c,
+		reader:   bufio.NewReader(c),
+		metadata: m,
+	}
+}
+
+func (p *PacketTrojan) Metadata() *message.Metadata {
+	return p.metadata
+}
+
+// addr gets address of peer, server mode takes tcp address of client
+// as its udp address
+func (p *PacketTrojan) addr() net.Addr {
+	if tcp, ok := p.RemoteAddr().(*net.TCPAddr); ok {
+		return &net.UDPAddr{IP: tcp.IP, Port: tcp.Port, Zone: tcp.Zone}
+	}
+	return p.RemoteAddr()
+}
+
+func (p *PacketTrojan) ReadMsgFrom() (message.Message, net.Addr, error) {
+	m := &udpMsg{metadata: message.NewMetadata()}
+	if err := readAddr(p.reader, m.metadata); err != nil {
+		return nil, p.addr(), err
+	}
+	l := make([]byte, 2)
+	if _, err := io.ReadFull(p.reader, l); err != nil {
+		return nil, p.addr(), err
+	}
+	if err := readCRLF(p.reader); err != nil {
+		return nil, p.addr(), err
+	}
+	m.payload = make([]byte, binary.BigEndian.Uint16(l))
+	if _, err := io.ReadFull(p.reader, m.payload); err != nil {
+		return nil, p.addr(), err
+	}
+	if p.mode == proxy.ModeServer {
+		m.metadata.WithClientIP(p.metadata.ClientIP).
+			WithClientPort(p.metadata.ClientPort).
+			WithUser(p.metadata.User)
+	}
+	return m, p.addr(), nil
+}
+
+// WriteMsgTo writes packet to peer of the stream as one trojan packet,
+// addr is ignored. Packet larger than maxPayloadSize is refused.
+func (p *PacketTrojan) WriteMsgTo(msg message.Message, addr net.Addr) error {
+	payload := msg.Payload()
+	if len(payload) > maxPayloadSize {
+		return proxy.ErrNotSupported
+	}
+	buf := make([]byte, 0, 1+1+255+2+2+2+len(payload))
+	buf = appendAddr(buf, msg.Metadata())
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(payload)))
+	buf = append(buf, crlf...)
+	buf = append(buf, payload...)
+	_, err := p.Conn.Write(buf)
+	return err
+}
+
+// ReadFrom reads payload of a packet, address is that of remote
+func (p *PacketTrojan) ReadFrom(b []byte) (int, net.Addr, error) {
+	msg, _, err := p.ReadMsgFrom()
+	if err != nil {
+		return 0, nil, err
+	}
+	m := msg.Metadata()
+	return copy(b, msg.Payload()), &net.UDPAddr{IP: m.RemoteIP, Port: m.RemotePort}, nil
+}
+
+// WriteTo writes packet to remote at addr, which has to be udp address
+func (p *PacketTrojan) WriteTo(b []byte, addr net.Addr) (int, error) {
+	u, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, proxy.ErrNotSupported
+	}
+	m := message.NewMetadata().WithRemoteIP(u.IP).WithRemotePort(u.Port)
+	if err := p.WriteMsgTo(&udpMsg{payload: b, metadata: m}, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,124 @@
+package trojan
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/intxff/rdcross/component/message"
+)
+
+// request, in tls
+// +-----------------------+---------+----------------+---------+----------+
+// | hex(SHA224(password)) |  CRLF   | Trojan Request |  CRLF   | Payload  |
+// +-----------------------+---------+----------------+---------+----------+
+// |          56           | X'0D0A' |    Variable    | X'0D0A' | Variable |
+// +-----------------------+---------+----------------+---------+----------+
+//
+// trojan request
+// +-----+------+----------+----------+
+// | CMD | ATYP | DST.ADDR | DST.PORT |
+// +-----+------+----------+----------+
+// |  1  |  1   | Variable |    2     |
+// +-----+------+----------+----------+
+//
+// udp packet of udp associate
+// +------+----------+----------+--------+---------+----------+
+// | ATYP | DST.ADDR | DST.PORT | Length |  CRLF   | Payload  |
+// +------+----------+----------+--------+---------+----------+
+// |  1   | Variable |    2     |   2    | X'0D0A' | Variable |
+// +------+----------+----------+--------+---------+----------+
+
+const (
+	hashSize = 56
+	// commands
+	CmdConnect      = byte(0x01)
+	CmdUdpAssociate = byte(0x03)
+	// address types
+	AtypIPv4   = byte(0x01)
+	AtypDomain = byte(0x03)
+	AtypIPv6   = byte(0x04)
+
+	maxPayloadSize = 8192
+	// time client has to send hash of request, silent ones are taken
+	// as probes
+	headTimeout = 5 * time.Second
+)
+
+var crlf = []byte{'\r', '\n'}
+
+var (
+	ErrAuthFailed      = errors.New("auth failed")
+	ErrBadRequest      = errors.New("bad request")
+	ErrCmdNotSupported = errors.New("cmd not supported")
+)
+
+// passwordHash gets hex of SHA224 of password
+func passwordHash(password string) string {
+	h := sha256.Sum224([]byte(password))
+	return hex.EncodeToString(h[:])
+}
+
+// appendAddr appends ATYP, DST.ADDR and DST.PORT of m
+func appendAddr(b []byte, m *message.Metadata) []byte {
+	switch {
+	case m.Domain != "":
+		b = append(b, AtypDomain, byte(len(m.Domain)))
+		b = append(b, m.Domain...)
+	case m.RemoteIP.To4() != nil:
+		b = append(b, AtypIPv4)
+		b = append(b, m.RemoteIP.To4()...)
+	default:
+		b = append(b, AtypIPv6)
+		b = append(b, m.RemoteIP.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(m.RemotePort))
+}
+
+// readAddr reads ATYP, DST.ADDR and DST.PORT into m
+func readAddr(r io.Reader, m *message.Metadata) error {
+	buf := make([]byte, 1+255+2)
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return err
+	}
+	var l int
+	atyp := buf[0]
+	switch atyp {
+	case AtypIPv4:
+		l = net.IPv4len
+	case AtypIPv6:
+		l = net.IPv6len
+	case AtypDomain:
+		if _, err := io.ReadFull(r, buf[:1]); err != nil {
+			return err
+		}
+		l = int(buf[0])
+	default:
+		return ErrBadRequest
+	}
+	if _, err := io.ReadFull(r, buf[:l+2]); err != nil {
+		return err
+	}
+	if atyp == AtypDomain {
+		m.WithDomain(string(buf[:l]))
+	} else {
+		m.WithRemoteIP(net.IP(append([]byte(nil), buf[:l]...)))
+	}
+	m.WithRemotePort(int(binary.BigEndian.Uint16(buf[l:])))
+	return nil
+}
+
+func readCRLF(r io.Reader) error {
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	if buf[0] != '\r' || buf[1] != '\n' {
+		return ErrBadRequest
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package trojan
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/intxff/rdcross/component/conn"
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+type StreamTrojan struct {
+	Trojan
+	net.Conn
+	metadata *message.Metadata
+	// server mode, udp associate
+	packet *PacketTrojan
+}
+
+func newStreamTrojan(t Trojan, c net.Conn, m *message.Metadata) (*StreamTrojan, error) {
+	st := &StreamTrojan{
+		Trojan:   t,
+		Conn:     c,
+		metadata: m,
+	}
+	var err error
+	if t.mode == proxy.ModeServer {
+		err = st.serverHandShake()
+	} else {
+		err = st.clientHandShake()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s *StreamTrojan) serverHandShake() error {
+	head, err := s.readHead()
+	if err != nil {
+		s.fallbackTo(head)
+		return err
+	}
+	user, exist := s.lookup(string(head[:hashSize]))
+	if !exist {
+		s.fallbackTo(head)
+		return ErrAuthFailed
+	}
+
+	cmd := make([]byte, 1)
+	if _, err := io.ReadFull(s.Conn, cmd); err != nil {
+		return err
+	}
+	cAddr := s.RemoteAddr().(*net.TCPAddr)
+	m := message.NewMetadata().WithClientIP(cAddr.IP).
+		WithClientPort(cAddr.Port).
+		WithIngress(s.metadata.Ingress).
+		WithUser(user)
+	if err := readAddr(s.Conn, m); err != nil {
+		return err
+	}
+	if err := readCRLF(s.Conn); err != nil {
+		return err
+	}
+	s.metadata = m
+
+	switch cmd[0] {
+	case CmdConnect:
+	case CmdUdpAssociate:
+		s.packet = newPacketTrojan(s.Trojan, s.Conn, m)
+	default:
+		return ErrCmdNotSupported
+	}
+	return nil
+}
+
+// readHead reads hash and CRLF of request. It stops as soon as bytes
+// read can't be a request, or client is silent till deadline, so that
+// probes get answered by fallback instead of waiting for more.
+func (s *StreamTrojan) readHead() ([]byte, error) {
+	s.Conn.SetReadDeadline(time.Now().Add(headTimeout))
+	defer s.Conn.SetReadDeadline(time.Time{})
+	head := make([]byte, hashSize+2)
+	read := 0
+	for read < len(head) {
+		n, err := s.Conn.Read(head[read:])
+		for i := read; i < read+n; i++ {
+			if !validHead(i, head[i]) {
+				return head[:read+n], ErrAuthFailed
+			}
+		}
+		read += n
+		if err != nil {
+			return head[:read], err
+		}
+	}
+	return head, nil
+}
+
+// validHead checks b at i of request is lowercase hex of hash or CRLF
+func validHead(i int, b byte) bool {
+	switch {
+	case i < hashSize:
+		return '0' <= b && b <= '9' || 'a' <= b && b <= 'f'
+	case i == hashSize:
+		return b == '\r'
+	}
+	return b == '\n'
+}
+
+// fallbackTo relays connection with bytes read to fallback, or leaves
+// it closed if fallback is not set
+func (s *StreamTrojan) fallbackTo(read []byte) {
+	if s.fallback == "" {
+		return
+	}
+	rc, err := net.Dial("tcp", s.fallback)
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+	if _, err := rc.Write(read); err != nil {
+		return
+	}
+	go io.Copy(rc, s.Conn)
+	io.Copy(s.Conn, rc)
+}
+
+func (s *StreamTrojan) clientHandShake() error {
+	req := make([]byte, 0, hashSize+2+1+1+1+255+2+2)
+	req = append(req, s.hash...)
+	req = append(req, crlf...)
+	req = append(req, CmdConnect)
+	req = appendAddr(req, s.metadata)
+	req = append(req, crlf...)
+	_, err := s.Conn.Write(req)
+	return err
+}
+
+// PacketConn implements conn.PacketStreamConn, it is nil unless client
+// requests udp associate
+func (s *StreamTrojan) PacketConn() conn.ProxyPacketConn {
+	if s.packet == nil {
+		return nil
+	}
+	return s.packet
+}
+
+func (s *StreamTrojan) Metadata() *message.Metadata {
+	return s.metadata
+}
+
+func (s *StreamTrojan) ReadMux() (message.Message, error) {
+	return nil, proxy.ErrNotSupported
+}
+
+func (s *StreamTrojan) WriteMux(msg message.Message) error {
+	return proxy.ErrNotSupported
+}
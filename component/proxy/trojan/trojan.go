@@ -0,0 +1,121 @@
+package trojan
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+
+	"github.com/intxff/rdcross/component/conn"
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+type Trojan struct {
+	mode int
+	// client mode, hash of password
+	hash string
+	udp  bool
+	tls  *tls.Config
+	// server mode, users by hash of password
+	users *userList
+	// server mode, where connections failed to authenticate go
+	fallback string
+}
+
+type userList struct {
+	mu    sync.RWMutex
+	users map[string]string
+}
+
+// NewProxyTrojan creates trojan proxy, config of server mode has to
+// have certificates. Password of server is taken as an anonymous user.
+func NewProxyTrojan(mode int, password string, config *tls.Config, udp bool) *Trojan {
+	t := &Trojan{
+		mode:  mode,
+		hash:  passwordHash(password),
+		udp:   udp,
+		tls:   config,
+		users: &userList{users: make(map[string]string)},
+	}
+	if mode == proxy.ModeServer && password != "" {
+		t.users.users[t.hash] = ""
+	}
+	return t
+}
+
+// WithFallback sets address connections failed to authenticate are
+// relayed to, usually a web server, so probes see a normal website
+func (t *Trojan) WithFallback(addr string) *Trojan {
+	t.fallback = addr
+	return t
+}
+
+// AddUser adds user of server
+func (t *Trojan) AddUser(name, password string) {
+	t.users.mu.Lock()
+	defer t.users.mu.Unlock()
+	t.users.users[passwordHash(password)] = name
+}
+
+// RemoveUser forgets password hash of name, so a connection carrying it
+// is handled as a mismatch
+func (t *Trojan) RemoveUser(name string) bool {
+	t.users.mu.Lock()
+	defer t.users.mu.Unlock()
+	for k, v := range t.users.users {
+		if v == name {
+			delete(t.users.users, k)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Trojan) lookup(hash string) (string, bool) {
+	t.users.mu.RLock()
+	defer t.users.mu.RUnlock()
+	name, exist := t.users.users[hash]
+	return name, exist
+}
+
+func (t *Trojan) Cipher() proxy.Cipher {
+	return nil
+}
+
+func (t *Trojan) ShadowStreamConn(c net.Conn, extra ...any) (conn.ProxyStreamConn, error) {
+	if t.mode == proxy.ModeServer {
+		m := message.NewMetadata().WithIngress(extra[0].(string))
+		return newStreamTrojan(*t, tls.Server(c, t.tls), m)
+	}
+	return newStreamTrojan(*t, tls.Client(c, t.tls), extra[0].(*message.Metadata))
+}
+
+// ShadowPacketConn is not supported, udp of trojan is carried in stream
+func (t *Trojan) ShadowPacketConn(c net.PacketConn, extra ...any) (conn.ProxyPacketConn, error) {
+	return nil, proxy.ErrNotSupported
+}
+
+// StreamPacketConn implements proxy.PacketOverStream, sends udp
+// associate request and then udp packets in c
+func (t *Trojan) StreamPacketConn(c net.Conn, extra ...any) (conn.ProxyPacketConn, error) {
+	if !t.udp {
+		return nil, proxy.ErrNotSupported
+	}
+	tc := tls.Client(c, t.tls)
+	req := append([]byte(t.hash), crlf...)
+	req = append(req, CmdUdpAssociate)
+	req = appendAddr(req, extra[0].(*message.Metadata))
+	req = append(req, crlf...)
+	if _, err := tc.Write(req); err != nil {
+		return nil, err
+	}
+	return newPacketTrojan(*t, tc, nil), nil
+}
+
+func (t *Trojan) Type() proxy.ProxyType {
+	return proxy.TypeTrojan
+}
+
+func (t *Trojan) TcpMux() bool {
+	return false
+}
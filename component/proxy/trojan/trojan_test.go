@@ -0,0 +1,189 @@
+package trojan
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/intxff/rdcross/component/conn"
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+func newTestCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTrojan(t *testing.T) {
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{newTestCert(t)}}
+	server := NewProxyTrojan(proxy.ModeServer, "", serverConfig, true)
+	server.AddUser("alice", "secret")
+	clientConfig := &tls.Config{ServerName: "example.com", InsecureSkipVerify: true}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	type result struct {
+		sc  conn.ProxyStreamConn
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			sc, err := server.ShadowStreamConn(c, "in")
+			results <- result{sc, err}
+		}
+	}()
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	m := message.NewMetadata().WithDomain("example.org").WithRemotePort(443)
+
+	// tcp
+	client := NewProxyTrojan(proxy.ModeClient, "secret", clientConfig, true)
+	cc, err := client.ShadowStreamConn(dial(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc.Write([]byte("ping"))
+	r := <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if got := r.sc.Metadata(); got.Domain != "example.org" || got.RemotePort != 443 || got.User != "alice" {
+		t.Errorf("metadata %+v", got)
+	}
+	buf := make([]byte, 4)
+	if n, err := r.sc.Read(buf); err != nil || string(buf[:n]) != "ping" {
+		t.Errorf("read %q %v", buf[:n], err)
+	}
+	cc.Close()
+
+	// udp
+	cp, err := client.StreamPacketConn(dial(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := message.NewMetadata().WithRemoteIP(net.IPv4(192, 0, 2, 1).To4()).WithRemotePort(53)
+	// datagram too large is refused rather than split
+	if err := cp.WriteMsgTo(&udpMsg{payload: make([]byte, maxPayloadSize+1), metadata: dst}, nil); err == nil {
+		t.Error("packet larger than limit written")
+	}
+	if err := cp.WriteMsgTo(&udpMsg{payload: []byte("query"), metadata: dst}, nil); err != nil {
+		t.Fatal(err)
+	}
+	r = <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	sp := r.sc.(conn.PacketStreamConn).PacketConn()
+	if sp == nil {
+		t.Fatal("udp associate not recognized")
+	}
+	msg, _, err := sp.ReadMsgFrom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Payload()) != "query" || !msg.Metadata().RemoteIP.Equal(dst.RemoteIP) || msg.Metadata().User != "alice" {
+		t.Errorf("packet %q %+v", msg.Payload(), msg.Metadata())
+	}
+	if err := sp.WriteMsgTo(&udpMsg{payload: []byte("answer"), metadata: dst}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _, err = cp.ReadMsgFrom(); err != nil || string(msg.Payload()) != "answer" {
+		t.Errorf("answer %v", err)
+	}
+	cp.Close()
+
+	// wrong password
+	bad := NewProxyTrojan(proxy.ModeClient, "wrong", clientConfig, true)
+	if _, err := bad.ShadowStreamConn(dial(), m); err != nil {
+		t.Fatal(err)
+	}
+	if r = <-results; r.err != ErrAuthFailed {
+		t.Errorf("wrong password: %v", r.err)
+	}
+}
+
+func TestTrojanFallback(t *testing.T) {
+	fl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fl.Close()
+	// fallback answers with what it gets
+	go func() {
+		c, err := fl.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 256)
+		n, _ := c.Read(buf)
+		c.Write(append([]byte("fallback "), buf[:n]...))
+	}()
+
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{newTestCert(t)}}
+	server := NewProxyTrojan(proxy.ModeServer, "secret", serverConfig, true).
+		WithFallback(fl.Addr().String())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		server.ShadowStreamConn(c, "in")
+	}()
+
+	// a probe shorter than hash gets answer of fallback at once
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := tls.Client(c, &tls.Config{ServerName: "example.com", InsecureSkipVerify: true})
+	defer probe.Close()
+	probe.SetDeadline(time.Now().Add(headTimeout / 2))
+	req := "GET / HTTP/1.1\r\n\r\n"
+	if _, err := probe.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 256)
+	n, err := probe.Read(buf)
+	if err != nil || string(buf[:n]) != "fallback "+req {
+		t.Errorf("probe got %q %v", buf[:n], err)
+	}
+}
@@ -0,0 +1,86 @@
+package vless
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+type udpMsg struct {
+	payload  []byte
+	metadata *message.Metadata
+}
+
+func (u *udpMsg) Metadata() *message.Metadata {
+	return u.metadata
+}
+
+func (u *udpMsg) Others() any {
+	return nil
+}
+
+func (u *udpMsg) Payload() []byte {
+	return u.payload
+}
+
+// PacketVLESS carries udp packets to remote of request, each prefixed
+// by its length
+type PacketVLESS struct {
+	*StreamVLESS
+}
+
+// addr gets address of peer, server mode takes tcp address of client
+// as its udp address
+func (p *PacketVLESS) addr() net.Addr {
+	if tcp, ok := p.RemoteAddr().(*net.TCPAddr); ok {
+		return &net.UDPAddr{IP: tcp.IP, Port: tcp.Port, Zone: tcp.Zone}
+	}
+	return p.RemoteAddr()
+}
+
+func (p *PacketVLESS) ReadMsgFrom() (message.Message, net.Addr, error) {
+	l := make([]byte, 2)
+	if _, err := io.ReadFull(p.StreamVLESS, l); err != nil {
+		return nil, p.addr(), err
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(l))
+	if _, err := io.ReadFull(p.StreamVLESS, payload); err != nil {
+		return nil, p.addr(), err
+	}
+	m := *p.metadata
+	if p.mode == proxy.ModeClient {
+		m.ClientIP, m.ClientPort = nil, 0
+	}
+	return &udpMsg{payload: payload, metadata: &m}, p.addr(), nil
+}
+
+// WriteMsgTo writes packet to remote of request, addr is ignored
+func (p *PacketVLESS) WriteMsgTo(msg message.Message, addr net.Addr) error {
+	payload := msg.Payload()
+	if len(payload) > maxPayloadSize {
+		return proxy.ErrNotSupported
+	}
+	buf := make([]byte, 0, 2+len(payload))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(payload)))
+	buf = append(buf, payload...)
+	_, err := p.Conn.Write(buf)
+	return err
+}
+
+func (p *PacketVLESS) ReadFrom(b []byte) (int, net.Addr, error) {
+	msg, _, err := p.ReadMsgFrom()
+	if err != nil {
+		return 0, nil, err
+	}
+	return copy(b, msg.Payload()), &net.UDPAddr{IP: p.metadata.RemoteIP, Port: p.metadata.RemotePort}, nil
+}
+
+func (p *PacketVLESS) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if err := p.WriteMsgTo(&udpMsg{payload: b}, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
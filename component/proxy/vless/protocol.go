@@ -0,0 +1,114 @@
+package vless
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/intxff/rdcross/component/message"
+)
+
+// request
+// +-----+------+--------+--------+-----+------+------+----------+----------+
+// | VER | UUID | ADDONS | ADDONS | CMD | PORT | ATYP | DST.ADDR | Payload  |
+// |     |      | LENGTH |        |     |      |      |          |          |
+// +-----+------+--------+--------+-----+------+------+----------+----------+
+// |  1  |  16  |   1    |   M    |  1  |  2   |  1   | Variable | Variable |
+// +-----+------+--------+--------+-----+------+------+----------+----------+
+//
+// response
+// +-----+---------------+--------+----------+
+// | VER | ADDONS LENGTH | ADDONS | Payload  |
+// +-----+---------------+--------+----------+
+// |  1  |       1       |   N    | Variable |
+// +-----+---------------+--------+----------+
+//
+// udp packet, all to DST.ADDR of request
+// +--------+----------+
+// | Length | Payload  |
+// +--------+----------+
+// |   2    | Variable |
+// +--------+----------+
+
+const (
+	version = byte(0x00)
+	// commands
+	CmdTCP = byte(0x01)
+	CmdUDP = byte(0x02)
+	// address types
+	AtypIPv4   = byte(0x01)
+	AtypDomain = byte(0x02)
+	AtypIPv6   = byte(0x03)
+
+	maxPayloadSize = 0xffff
+)
+
+var (
+	ErrAuthFailed      = errors.New("auth failed")
+	ErrBadHeader       = errors.New("bad header")
+	ErrCmdNotSupported = errors.New("cmd not supported")
+)
+
+// appendRequest appends request header of cmd to m by user id
+func appendRequest(b []byte, id []byte, cmd byte, m *message.Metadata) []byte {
+	b = append(b, version)
+	b = append(b, id...)
+	b = append(b, 0, cmd)
+	b = binary.BigEndian.AppendUint16(b, uint16(m.RemotePort))
+	switch {
+	case m.Domain != "":
+		b = append(b, AtypDomain, byte(len(m.Domain)))
+		b = append(b, m.Domain...)
+	case m.RemoteIP.To4() != nil:
+		b = append(b, AtypIPv4)
+		b = append(b, m.RemoteIP.To4()...)
+	default:
+		b = append(b, AtypIPv6)
+		b = append(b, m.RemoteIP.To16()...)
+	}
+	return b
+}
+
+// readAddr reads PORT, ATYP and DST.ADDR into m
+func readAddr(r io.Reader, m *message.Metadata) error {
+	buf := make([]byte, 2+1+255)
+	if _, err := io.ReadFull(r, buf[:3]); err != nil {
+		return err
+	}
+	m.WithRemotePort(int(binary.BigEndian.Uint16(buf)))
+	switch buf[2] {
+	case AtypIPv4:
+		if _, err := io.ReadFull(r, buf[:net.IPv4len]); err != nil {
+			return err
+		}
+		m.WithRemoteIP(net.IP(append([]byte(nil), buf[:net.IPv4len]...)))
+	case AtypIPv6:
+		if _, err := io.ReadFull(r, buf[:net.IPv6len]); err != nil {
+			return err
+		}
+		m.WithRemoteIP(net.IP(append([]byte(nil), buf[:net.IPv6len]...)))
+	case AtypDomain:
+		if _, err := io.ReadFull(r, buf[:1]); err != nil {
+			return err
+		}
+		l := int(buf[0])
+		if _, err := io.ReadFull(r, buf[:l]); err != nil {
+			return err
+		}
+		m.WithDomain(string(buf[:l]))
+	default:
+		return ErrBadHeader
+	}
+	return nil
+}
+
+// skipAddons reads length of addons and discards them
+func skipAddons(r io.Reader) error {
+	l := make([]byte, 1)
+	if _, err := io.ReadFull(r, l); err != nil {
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, int64(l[0]))
+	return err
+}
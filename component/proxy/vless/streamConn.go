@@ -0,0 +1,127 @@
+package vless
+
+import (
+	"io"
+	"net"
+
+	"github.com/intxff/rdcross/component/conn"
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+type StreamVLESS struct {
+	VLESS
+	net.Conn
+	metadata *message.Metadata
+	cmd      byte
+	// client mode, response header is read before the first payload
+	responded bool
+}
+
+func newStreamVLESS(v VLESS, c net.Conn, m *message.Metadata, cmd byte) (*StreamVLESS, error) {
+	s := &StreamVLESS{
+		VLESS:    v,
+		Conn:     c,
+		metadata: m,
+		cmd:      cmd,
+	}
+	var err error
+	if v.mode == proxy.ModeServer {
+		err = s.serverHandShake()
+	} else {
+		err = s.clientHandShake()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *StreamVLESS) serverHandShake() error {
+	head := make([]byte, 1+16)
+	if _, err := io.ReadFull(s.Conn, head); err != nil {
+		return err
+	}
+	if head[0] != version {
+		return ErrBadHeader
+	}
+	var id [16]byte
+	copy(id[:], head[1:])
+	user, exist := s.lookup(id)
+	if !exist {
+		return ErrAuthFailed
+	}
+	if err := skipAddons(s.Conn); err != nil {
+		return err
+	}
+	cmd := make([]byte, 1)
+	if _, err := io.ReadFull(s.Conn, cmd); err != nil {
+		return err
+	}
+	if s.cmd = cmd[0]; s.cmd != CmdTCP && s.cmd != CmdUDP {
+		return ErrCmdNotSupported
+	}
+
+	cAddr := s.RemoteAddr().(*net.TCPAddr)
+	m := message.NewMetadata().WithClientIP(cAddr.IP).
+		WithClientPort(cAddr.Port).
+		WithIngress(s.metadata.Ingress).
+		WithUser(user)
+	if err := readAddr(s.Conn, m); err != nil {
+		return err
+	}
+	s.metadata = m
+	_, err := s.Conn.Write([]byte{version, 0})
+	return err
+}
+
+func (s *StreamVLESS) clientHandShake() error {
+	req := appendRequest(make([]byte, 0, 1+16+1+1+2+1+1+255), s.id, s.cmd, s.metadata)
+	_, err := s.Conn.Write(req)
+	return err
+}
+
+func (s *StreamVLESS) Read(b []byte) (int, error) {
+	if s.mode == proxy.ModeClient && !s.responded {
+		if err := s.readResponse(); err != nil {
+			return 0, err
+		}
+	}
+	return s.Conn.Read(b)
+}
+
+func (s *StreamVLESS) readResponse() error {
+	ver := make([]byte, 1)
+	if _, err := io.ReadFull(s.Conn, ver); err != nil {
+		return err
+	}
+	if ver[0] != version {
+		return ErrBadHeader
+	}
+	if err := skipAddons(s.Conn); err != nil {
+		return err
+	}
+	s.responded = true
+	return nil
+}
+
+// PacketConn implements conn.PacketStreamConn
+func (s *StreamVLESS) PacketConn() conn.ProxyPacketConn {
+	if s.cmd != CmdUDP {
+		return nil
+	}
+	return &PacketVLESS{StreamVLESS: s}
+}
+
+func (s *StreamVLESS) Metadata() *message.Metadata {
+	return s.metadata
+}
+
+func (s *StreamVLESS) ReadMux() (message.Message, error) {
+	return nil, proxy.ErrNotSupported
+}
+
+func (s *StreamVLESS) WriteMux(msg message.Message) error {
+	return proxy.ErrNotSupported
+}
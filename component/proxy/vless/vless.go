@@ -0,0 +1,121 @@
+package vless
+
+import (
+	"net"
+	"sync"
+
+	"github.com/intxff/rdcross/component/conn"
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+	"github.com/intxff/rdcross/component/proxy/vmess"
+)
+
+// VLESS authenticates by uuid only and leaves payload as it is, it is
+// meant to run over encrypted transports like tls
+type VLESS struct {
+	mode int
+	// client mode
+	id  []byte
+	udp bool
+	// server mode
+	users *userList
+}
+
+type userList struct {
+	mu    sync.RWMutex
+	users map[[16]byte]string
+}
+
+// NewProxyVLESS creates vless proxy, uuid of server is taken as an
+// anonymous user
+func NewProxyVLESS(mode int, uuid string, udp bool) (*VLESS, error) {
+	v := &VLESS{
+		mode:  mode,
+		udp:   udp,
+		users: &userList{users: make(map[[16]byte]string)},
+	}
+	if uuid == "" {
+		return v, nil
+	}
+	if mode == proxy.ModeServer {
+		return v, v.AddUser("", uuid)
+	}
+	id, err := vmess.ParseUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	v.id = id
+	return v, nil
+}
+
+// AddUser adds or replaces user of server
+func (v *VLESS) AddUser(name, uuid string) error {
+	id, err := vmess.ParseUUID(uuid)
+	if err != nil {
+		return err
+	}
+	var k [16]byte
+	copy(k[:], id)
+	v.users.mu.Lock()
+	defer v.users.mu.Unlock()
+	v.users.users[k] = name
+	return nil
+}
+
+// RemoveUser drops uuid of name from the users looked up by request
+func (v *VLESS) RemoveUser(name string) bool {
+	v.users.mu.Lock()
+	defer v.users.mu.Unlock()
+	for k, u := range v.users.users {
+		if u == name {
+			delete(v.users.users, k)
+			return true
+		}
+	}
+	return false
+}
+
+func (v *VLESS) lookup(id [16]byte) (string, bool) {
+	v.users.mu.RLock()
+	defer v.users.mu.RUnlock()
+	name, exist := v.users.users[id]
+	return name, exist
+}
+
+func (v *VLESS) Cipher() proxy.Cipher {
+	return nil
+}
+
+func (v *VLESS) ShadowStreamConn(c net.Conn, extra ...any) (conn.ProxyStreamConn, error) {
+	if v.mode == proxy.ModeServer {
+		m := message.NewMetadata().WithIngress(extra[0].(string))
+		return newStreamVLESS(*v, c, m, 0)
+	}
+	return newStreamVLESS(*v, c, extra[0].(*message.Metadata), CmdTCP)
+}
+
+// ShadowPacketConn is not supported, udp of vless is carried in stream
+func (v *VLESS) ShadowPacketConn(c net.PacketConn, extra ...any) (conn.ProxyPacketConn, error) {
+	return nil, proxy.ErrNotSupported
+}
+
+// StreamPacketConn implements proxy.PacketOverStream, packets in c are
+// all sent to remote in metadata
+func (v *VLESS) StreamPacketConn(c net.Conn, extra ...any) (conn.ProxyPacketConn, error) {
+	if !v.udp {
+		return nil, proxy.ErrNotSupported
+	}
+	s, err := newStreamVLESS(*v, c, extra[0].(*message.Metadata), CmdUDP)
+	if err != nil {
+		return nil, err
+	}
+	return &PacketVLESS{StreamVLESS: s}, nil
+}
+
+func (v *VLESS) Type() proxy.ProxyType {
+	return proxy.TypeVLESS
+}
+
+func (v *VLESS) TcpMux() bool {
+	return false
+}
@@ -0,0 +1,127 @@
+package vless
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/intxff/rdcross/component/conn"
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+const (
+	testUUID  = "b831381d-6324-4d53-ad4f-8cda48b30811"
+	otherUUID = "27848739-7e62-4138-9fd3-098a63964b6b"
+)
+
+func TestVLESS(t *testing.T) {
+	server, err := NewProxyVLESS(proxy.ModeServer, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.AddUser("alice", testUUID); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	type result struct {
+		sc  conn.ProxyStreamConn
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			sc, err := server.ShadowStreamConn(c, "in")
+			results <- result{sc, err}
+		}
+	}()
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// tcp
+	client, err := NewProxyVLESS(proxy.ModeClient, testUUID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := message.NewMetadata().WithDomain("example.org").WithRemotePort(443)
+	cc, err := client.ShadowStreamConn(dial(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc.Write([]byte("ping"))
+	r := <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if m := r.sc.Metadata(); m.Domain != "example.org" || m.RemotePort != 443 || m.User != "alice" {
+		t.Errorf("metadata %+v", m)
+	}
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(r.sc, got); err != nil || string(got) != "ping" {
+		t.Fatalf("request %q %v", got, err)
+	}
+	r.sc.Write([]byte("pong"))
+	r.sc.Close()
+	if _, err := io.ReadFull(cc, got); err != nil || string(got) != "pong" {
+		t.Fatalf("response %q %v", got, err)
+	}
+	cc.Close()
+
+	// udp over stream, packets keep their boundaries
+	m = message.NewMetadata().WithRemoteIP(net.IPv4(192, 0, 2, 1)).WithRemotePort(53)
+	cp, err := client.StreamPacketConn(dial(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{"query", "again"} {
+		if err := cp.WriteMsgTo(&udpMsg{payload: []byte(q), metadata: m}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r = <-results
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	sp := r.sc.(conn.PacketStreamConn).PacketConn()
+	if sp == nil {
+		t.Fatal("no packet conn")
+	}
+	for _, q := range []string{"query", "again"} {
+		msg, _, err := sp.ReadMsgFrom()
+		if err != nil || string(msg.Payload()) != q || !msg.Metadata().RemoteIP.Equal(m.RemoteIP) {
+			t.Fatalf("query %v", err)
+		}
+	}
+	if err := sp.WriteMsgTo(&udpMsg{payload: []byte("answer")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	msg, _, err := cp.ReadMsgFrom()
+	if err != nil || string(msg.Payload()) != "answer" {
+		t.Errorf("answer %v", err)
+	}
+	r.sc.Close()
+	cp.Close()
+
+	// unknown uuid
+	other, _ := NewProxyVLESS(proxy.ModeClient, otherUUID, true)
+	if _, err := other.ShadowStreamConn(dial(), m); err != nil {
+		t.Fatal(err)
+	}
+	if r = <-results; r.err != ErrAuthFailed {
+		t.Errorf("unknown user: %v", r.err)
+	}
+}
@@ -0,0 +1,200 @@
+package vmess
+
+import (
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/sha3"
+)
+
+// bodyAEAD gets aead of body by security
+func bodyAEAD(security byte, key []byte) (cipher.AEAD, error) {
+	if security == SecurityChacha20Poly1305 {
+		k := md5.Sum(key)
+		kk := md5.Sum(k[:])
+		return chacha20poly1305.New(append(k[:], kk[:]...))
+	}
+	return newGCM(key), nil
+}
+
+// chunkNonce is count of chunks followed by iv[2:12]
+type chunkNonce struct {
+	nonce []byte
+	count uint16
+}
+
+func newChunkNonce(iv []byte) *chunkNonce {
+	return &chunkNonce{nonce: append([]byte(nil), iv[:12]...)}
+}
+
+func (c *chunkNonce) next() []byte {
+	binary.BigEndian.PutUint16(c.nonce, c.count)
+	c.count++
+	return c.nonce
+}
+
+// sizeMask is shake128 of body iv, masking lengths of chunks and
+// giving lengths of padding
+type sizeMask struct {
+	shake sha3.ShakeHash
+	buf   [2]byte
+}
+
+// newSizeMask gets mask of chunks by options, nil if not masked
+func newSizeMask(opt byte, iv []byte) *sizeMask {
+	if opt&optChunkMasking == 0 {
+		return nil
+	}
+	m := &sizeMask{shake: sha3.NewShake128()}
+	m.shake.Write(iv)
+	return m
+}
+
+func (m *sizeMask) next() uint16 {
+	if m == nil {
+		return 0
+	}
+	m.shake.Read(m.buf[:])
+	return binary.BigEndian.Uint16(m.buf[:])
+}
+
+// padding gets length of padding of next chunk, taken ahead of mask of
+// its length
+func (m *sizeMask) padding(padded bool) int {
+	if !padded {
+		return 0
+	}
+	return int(m.next() % maxPadding)
+}
+
+type chunkWriter struct {
+	io.Writer
+	aead   cipher.AEAD
+	nonce  *chunkNonce
+	mask   *sizeMask
+	padded bool
+	// chunks of concurrent writes and close are not mixed up
+	mu  sync.Mutex
+	buf []byte
+}
+
+// newChunkWriter creates writer of chunks, opt tells masking and padding
+func newChunkWriter(w io.Writer, aead cipher.AEAD, iv []byte, opt byte) *chunkWriter {
+	mask := newSizeMask(opt, iv)
+	return &chunkWriter{
+		Writer: w,
+		aead:   aead,
+		nonce:  newChunkNonce(iv),
+		mask:   mask,
+		padded: mask != nil && opt&optGlobalPadding != 0,
+		buf:    make([]byte, 2+maxChunkSize),
+	}
+}
+
+// WriteChunk writes b as one chunk, b is no longer than maxPayload
+func (w *chunkWriter) WriteChunk(b []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writeChunk(b)
+}
+
+func (w *chunkWriter) writeChunk(b []byte) error {
+	padding := w.mask.padding(w.padded)
+	sealed := w.aead.Seal(w.buf[2:2], w.nonce.next(), b, nil)
+	binary.BigEndian.PutUint16(w.buf, uint16(len(sealed)+padding)^w.mask.next())
+	n := 2 + len(sealed) + padding
+	rand.Read(w.buf[2+len(sealed) : n])
+	_, err := w.Writer.Write(w.buf[:n])
+	return err
+}
+
+func (w *chunkWriter) maxPayload() int {
+	if w.padded {
+		return maxChunkSize - maxPadding - w.aead.Overhead()
+	}
+	return maxChunkSize - w.aead.Overhead()
+}
+
+// Write splits b into chunks
+func (w *chunkWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := 0
+	for n < len(b) {
+		nc := len(b) - n
+		if nc > w.maxPayload() {
+			nc = w.maxPayload()
+		}
+		if err := w.writeChunk(b[n : n+nc]); err != nil {
+			return n, err
+		}
+		n += nc
+	}
+	return n, nil
+}
+
+type chunkReader struct {
+	io.Reader
+	aead   cipher.AEAD
+	nonce  *chunkNonce
+	mask   *sizeMask
+	padded bool
+	buf    []byte
+	// left plaintext in buf[lLeft:rLeft]
+	lLeft int
+	rLeft int
+}
+
+// newChunkReader creates reader of chunks, opt tells masking and padding
+func newChunkReader(r io.Reader, aead cipher.AEAD, iv []byte, opt byte) *chunkReader {
+	mask := newSizeMask(opt, iv)
+	return &chunkReader{
+		Reader: r,
+		aead:   aead,
+		nonce:  newChunkNonce(iv),
+		mask:   mask,
+		padded: mask != nil && opt&optGlobalPadding != 0,
+		buf:    make([]byte, 0xffff),
+	}
+}
+
+// ReadChunk reads a whole chunk, empty chunk is io.EOF
+func (r *chunkReader) ReadChunk() ([]byte, error) {
+	if _, err := io.ReadFull(r.Reader, r.buf[:2]); err != nil {
+		return nil, err
+	}
+	padding := r.mask.padding(r.padded)
+	l := int(binary.BigEndian.Uint16(r.buf) ^ r.mask.next())
+	if l < r.aead.Overhead()+padding {
+		return nil, ErrBadHeader
+	}
+	if _, err := io.ReadFull(r.Reader, r.buf[:l]); err != nil {
+		return nil, err
+	}
+	b, err := r.aead.Open(r.buf[:0], r.nonce.next(), r.buf[:l-padding], nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, io.EOF
+	}
+	return b, nil
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	if r.lLeft == r.rLeft {
+		chunk, err := r.ReadChunk()
+		if err != nil {
+			return 0, err
+		}
+		r.lLeft, r.rLeft = 0, len(chunk)
+	}
+	n := copy(b, r.buf[r.lLeft:r.rLeft])
+	r.lLeft += n
+	return n, nil
+}
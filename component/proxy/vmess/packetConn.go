@@ -0,0 +1,79 @@
+package vmess
+
+import (
+	"net"
+
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+type udpMsg struct {
+	payload  []byte
+	metadata *message.Metadata
+}
+
+func (u *udpMsg) Metadata() *message.Metadata {
+	return u.metadata
+}
+
+func (u *udpMsg) Others() any {
+	return nil
+}
+
+func (u *udpMsg) Payload() []byte {
+	return u.payload
+}
+
+// PacketVMess carries udp packets to remote of request, a chunk each
+type PacketVMess struct {
+	*StreamVMess
+}
+
+// addr gets address of peer, server mode takes tcp address of client
+// as its udp address
+func (p *PacketVMess) addr() net.Addr {
+	if tcp, ok := p.RemoteAddr().(*net.TCPAddr); ok {
+		return &net.UDPAddr{IP: tcp.IP, Port: tcp.Port, Zone: tcp.Zone}
+	}
+	return p.RemoteAddr()
+}
+
+func (p *PacketVMess) ReadMsgFrom() (message.Message, net.Addr, error) {
+	if p.reader == nil {
+		if err := p.readResponseHeader(); err != nil {
+			return nil, p.addr(), err
+		}
+	}
+	chunk, err := p.reader.ReadChunk()
+	if err != nil {
+		return nil, p.addr(), err
+	}
+	m := *p.metadata
+	if p.mode == proxy.ModeClient {
+		m.ClientIP, m.ClientPort = nil, 0
+	}
+	return &udpMsg{payload: append([]byte(nil), chunk...), metadata: &m}, p.addr(), nil
+}
+
+// WriteMsgTo writes packet to remote of request, addr is ignored
+func (p *PacketVMess) WriteMsgTo(msg message.Message, addr net.Addr) error {
+	if len(msg.Payload()) > p.writer.maxPayload() {
+		return proxy.ErrNotSupported
+	}
+	return p.writer.WriteChunk(msg.Payload())
+}
+
+func (p *PacketVMess) ReadFrom(b []byte) (int, net.Addr, error) {
+	msg, _, err := p.ReadMsgFrom()
+	if err != nil {
+		return 0, nil, err
+	}
+	return copy(b, msg.Payload()), &net.UDPAddr{IP: p.metadata.RemoteIP, Port: p.metadata.RemotePort}, nil
+}
+
+func (p *PacketVMess) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if err := p.WriteMsgTo(&udpMsg{payload: b}, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,313 @@
+package vmess
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intxff/rdcross/component/message"
+)
+
+// VMess with AEAD header, requests are
+// +---------+-------------------+-------+-------------------+
+// | auth id | header length     | nonce | header            |
+// +---------+-------------------+-------+-------------------+
+// |   16    | 2 + 16, encrypted |   8   | variable, sealed  |
+// +---------+-------------------+-------+-------------------+
+//
+// header
+// +-----+----+-----+---+-----+-------+-----+-----+------+------+------+---------+------+
+// | Ver | IV | Key | V | Opt | P|Sec | Rsv | Cmd | Port | Atyp | Addr | Padding | FNV  |
+// +-----+----+-----+---+-----+-------+-----+-----+------+------+------+---------+------+
+// |  1  | 16 | 16  | 1 |  1  |   1   |  1  |  1  |  2   |  1   | var  |    P    |  4   |
+// +-----+----+-----+---+-----+-------+-----+-----+------+------+------+---------+------+
+//
+// response header is V, Opt, Cmd and CmdLen, sealed like the request
+// header. Body is chunks of 2 bytes length and sealed data, an empty
+// chunk ends the stream. Udp packets are a chunk each. With chunk
+// masking, length is xored by shake128 of body iv, with global padding
+// also random bytes of length from the same shake follow sealed data.
+
+const (
+	version = byte(0x01)
+	// options
+	optChunkStream   = byte(0x01)
+	optChunkMasking  = byte(0x04)
+	optGlobalPadding = byte(0x08)
+	// length of chunk sealed, not supported
+	optAuthenticatedLength = byte(0x10)
+	// security
+	SecurityAES128GCM        = byte(0x03)
+	SecurityChacha20Poly1305 = byte(0x04)
+	// commands
+	CmdTCP = byte(0x01)
+	CmdUDP = byte(0x02)
+	// address types
+	AtypIPv4   = byte(0x01)
+	AtypDomain = byte(0x02)
+	AtypIPv6   = byte(0x03)
+
+	// auth id older than this is refused
+	maxTimeDiff = 120 * time.Second
+	// size of sealed chunk and its padding
+	maxChunkSize = 8192
+	maxPadding   = 64
+)
+
+var (
+	ErrAuthFailed      = errors.New("auth failed")
+	ErrBadHeader       = errors.New("bad header")
+	ErrReplay          = errors.New("replayed auth id")
+	ErrCmdNotSupported = errors.New("cmd not supported")
+)
+
+// kdf salts
+const (
+	kdfSaltVMessAEADKDF        = "VMess AEAD KDF"
+	kdfSaltAuthIDEncryptionKey = "AES Auth ID Encryption"
+	kdfSaltHeaderLengthKey     = "VMess Header AEAD Key_Length"
+	kdfSaltHeaderLengthIV      = "VMess Header AEAD Nonce_Length"
+	kdfSaltHeaderKey           = "VMess Header AEAD Key"
+	kdfSaltHeaderIV            = "VMess Header AEAD Nonce"
+	kdfSaltRespHeaderLenKey    = "AEAD Resp Header Len Key"
+	kdfSaltRespHeaderLenIV     = "AEAD Resp Header Len IV"
+	kdfSaltRespHeaderKey       = "AEAD Resp Header Key"
+	kdfSaltRespHeaderIV        = "AEAD Resp Header IV"
+)
+
+// ParseUUID parses uuid in the form of 8-4-4-4-12 hex digits
+func ParseUUID(s string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 {
+		return nil, errors.New("invalid uuid " + s)
+	}
+	return b, nil
+}
+
+// cmdKey gets key of user from uuid
+func cmdKey(id []byte) []byte {
+	h := md5.New()
+	h.Write(id)
+	h.Write([]byte("c48619fe-8f02-49e0-b9e9-edf763e17e21"))
+	return h.Sum(nil)
+}
+
+// kdf is nested hmac-sha256, each path is a layer over the last
+func kdf(key []byte, path ...string) []byte {
+	creator := func() hash.Hash {
+		return hmac.New(sha256.New, []byte(kdfSaltVMessAEADKDF))
+	}
+	for _, v := range path {
+		parent, value := creator, []byte(v)
+		creator = func() hash.Hash {
+			return hmac.New(parent, value)
+		}
+	}
+	h := creator()
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+func kdf16(key []byte, path ...string) []byte {
+	return kdf(key, path...)[:16]
+}
+
+func newGCM(key []byte) cipher.AEAD {
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+// authBlock gets cipher of auth id of user
+func authBlock(cmdKey []byte) cipher.Block {
+	block, _ := aes.NewCipher(kdf16(cmdKey, kdfSaltAuthIDEncryptionKey))
+	return block
+}
+
+// newAuthID creates timestamp, random and crc32 encrypted by block
+func newAuthID(block cipher.Block) []byte {
+	id := make([]byte, 16)
+	binary.BigEndian.PutUint64(id, uint64(time.Now().Unix()))
+	rand.Read(id[8:12])
+	binary.BigEndian.PutUint32(id[12:], crc32.ChecksumIEEE(id[:12]))
+	block.Encrypt(id, id)
+	return id
+}
+
+// checkAuthID decrypts auth id by block, true if it is valid
+func checkAuthID(block cipher.Block, id []byte) bool {
+	plain := make([]byte, 16)
+	block.Decrypt(plain, id)
+	if crc32.ChecksumIEEE(plain[:12]) != binary.BigEndian.Uint32(plain[12:]) {
+		return false
+	}
+	diff := time.Since(time.Unix(int64(binary.BigEndian.Uint64(plain)), 0))
+	return diff <= maxTimeDiff && diff >= -maxTimeDiff
+}
+
+// sealHeader seals request header by cmd key of user
+func sealHeader(cmdKey, header []byte) []byte {
+	id := newAuthID(authBlock(cmdKey))
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+
+	out := make([]byte, 0, 16+18+8+len(header)+16)
+	out = append(out, id...)
+	l := binary.BigEndian.AppendUint16(nil, uint16(len(header)))
+	out = newGCM(kdf16(cmdKey, kdfSaltHeaderLengthKey, string(id), string(nonce))).
+		Seal(out, kdf(cmdKey, kdfSaltHeaderLengthIV, string(id), string(nonce))[:12], l, id)
+	out = append(out, nonce...)
+	return newGCM(kdf16(cmdKey, kdfSaltHeaderKey, string(id), string(nonce))).
+		Seal(out, kdf(cmdKey, kdfSaltHeaderIV, string(id), string(nonce))[:12], header, id)
+}
+
+// openHeader reads request header after auth id
+func openHeader(r io.Reader, cmdKey, id []byte) ([]byte, error) {
+	buf := make([]byte, 18+8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	nonce := string(buf[18:])
+	l, err := newGCM(kdf16(cmdKey, kdfSaltHeaderLengthKey, string(id), nonce)).
+		Open(nil, kdf(cmdKey, kdfSaltHeaderLengthIV, string(id), nonce)[:12], buf[:18], id)
+	if err != nil {
+		return nil, ErrBadHeader
+	}
+	header := make([]byte, int(binary.BigEndian.Uint16(l))+16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	header, err = newGCM(kdf16(cmdKey, kdfSaltHeaderKey, string(id), nonce)).
+		Open(header[:0], kdf(cmdKey, kdfSaltHeaderIV, string(id), nonce)[:12], header, id)
+	if err != nil {
+		return nil, ErrBadHeader
+	}
+	return header, nil
+}
+
+// sealResponseHeader seals response header by response key and iv
+func sealResponseHeader(key, iv, header []byte) []byte {
+	l := binary.BigEndian.AppendUint16(nil, uint16(len(header)))
+	out := newGCM(kdf16(key, kdfSaltRespHeaderLenKey)).
+		Seal(nil, kdf(iv, kdfSaltRespHeaderLenIV)[:12], l, nil)
+	return newGCM(kdf16(key, kdfSaltRespHeaderKey)).
+		Seal(out, kdf(iv, kdfSaltRespHeaderIV)[:12], header, nil)
+}
+
+func openResponseHeader(r io.Reader, key, iv []byte) ([]byte, error) {
+	buf := make([]byte, 18)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	l, err := newGCM(kdf16(key, kdfSaltRespHeaderLenKey)).
+		Open(nil, kdf(iv, kdfSaltRespHeaderLenIV)[:12], buf, nil)
+	if err != nil {
+		return nil, ErrBadHeader
+	}
+	header := make([]byte, int(binary.BigEndian.Uint16(l))+16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	header, err = newGCM(kdf16(key, kdfSaltRespHeaderKey)).
+		Open(header[:0], kdf(iv, kdfSaltRespHeaderIV)[:12], header, nil)
+	if err != nil {
+		return nil, ErrBadHeader
+	}
+	return header, nil
+}
+
+// appendAddr appends Port, Atyp and Addr of m
+func appendAddr(b []byte, m *message.Metadata) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(m.RemotePort))
+	switch {
+	case m.Domain != "":
+		b = append(b, AtypDomain, byte(len(m.Domain)))
+		b = append(b, m.Domain...)
+	case m.RemoteIP.To4() != nil:
+		b = append(b, AtypIPv4)
+		b = append(b, m.RemoteIP.To4()...)
+	default:
+		b = append(b, AtypIPv6)
+		b = append(b, m.RemoteIP.To16()...)
+	}
+	return b
+}
+
+// parseAddr parses Port, Atyp and Addr into m, returns bytes taken
+func parseAddr(b []byte, m *message.Metadata) (int, error) {
+	if len(b) < 2+1+1 {
+		return 0, ErrBadHeader
+	}
+	m.WithRemotePort(int(binary.BigEndian.Uint16(b)))
+	n := 3
+	switch b[2] {
+	case AtypIPv4:
+		n += net.IPv4len
+		if len(b) < n {
+			return 0, ErrBadHeader
+		}
+		m.WithRemoteIP(net.IP(append([]byte(nil), b[3:n]...)))
+	case AtypIPv6:
+		n += net.IPv6len
+		if len(b) < n {
+			return 0, ErrBadHeader
+		}
+		m.WithRemoteIP(net.IP(append([]byte(nil), b[3:n]...)))
+	case AtypDomain:
+		n += 1 + int(b[3])
+		if len(b) < n {
+			return 0, ErrBadHeader
+		}
+		m.WithDomain(string(b[4:n]))
+	default:
+		return 0, ErrBadHeader
+	}
+	return n, nil
+}
+
+// authIDPool remembers auth ids as long as they are valid
+type authIDPool struct {
+	mu    sync.Mutex
+	ids   map[[16]byte]time.Time
+	clean time.Time
+}
+
+func newAuthIDPool() *authIDPool {
+	return &authIDPool{ids: make(map[[16]byte]time.Time)}
+}
+
+// check adds id to pool, false if it is seen before
+func (p *authIDPool) check(id []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if now.After(p.clean) {
+		for k, v := range p.ids {
+			if now.After(v) {
+				delete(p.ids, k)
+			}
+		}
+		p.clean = now.Add(maxTimeDiff)
+	}
+	var k [16]byte
+	copy(k[:], id)
+	if expire, exist := p.ids[k]; exist && now.Before(expire) {
+		return false
+	}
+	p.ids[k] = now.Add(2 * maxTimeDiff)
+	return true
+}
@@ -0,0 +1,221 @@
+package vmess
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	mrand "math/rand"
+	"net"
+	"time"
+
+	"github.com/intxff/rdcross/component/conn"
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+// connections failed to authenticate are drained for a random time up
+// to this before closed
+const maxDrainDelay = 10 * time.Second
+
+type StreamVMess struct {
+	VMess
+	net.Conn
+	metadata *message.Metadata
+	cmd      byte
+	opt      byte
+	// body key and iv of request, response ones are hash of them
+	key      []byte
+	iv       []byte
+	respAuth byte
+	reader   *chunkReader
+	writer   *chunkWriter
+}
+
+func newStreamVMess(v VMess, c net.Conn, m *message.Metadata, cmd byte) (*StreamVMess, error) {
+	s := &StreamVMess{
+		VMess:    v,
+		Conn:     c,
+		metadata: m,
+		cmd:      cmd,
+	}
+	var err error
+	if v.mode == proxy.ModeServer {
+		err = s.serverHandShake()
+	} else {
+		err = s.clientHandShake()
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *StreamVMess) responseKey() ([]byte, []byte) {
+	key, iv := sha256.Sum256(s.key), sha256.Sum256(s.iv)
+	return key[:16], iv[:16]
+}
+
+func (s *StreamVMess) clientHandShake() error {
+	buf := make([]byte, 16+16+1)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return err
+	}
+	s.iv, s.key, s.respAuth = buf[:16], buf[16:32], buf[32]
+	padding := mrand.Intn(16)
+	// as v2ray does for aead security
+	s.opt = optChunkStream | optChunkMasking | optGlobalPadding
+
+	header := make([]byte, 0, 1+16+16+5+2+1+1+255+padding+4)
+	header = append(header, version)
+	header = append(header, s.iv...)
+	header = append(header, s.key...)
+	header = append(header, s.respAuth, s.opt, byte(padding<<4)|s.security, 0, s.cmd)
+	header = appendAddr(header, s.metadata)
+	header = append(header, make([]byte, padding)...)
+	rand.Read(header[len(header)-padding:])
+	h := fnv.New32a()
+	h.Write(header)
+	header = h.Sum(header)
+
+	aead, err := bodyAEAD(s.security, s.key)
+	if err != nil {
+		return err
+	}
+	s.writer = newChunkWriter(s.Conn, aead, s.iv, s.opt)
+	_, err = s.Conn.Write(sealHeader(s.cmdKey, header))
+	return err
+}
+
+// readResponseHeader reads response header before the first chunk
+func (s *StreamVMess) readResponseHeader() error {
+	key, iv := s.responseKey()
+	header, err := openResponseHeader(s.Conn, key, iv)
+	if err != nil {
+		return err
+	}
+	if len(header) < 4 || header[0] != s.respAuth {
+		return ErrBadHeader
+	}
+	aead, err := bodyAEAD(s.security, key)
+	if err != nil {
+		return err
+	}
+	s.reader = newChunkReader(s.Conn, aead, iv, s.opt)
+	return nil
+}
+
+func (s *StreamVMess) serverHandShake() error {
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(s.Conn, id); err != nil {
+		return err
+	}
+	u, exist := s.lookup(id)
+	if !exist {
+		s.drain()
+		return ErrAuthFailed
+	}
+	if !s.authIDs.check(id) {
+		s.drain()
+		return ErrReplay
+	}
+	header, err := openHeader(s.Conn, u.cmdKey, id)
+	if err != nil {
+		s.drain()
+		return err
+	}
+	// fixed part, address, fnv
+	if len(header) < 38+4 || header[0] != version {
+		return ErrBadHeader
+	}
+	h := fnv.New32a()
+	h.Write(header[:len(header)-4])
+	if h.Sum32() != binary.BigEndian.Uint32(header[len(header)-4:]) {
+		return ErrBadHeader
+	}
+	s.iv, s.key, s.respAuth = header[1:17], header[17:33], header[33]
+	s.opt, s.security, s.cmd = header[34], header[35]&0x0f, header[37]
+	// padding lengths come from shake of masking
+	if s.opt&optChunkStream == 0 || s.opt&optAuthenticatedLength != 0 ||
+		(s.opt&optGlobalPadding != 0 && s.opt&optChunkMasking == 0) {
+		return ErrBadHeader
+	}
+	if s.security != SecurityAES128GCM && s.security != SecurityChacha20Poly1305 {
+		return ErrBadHeader
+	}
+	if s.cmd != CmdTCP && s.cmd != CmdUDP {
+		return ErrCmdNotSupported
+	}
+
+	cAddr := s.RemoteAddr().(*net.TCPAddr)
+	m := message.NewMetadata().WithClientIP(cAddr.IP).
+		WithClientPort(cAddr.Port).
+		WithIngress(s.metadata.Ingress).
+		WithUser(u.name)
+	if _, err := parseAddr(header[38:len(header)-4], m); err != nil {
+		return err
+	}
+	s.metadata = m
+
+	aead, err := bodyAEAD(s.security, s.key)
+	if err != nil {
+		return err
+	}
+	s.reader = newChunkReader(s.Conn, aead, s.iv, s.opt)
+	key, iv := s.responseKey()
+	if aead, err = bodyAEAD(s.security, key); err != nil {
+		return err
+	}
+	s.writer = newChunkWriter(s.Conn, aead, iv, s.opt)
+	_, err = s.Conn.Write(sealResponseHeader(key, iv, []byte{s.respAuth, 0, 0, 0}))
+	return err
+}
+
+// drain reads connection for a random time, so probes can not tell
+// server by how soon the connection is closed
+func (s *StreamVMess) drain() {
+	s.Conn.SetReadDeadline(time.Now().Add(time.Duration(mrand.Int63n(int64(maxDrainDelay)))))
+	io.Copy(io.Discard, s.Conn)
+}
+
+func (s *StreamVMess) Read(b []byte) (int, error) {
+	if s.reader == nil {
+		if err := s.readResponseHeader(); err != nil {
+			return 0, err
+		}
+	}
+	return s.reader.Read(b)
+}
+
+func (s *StreamVMess) Write(b []byte) (int, error) {
+	return s.writer.Write(b)
+}
+
+// Close ends stream by an empty chunk, which waits for chunks being
+// written
+func (s *StreamVMess) Close() error {
+	s.writer.WriteChunk(nil)
+	return s.Conn.Close()
+}
+
+// PacketConn implements conn.PacketStreamConn
+func (s *StreamVMess) PacketConn() conn.ProxyPacketConn {
+	if s.cmd != CmdUDP {
+		return nil
+	}
+	return &PacketVMess{StreamVMess: s}
+}
+
+func (s *StreamVMess) Metadata() *message.Metadata {
+	return s.metadata
+}
+
+func (s *StreamVMess) ReadMux() (message.Message, error) {
+	return nil, proxy.ErrNotSupported
+}
+
+func (s *StreamVMess) WriteMux(msg message.Message) error {
+	return proxy.ErrNotSupported
+}
@@ -0,0 +1,140 @@
+package vmess
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/intxff/rdcross/component/conn"
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+type VMess struct {
+	mode int
+	// client mode
+	cmdKey   []byte
+	security byte
+	udp      bool
+	// server mode
+	users   *userList
+	authIDs *authIDPool
+}
+
+type vmessUser struct {
+	name   string
+	cmdKey []byte
+	block  cipher.Block
+}
+
+type userList struct {
+	mu    sync.RWMutex
+	users map[string]*vmessUser
+}
+
+// NewProxyVMess creates vmess proxy, security of body is aes-128-gcm
+// or chacha20-poly1305. Uuid of server is taken as an anonymous user.
+func NewProxyVMess(mode int, uuid, security string, udp bool) (*VMess, error) {
+	v := &VMess{
+		mode:    mode,
+		udp:     udp,
+		users:   &userList{users: make(map[string]*vmessUser)},
+		authIDs: newAuthIDPool(),
+	}
+	switch strings.ToLower(security) {
+	case "", "auto", "aes-128-gcm":
+		v.security = SecurityAES128GCM
+	case "chacha20-poly1305":
+		v.security = SecurityChacha20Poly1305
+	default:
+		return nil, fmt.Errorf("vmess security %v not supported", security)
+	}
+	if uuid == "" {
+		return v, nil
+	}
+	if mode == proxy.ModeServer {
+		return v, v.AddUser("", uuid)
+	}
+	id, err := ParseUUID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	v.cmdKey = cmdKey(id)
+	return v, nil
+}
+
+// AddUser adds or replaces user of server
+func (v *VMess) AddUser(name, uuid string) error {
+	id, err := ParseUUID(uuid)
+	if err != nil {
+		return err
+	}
+	key := cmdKey(id)
+	v.users.mu.Lock()
+	defer v.users.mu.Unlock()
+	v.users.users[name] = &vmessUser{name: name, cmdKey: key, block: authBlock(key)}
+	return nil
+}
+
+// RemoveUser drops uuid of name, auth ids derived from it no longer match
+func (v *VMess) RemoveUser(name string) bool {
+	v.users.mu.Lock()
+	defer v.users.mu.Unlock()
+	if _, exist := v.users.users[name]; !exist {
+		return false
+	}
+	delete(v.users.users, name)
+	return true
+}
+
+// lookup finds user the auth id is encrypted for
+func (v *VMess) lookup(id []byte) (*vmessUser, bool) {
+	v.users.mu.RLock()
+	defer v.users.mu.RUnlock()
+	for _, u := range v.users.users {
+		if checkAuthID(u.block, id) {
+			return u, true
+		}
+	}
+	return nil, false
+}
+
+func (v *VMess) Cipher() proxy.Cipher {
+	return nil
+}
+
+func (v *VMess) ShadowStreamConn(c net.Conn, extra ...any) (conn.ProxyStreamConn, error) {
+	if v.mode == proxy.ModeServer {
+		m := message.NewMetadata().WithIngress(extra[0].(string))
+		return newStreamVMess(*v, c, m, 0)
+	}
+	return newStreamVMess(*v, c, extra[0].(*message.Metadata), CmdTCP)
+}
+
+// ShadowPacketConn is not supported, udp of vmess is carried in stream
+func (v *VMess) ShadowPacketConn(c net.PacketConn, extra ...any) (conn.ProxyPacketConn, error) {
+	return nil, proxy.ErrNotSupported
+}
+
+// StreamPacketConn implements proxy.PacketOverStream, packets in c are
+// all sent to remote in metadata
+func (v *VMess) StreamPacketConn(c net.Conn, extra ...any) (conn.ProxyPacketConn, error) {
+	if !v.udp {
+		return nil, proxy.ErrNotSupported
+	}
+	s, err := newStreamVMess(*v, c, extra[0].(*message.Metadata), CmdUDP)
+	if err != nil {
+		return nil, err
+	}
+	return &PacketVMess{StreamVMess: s}, nil
+}
+
+func (v *VMess) Type() proxy.ProxyType {
+	return proxy.TypeVMess
+}
+
+func (v *VMess) TcpMux() bool {
+	return false
+}
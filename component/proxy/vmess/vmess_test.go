@@ -0,0 +1,211 @@
+package vmess
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/intxff/rdcross/component/conn"
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+)
+
+const (
+	testUUID  = "b831381d-6324-4d53-ad4f-8cda48b30811"
+	otherUUID = "27848739-7e62-4138-9fd3-098a63964b6b"
+)
+
+func TestVMess(t *testing.T) {
+	for _, security := range []string{"aes-128-gcm", "chacha20-poly1305"} {
+		server, err := NewProxyVMess(proxy.ModeServer, otherUUID, "", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := server.AddUser("alice", testUUID); err != nil {
+			t.Fatal(err)
+		}
+		client, err := NewProxyVMess(proxy.ModeClient, testUUID, security, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		type result struct {
+			sc  conn.ProxyStreamConn
+			err error
+		}
+		results := make(chan result, 1)
+		go func() {
+			for {
+				c, err := l.Accept()
+				if err != nil {
+					return
+				}
+				sc, err := server.ShadowStreamConn(c, "in")
+				results <- result{sc, err}
+			}
+		}()
+		dial := func() net.Conn {
+			c, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return c
+		}
+
+		// tcp
+		m := message.NewMetadata().WithDomain("example.org").WithRemotePort(443)
+		cc, err := client.ShadowStreamConn(dial(), m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := make([]byte, 3*maxChunkSize)
+		for i := range request {
+			request[i] = byte(i)
+		}
+		go cc.Write(request)
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("%v: %v", security, r.err)
+		}
+		if m := r.sc.Metadata(); m.Domain != "example.org" || m.RemotePort != 443 || m.User != "alice" {
+			t.Errorf("%v: metadata %+v", security, m)
+		}
+		got := make([]byte, len(request))
+		if _, err := io.ReadFull(r.sc, got); err != nil || string(got) != string(request) {
+			t.Fatalf("%v: request %v", security, err)
+		}
+		r.sc.Write([]byte("pong"))
+		r.sc.Close()
+		got = make([]byte, 4)
+		if _, err := io.ReadFull(cc, got); err != nil || string(got) != "pong" {
+			t.Fatalf("%v: response %q %v", security, got, err)
+		}
+		if _, err := cc.Read(got); err != io.EOF {
+			t.Errorf("%v: stream not ended: %v", security, err)
+		}
+		cc.Close()
+
+		// udp over stream
+		m = message.NewMetadata().WithRemoteIP(net.IPv4(192, 0, 2, 1)).WithRemotePort(53)
+		cp, err := client.StreamPacketConn(dial(), m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cp.WriteMsgTo(&udpMsg{payload: []byte("query"), metadata: m}, nil); err != nil {
+			t.Fatal(err)
+		}
+		r = <-results
+		if r.err != nil {
+			t.Fatalf("%v: %v", security, r.err)
+		}
+		sp := r.sc.(conn.PacketStreamConn).PacketConn()
+		if sp == nil {
+			t.Fatalf("%v: no packet conn", security)
+		}
+		msg, _, err := sp.ReadMsgFrom()
+		if err != nil || string(msg.Payload()) != "query" || !msg.Metadata().RemoteIP.Equal(m.RemoteIP) {
+			t.Fatalf("%v: query %v", security, err)
+		}
+		if err := sp.WriteMsgTo(&udpMsg{payload: []byte("answer")}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if msg, _, err = cp.ReadMsgFrom(); err != nil || string(msg.Payload()) != "answer" {
+			t.Errorf("%v: answer %v", security, err)
+		}
+		r.sc.Close()
+		l.Close()
+	}
+}
+
+func TestAuthID(t *testing.T) {
+	id, _ := ParseUUID(testUUID)
+	block := authBlock(cmdKey(id))
+	authID := newAuthID(block)
+	if !checkAuthID(block, authID) {
+		t.Fatal("auth id not accepted")
+	}
+	other, _ := ParseUUID(otherUUID)
+	if checkAuthID(authBlock(cmdKey(other)), authID) {
+		t.Error("auth id accepted by other user")
+	}
+	pool := newAuthIDPool()
+	if !pool.check(authID) || pool.check(authID) {
+		t.Error("replayed auth id accepted")
+	}
+}
+
+// TestV2RayChunk reads chunks framed as v2ray does with chunk masking
+// and global padding, masks are shake128 of iv from another library
+func TestV2RayChunk(t *testing.T) {
+	key, iv := make([]byte, 16), make([]byte, 16)
+	for i := range key {
+		key[i], iv[i] = byte(i), byte(16+i)
+	}
+	opt := optChunkStream | optChunkMasking | optGlobalPadding
+	mask := newSizeMask(opt, iv)
+	for _, want := range []uint16{64657, 36419, 60612, 46766} {
+		if got := mask.next(); got != want {
+			t.Fatalf("mask %v, want %v", got, want)
+		}
+	}
+
+	// padding length is taken ahead of mask of length
+	aead, _ := bodyAEAD(SecurityAES128GCM, key)
+	nonce := newChunkNonce(iv)
+	sealed := aead.Seal(nil, nonce.next(), []byte("hello"), nil)
+	frame := binary.BigEndian.AppendUint16(nil, uint16(len(sealed)+64657%64)^36419)
+	frame = append(frame, sealed...)
+	frame = append(frame, make([]byte, 64657%64)...)
+	end := aead.Seal(nil, nonce.next(), nil, nil)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(end)+60612%64)^46766)
+	frame = append(frame, end...)
+	frame = append(frame, make([]byte, 60612%64)...)
+
+	r := newChunkReader(bytes.NewReader(frame), aead, iv, opt)
+	if b, err := r.ReadChunk(); err != nil || string(b) != "hello" {
+		t.Fatalf("chunk %q %v", b, err)
+	}
+	if _, err := r.ReadChunk(); err != io.EOF {
+		t.Errorf("end chunk %v", err)
+	}
+
+	var out bytes.Buffer
+	w := newChunkWriter(&out, aead, iv, opt)
+	w.Write([]byte("hello"))
+	if n := 2 + len(sealed); out.Len() != n+64657%64 || !bytes.Equal(out.Bytes()[:n], frame[:n]) {
+		t.Errorf("chunk written %x", out.Bytes())
+	}
+}
+
+func TestCloseWhileWriting(t *testing.T) {
+	key, iv := make([]byte, 16), make([]byte, 16)
+	aead, _ := bodyAEAD(SecurityChacha20Poly1305, key)
+	opt := optChunkStream | optChunkMasking | optGlobalPadding
+	c, s := net.Pipe()
+	stream := &StreamVMess{Conn: c, writer: newChunkWriter(c, aead, iv, opt)}
+	go func() {
+		for {
+			if _, err := stream.Write(make([]byte, 3*maxChunkSize)); err != nil {
+				return
+			}
+		}
+	}()
+	go stream.Close()
+
+	// every chunk opens with nonce in order
+	r := newChunkReader(s, aead, iv, opt)
+	for {
+		if _, err := r.ReadChunk(); err != nil {
+			if err != io.EOF && err != io.ErrClosedPipe {
+				t.Error(err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Multiplex streams over one connection, frames are compatible with
+// github.com/xtaci/smux of version 1 and 2
+package smux
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// frame
+// +-----+-----+--------+-----------+----------+
+// | VER | CMD | LENGTH | STREAM ID | Payload  |
+// +-----+-----+--------+-----------+----------+
+// |  1  |  1  |   2    |     4     | Variable |
+// +-----+-----+--------+-----------+----------+
+//
+// LENGTH and STREAM ID are little endian. Payload of UPD, version 2
+// only, is bytes consumed and window of receiver, both uint32.
+
+const (
+	cmdSYN = byte(iota) // open stream
+	cmdFIN              // close stream
+	cmdPSH              // data
+	cmdNOP              // keepalive
+	cmdUPD              // window update
+)
+
+const (
+	headerSize = 8
+	updSize    = 8
+)
+
+var (
+	ErrInvalidVersion  = errors.New("invalid smux version")
+	ErrInvalidCmd      = errors.New("invalid smux cmd")
+	ErrSessionClosed   = errors.New("smux session closed")
+	ErrStreamClosed    = errors.New("smux stream closed")
+	ErrKeepAlive       = errors.New("smux keepalive timeout")
+	ErrTooManyStreams  = errors.New("smux stream id exhausted")
+	ErrWindowOverflow  = errors.New("smux peer exceeds stream window")
+	errInvalidProtocol = errors.New("invalid smux frame")
+)
+
+type frame struct {
+	ver     byte
+	cmd     byte
+	sid     uint32
+	payload []byte
+}
+
+func (f frame) marshal() []byte {
+	b := make([]byte, headerSize, headerSize+len(f.payload))
+	b[0], b[1] = f.ver, f.cmd
+	binary.LittleEndian.PutUint16(b[2:], uint16(len(f.payload)))
+	binary.LittleEndian.PutUint32(b[4:], f.sid)
+	return append(b, f.payload...)
+}
+
+// readHeader reads header of frame, payload is left in r
+func readHeader(r io.Reader, b []byte) (frame, int, error) {
+	if _, err := io.ReadFull(r, b[:headerSize]); err != nil {
+		return frame{}, 0, err
+	}
+	f := frame{ver: b[0], cmd: b[1], sid: binary.LittleEndian.Uint32(b[4:])}
+	return f, int(binary.LittleEndian.Uint16(b[2:])), nil
+}
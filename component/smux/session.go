@@ -0,0 +1,315 @@
+package smux
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	// 1 or 2, streams of version 2 are flow controlled by window
+	Version           int
+	KeepAliveInterval time.Duration
+	// session is closed if nothing is received for so long
+	KeepAliveTimeout time.Duration
+	MaxFrameSize     int
+	// receive window of every stream
+	MaxStreamBuffer int
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Version:           2,
+		KeepAliveInterval: 10 * time.Second,
+		KeepAliveTimeout:  30 * time.Second,
+		MaxFrameSize:      32768,
+		MaxStreamBuffer:   65536,
+	}
+}
+
+// window of peer before its first update
+const initialPeerWindow = 262144
+
+// streams opened by peer and not yet accepted, more are refused
+const acceptBacklog = 1024
+
+// Session holds streams over one connection
+type Session struct {
+	conn   net.Conn
+	config *Config
+
+	idMu   sync.Mutex
+	nextID uint32
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+
+	accepts chan *Stream
+	wMu     sync.Mutex
+	// set once a frame is received, cleared by keepalive
+	alive atomic.Bool
+
+	die     chan struct{}
+	dieOnce sync.Once
+	err     atomic.Value
+}
+
+// Client creates session of client side, stream ids are odd
+func Client(c net.Conn, config *Config) *Session {
+	return newSession(c, config, 1)
+}
+
+// Server creates session of server side, stream ids are even
+func Server(c net.Conn, config *Config) *Session {
+	return newSession(c, config, 0)
+}
+
+func newSession(c net.Conn, config *Config, id uint32) *Session {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	s := &Session{
+		conn:    c,
+		config:  config,
+		nextID:  id,
+		streams: make(map[uint32]*Stream),
+		accepts: make(chan *Stream, acceptBacklog),
+		die:     make(chan struct{}),
+	}
+	go s.recvLoop()
+	go s.keepalive()
+	return s
+}
+
+// OpenStream opens a new stream to peer
+func (s *Session) OpenStream() (*Stream, error) {
+	if s.IsClosed() {
+		return nil, ErrSessionClosed
+	}
+	s.idMu.Lock()
+	s.nextID += 2
+	id := s.nextID
+	s.idMu.Unlock()
+	if id < 2 {
+		// wrapped around
+		return nil, ErrTooManyStreams
+	}
+
+	// registered first, peer may answer before syn is written
+	st := newStream(id, s)
+	s.mu.Lock()
+	select {
+	case <-s.die:
+		s.mu.Unlock()
+		return nil, ErrSessionClosed
+	default:
+	}
+	s.streams[id] = st
+	s.mu.Unlock()
+	if err := s.writeFrame(frame{ver: byte(s.config.Version), cmd: cmdSYN, sid: id}); err != nil {
+		s.streamClosed(id)
+		return nil, err
+	}
+	return st, nil
+}
+
+// AcceptStream waits for stream opened by peer
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case st := <-s.accepts:
+		return st, nil
+	case <-s.die:
+		return nil, s.closeErr()
+	}
+}
+
+// NumStreams gets number of streams alive
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+func (s *Session) IsClosed() bool {
+	select {
+	case <-s.die:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes session with all its streams
+func (s *Session) Close() error {
+	return s.closeWith(ErrSessionClosed)
+}
+
+func (s *Session) closeWith(err error) error {
+	closed := false
+	s.dieOnce.Do(func() {
+		s.err.Store(err)
+		close(s.die)
+		closed = true
+	})
+	if !closed {
+		return ErrSessionClosed
+	}
+	s.mu.Lock()
+	for _, st := range s.streams {
+		st.sessionClosed()
+	}
+	s.mu.Unlock()
+	return s.conn.Close()
+}
+
+func (s *Session) closeErr() error {
+	if err, ok := s.err.Load().(error); ok {
+		return err
+	}
+	return ErrSessionClosed
+}
+
+func (s *Session) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+func (s *Session) writeFrame(f frame) error {
+	b := f.marshal()
+	s.wMu.Lock()
+	defer s.wMu.Unlock()
+	select {
+	case <-s.die:
+		return s.closeErr()
+	default:
+	}
+	if _, err := s.conn.Write(b); err != nil {
+		s.closeWith(err)
+		return err
+	}
+	return nil
+}
+
+// streamClosed removes stream closed locally, frames of it are dropped
+func (s *Session) streamClosed(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, id)
+}
+
+func (s *Session) stream(id uint32) *Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[id]
+}
+
+func (s *Session) recvLoop() {
+	header := make([]byte, headerSize)
+	for {
+		f, l, err := readHeader(s.conn, header)
+		if err != nil {
+			s.closeWith(err)
+			return
+		}
+		s.alive.Store(true)
+		if int(f.ver) != s.config.Version {
+			s.closeWith(ErrInvalidVersion)
+			return
+		}
+
+		switch f.cmd {
+		case cmdNOP:
+		case cmdSYN:
+			s.mu.Lock()
+			_, exist := s.streams[f.sid]
+			st := newStream(f.sid, s)
+			if !exist {
+				s.streams[f.sid] = st
+			}
+			s.mu.Unlock()
+			if exist {
+				break
+			}
+			// never wait for accept, other streams would stall
+			select {
+			case s.accepts <- st:
+			default:
+				s.streamClosed(f.sid)
+				go s.writeFrame(frame{ver: byte(s.config.Version), cmd: cmdFIN, sid: f.sid})
+			}
+		case cmdFIN:
+			if st := s.stream(f.sid); st != nil {
+				st.fin()
+			}
+		case cmdPSH:
+			if l == 0 {
+				continue
+			}
+			payload := make([]byte, l)
+			if _, err := io.ReadFull(s.conn, payload); err != nil {
+				s.closeWith(err)
+				return
+			}
+			if st := s.stream(f.sid); st != nil {
+				if err := st.push(payload); err != nil {
+					s.closeWith(err)
+					return
+				}
+			}
+			// length is already read
+			continue
+		case cmdUPD:
+			if s.config.Version < 2 || l != updSize {
+				s.closeWith(errInvalidProtocol)
+				return
+			}
+			payload := header[:updSize]
+			if _, err := io.ReadFull(s.conn, payload); err != nil {
+				s.closeWith(err)
+				return
+			}
+			if st := s.stream(f.sid); st != nil {
+				st.update(binary.LittleEndian.Uint32(payload), binary.LittleEndian.Uint32(payload[4:]))
+			}
+			continue
+		default:
+			s.closeWith(ErrInvalidCmd)
+			return
+		}
+		// payload of other cmds is not expected
+		if l > 0 {
+			if _, err := io.CopyN(io.Discard, s.conn, int64(l)); err != nil {
+				s.closeWith(err)
+				return
+			}
+		}
+	}
+}
+
+// keepalive sends nop periodically and closes session if peer is silent
+// for too long
+func (s *Session) keepalive() {
+	ping := time.NewTicker(s.config.KeepAliveInterval)
+	timeout := time.NewTicker(s.config.KeepAliveTimeout)
+	defer ping.Stop()
+	defer timeout.Stop()
+	for {
+		select {
+		case <-ping.C:
+			s.writeFrame(frame{ver: byte(s.config.Version), cmd: cmdNOP})
+		case <-timeout.C:
+			if !s.alive.Swap(false) {
+				s.closeWith(ErrKeepAlive)
+				return
+			}
+		case <-s.die:
+			return
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package smux
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestPair(t *testing.T, config *Config) (client, server *Session) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ch := make(chan net.Conn, 1)
+	go func() {
+		c, _ := l.Accept()
+		ch <- c
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Client(c, config), Server(<-ch, config)
+}
+
+func TestStreams(t *testing.T) {
+	for _, version := range []int{1, 2} {
+		config := DefaultConfig()
+		config.Version = version
+		client, server := newTestPair(t, config)
+		go func() {
+			for {
+				st, err := server.AcceptStream()
+				if err != nil {
+					return
+				}
+				go func() {
+					io.Copy(st, st)
+					st.Close()
+				}()
+			}
+		}()
+
+		// more than window of peer, writer has to wait for updates
+		data := make([]byte, 4*initialPeerWindow)
+		rand.Read(data)
+		done := make(chan error, 4)
+		for i := 0; i < cap(done); i++ {
+			go func() {
+				st, err := client.OpenStream()
+				if err != nil {
+					done <- err
+					return
+				}
+				defer st.Close()
+				go st.Write(data)
+				got := make([]byte, len(data))
+				if _, err := io.ReadFull(st, got); err != nil {
+					done <- err
+					return
+				}
+				if !bytes.Equal(got, data) {
+					done <- io.ErrUnexpectedEOF
+					return
+				}
+				done <- nil
+			}()
+		}
+		for i := 0; i < cap(done); i++ {
+			if err := <-done; err != nil {
+				t.Fatalf("version %v: %v", version, err)
+			}
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestHalfClose(t *testing.T) {
+	client, server := newTestPair(t, nil)
+	defer client.Close()
+	defer server.Close()
+
+	st, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Write([]byte("hello"))
+	st.Close()
+	ss, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(ss)
+	if err != nil || string(got) != "hello" {
+		t.Errorf("got %q %v", got, err)
+	}
+
+	ss.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := ss.Read(got); err != io.EOF {
+		t.Errorf("read after fin: %v", err)
+	}
+	st, _ = client.OpenStream()
+	ss, _ = server.AcceptStream()
+	ss.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := ss.Read(got); !isTimeout(err) {
+		t.Errorf("read deadline: %v", err)
+	}
+}
+
+func isTimeout(err error) bool {
+	e, ok := err.(net.Error)
+	return ok && e.Timeout()
+}
+
+func TestKeepAlive(t *testing.T) {
+	config := DefaultConfig()
+	config.KeepAliveInterval = 10 * time.Millisecond
+	config.KeepAliveTimeout = 50 * time.Millisecond
+	client, server := newTestPair(t, config)
+	time.Sleep(200 * time.Millisecond)
+	if client.IsClosed() || server.IsClosed() {
+		t.Fatal("session closed with keepalive")
+	}
+
+	// peer stops sending anything
+	silent := *config
+	silent.KeepAliveInterval = time.Hour
+	client, server = newTestPair(t, &silent)
+	defer client.Close()
+	time.Sleep(200 * time.Millisecond)
+	if !server.IsClosed() {
+		t.Error("session alive without keepalive")
+	}
+}
+
+func TestWindowOverflow(t *testing.T) {
+	c, peer := net.Pipe()
+	server := Server(c, nil)
+	defer server.Close()
+	go io.Copy(io.Discard, peer)
+
+	// peer ignores window and never gets update as nothing is read
+	write := func(f frame) error {
+		_, err := peer.Write(f.marshal())
+		return err
+	}
+	write(frame{ver: 2, cmd: cmdSYN, sid: 1})
+	payload := make([]byte, DefaultConfig().MaxFrameSize)
+	for sent := 0; sent <= initialPeerWindow; sent += len(payload) {
+		if err := write(frame{ver: 2, cmd: cmdPSH, sid: 1, payload: payload}); err != nil {
+			break
+		}
+	}
+	select {
+	case <-server.die:
+	case <-time.After(time.Second):
+		t.Fatal("session alive with window exceeded")
+	}
+	if err := server.closeErr(); err != ErrWindowOverflow {
+		t.Errorf("closed with %v", err)
+	}
+}
+
+func TestAcceptBacklog(t *testing.T) {
+	client, server := newTestPair(t, nil)
+	defer client.Close()
+	defer server.Close()
+
+	// nothing is accepted till backlog is full
+	streams := make([]*Stream, acceptBacklog+1)
+	for i := range streams {
+		st, err := client.OpenStream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		streams[i] = st
+	}
+	last := streams[acceptBacklog]
+	last.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := last.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("stream beyond backlog: %v", err)
+	}
+
+	// streams in backlog still work
+	streams[0].Write([]byte("hello"))
+	ss, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	ss.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(ss, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("got %q %v", buf, err)
+	}
+}
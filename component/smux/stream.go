@@ -0,0 +1,279 @@
+package smux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stream is a net.Conn over session
+type Stream struct {
+	id   uint32
+	sess *Session
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+	// flow control of version 2, bytes read and not yet notified
+	numRead uint32
+	incr    uint32
+	// bytes received, read and read when window is last told to peer
+	recv     uint64
+	read     uint64
+	reported uint64
+	// bytes written and consumed by peer, window of peer
+	numWritten   atomic.Uint32
+	peerConsumed atomic.Uint32
+	peerWindow   atomic.Uint32
+
+	readEvent   chan struct{}
+	updateEvent chan struct{}
+	drainEvent  chan struct{}
+	finEvent    chan struct{}
+	finOnce     sync.Once
+	die         chan struct{}
+	dieOnce     sync.Once
+
+	readDeadline  atomic.Value
+	writeDeadline atomic.Value
+}
+
+func newStream(id uint32, sess *Session) *Stream {
+	s := &Stream{
+		id:          id,
+		sess:        sess,
+		readEvent:   make(chan struct{}, 1),
+		updateEvent: make(chan struct{}, 1),
+		drainEvent:  make(chan struct{}, 1),
+		finEvent:    make(chan struct{}),
+		die:         make(chan struct{}),
+	}
+	s.peerWindow.Store(initialPeerWindow)
+	return s
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// deadline gets channel fired at deadline in v, nil if not set
+func deadline(v *atomic.Value) (<-chan time.Time, func()) {
+	d, ok := v.Load().(time.Time)
+	if !ok || d.IsZero() {
+		return nil, func() {}
+	}
+	timer := time.NewTimer(time.Until(d))
+	return timer.C, func() { timer.Stop() }
+}
+
+func (s *Stream) ID() uint32 {
+	return s.id
+}
+
+func (s *Stream) Read(b []byte) (int, error) {
+	for {
+		s.mu.Lock()
+		if s.buf.Len() > 0 {
+			n, _ := s.buf.Read(b)
+			var consumed uint32
+			s.numRead += uint32(n)
+			s.incr += uint32(n)
+			s.read += uint64(n)
+			// the first read tells peer the window
+			if s.incr >= uint32(s.sess.config.MaxStreamBuffer/2) || s.numRead == uint32(n) {
+				consumed, s.incr = s.numRead, 0
+				s.reported = s.read
+			}
+			s.mu.Unlock()
+			notify(s.drainEvent)
+			if consumed > 0 && s.sess.config.Version >= 2 {
+				s.sendUpdate(consumed)
+			}
+			return n, nil
+		}
+		s.mu.Unlock()
+
+		timeout, stop := deadline(&s.readDeadline)
+		select {
+		case <-s.readEvent:
+		case <-s.finEvent:
+			// data may arrive with fin
+			s.mu.Lock()
+			empty := s.buf.Len() == 0
+			s.mu.Unlock()
+			if empty {
+				stop()
+				return 0, io.EOF
+			}
+		case <-s.die:
+			stop()
+			return 0, ErrStreamClosed
+		case <-timeout:
+			return 0, os.ErrDeadlineExceeded
+		}
+		stop()
+	}
+}
+
+func (s *Stream) sendUpdate(consumed uint32) error {
+	payload := make([]byte, updSize)
+	binary.LittleEndian.PutUint32(payload, consumed)
+	binary.LittleEndian.PutUint32(payload[4:], uint32(s.sess.config.MaxStreamBuffer))
+	return s.sess.writeFrame(frame{ver: byte(s.sess.config.Version), cmd: cmdUPD, sid: s.id, payload: payload})
+}
+
+// window gets bytes allowed to send before peer consumes more
+func (s *Stream) window() int {
+	if s.sess.config.Version < 2 {
+		return s.sess.config.MaxFrameSize
+	}
+	inflight := int32(s.numWritten.Load() - s.peerConsumed.Load())
+	return int(int32(s.peerWindow.Load()) - inflight)
+}
+
+func (s *Stream) Write(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		win := s.window()
+		if win <= 0 {
+			timeout, stop := deadline(&s.writeDeadline)
+			select {
+			case <-s.updateEvent:
+			case <-s.finEvent:
+				stop()
+				return n, io.EOF
+			case <-s.die:
+				stop()
+				return n, ErrStreamClosed
+			case <-timeout:
+				return n, os.ErrDeadlineExceeded
+			}
+			stop()
+			continue
+		}
+		select {
+		case <-s.die:
+			return n, ErrStreamClosed
+		case <-s.finEvent:
+			return n, io.EOF
+		default:
+		}
+
+		size := len(b) - n
+		if size > win {
+			size = win
+		}
+		if size > s.sess.config.MaxFrameSize {
+			size = s.sess.config.MaxFrameSize
+		}
+		f := frame{ver: byte(s.sess.config.Version), cmd: cmdPSH, sid: s.id, payload: b[n : n+size]}
+		if err := s.sess.writeFrame(f); err != nil {
+			return n, err
+		}
+		s.numWritten.Add(uint32(size))
+		n += size
+	}
+	return n, nil
+}
+
+// Close sends fin to peer, stream can not be used any more
+func (s *Stream) Close() error {
+	closed := false
+	s.dieOnce.Do(func() {
+		close(s.die)
+		closed = true
+	})
+	if !closed {
+		return ErrStreamClosed
+	}
+	s.sess.streamClosed(s.id)
+	return s.sess.writeFrame(frame{ver: byte(s.sess.config.Version), cmd: cmdFIN, sid: s.id})
+}
+
+// sessionClosed closes stream without fin
+func (s *Stream) sessionClosed() {
+	s.dieOnce.Do(func() {
+		close(s.die)
+	})
+}
+
+// push buffers data of peer. Peer of version 2 sending beyond the
+// window it is told is an error, while version 1 has no window so
+// receiving waits till the buffer is drained.
+func (s *Stream) push(b []byte) error {
+	config := s.sess.config
+	s.mu.Lock()
+	if config.Version >= 2 {
+		// bytes sent before peer gets the first update are in the
+		// initial window
+		limit := s.reported + uint64(config.MaxStreamBuffer)
+		if limit < initialPeerWindow {
+			limit = initialPeerWindow
+		}
+		if s.recv+uint64(len(b)) > limit {
+			s.mu.Unlock()
+			return ErrWindowOverflow
+		}
+	}
+	for config.Version < 2 && s.buf.Len() >= config.MaxStreamBuffer {
+		s.mu.Unlock()
+		select {
+		case <-s.drainEvent:
+		case <-s.die:
+			return nil
+		case <-s.sess.die:
+			return nil
+		}
+		s.mu.Lock()
+	}
+	s.recv += uint64(len(b))
+	s.buf.Write(b)
+	s.mu.Unlock()
+	notify(s.readEvent)
+	return nil
+}
+
+func (s *Stream) fin() {
+	s.finOnce.Do(func() {
+		close(s.finEvent)
+	})
+}
+
+func (s *Stream) update(consumed, window uint32) {
+	s.peerConsumed.Store(consumed)
+	s.peerWindow.Store(window)
+	notify(s.updateEvent)
+}
+
+func (s *Stream) LocalAddr() net.Addr {
+	return s.sess.LocalAddr()
+}
+
+func (s *Stream) RemoteAddr() net.Addr {
+	return s.sess.RemoteAddr()
+}
+
+func (s *Stream) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	s.SetWriteDeadline(t)
+	return nil
+}
+
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.readDeadline.Store(t)
+	notify(s.readEvent)
+	return nil
+}
+
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.writeDeadline.Store(t)
+	notify(s.updateEvent)
+	return nil
+}
@@ -0,0 +1,137 @@
+package transport
+
+import (
+	"net"
+	"sync"
+
+	"github.com/intxff/rdcross/component/smux"
+)
+
+// streams opened in a session before another session is dialed
+const maxStreamsPerSession = 8
+
+// smuxPool keeps sessions to remote of a transport
+type smuxPool struct {
+	mu       sync.Mutex
+	sessions []*smux.Session
+	dial     func() (net.Conn, error)
+}
+
+// open opens stream in a session not full, or in a new one dialed out
+// of lock, so a slow remote does not hold streams of live sessions
+func (p *smuxPool) open() (net.Conn, error) {
+	if st := p.openPooled(); st != nil {
+		return st, nil
+	}
+
+	c, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	s := smux.Client(c, smux.DefaultConfig())
+	st, err := s.OpenStream()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	p.mu.Lock()
+	p.sessions = append(p.sessions, s)
+	p.mu.Unlock()
+	return st, nil
+}
+
+// openPooled opens stream in a pooled session, nil if all are full
+func (p *smuxPool) openPooled() net.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// idle sessions other than the first one are closed
+	alive := p.sessions[:0]
+	for _, s := range p.sessions {
+		switch {
+		case s.IsClosed():
+		case len(alive) > 0 && s.NumStreams() == 0:
+			s.Close()
+		default:
+			alive = append(alive, s)
+		}
+	}
+	for i := len(alive); i < len(p.sessions); i++ {
+		p.sessions[i] = nil
+	}
+	p.sessions = alive
+
+	for _, s := range p.sessions {
+		if s.NumStreams() >= maxStreamsPerSession {
+			continue
+		}
+		if st, err := s.OpenStream(); err == nil {
+			return st
+		}
+	}
+	return nil
+}
+
+// smuxListener accepts streams of every session from clients
+type smuxListener struct {
+	net.Listener
+	streams  chan net.Conn
+	sessions sync.Map
+	die      chan struct{}
+	err      error
+}
+
+func newSmuxListener(l net.Listener) *smuxListener {
+	sl := &smuxListener{
+		Listener: l,
+		streams:  make(chan net.Conn),
+		die:      make(chan struct{}),
+	}
+	go sl.acceptLoop()
+	return sl
+}
+
+func (l *smuxListener) acceptLoop() {
+	defer func() {
+		l.sessions.Range(func(key, _ any) bool {
+			key.(*smux.Session).Close()
+			return true
+		})
+	}()
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			l.err = err
+			close(l.die)
+			return
+		}
+		go l.serve(smux.Server(c, smux.DefaultConfig()))
+	}
+}
+
+func (l *smuxListener) serve(s *smux.Session) {
+	l.sessions.Store(s, struct{}{})
+	defer l.sessions.Delete(s)
+	for {
+		st, err := s.AcceptStream()
+		if err != nil {
+			return
+		}
+		select {
+		case l.streams <- st:
+		case <-l.die:
+			st.Close()
+			s.Close()
+			return
+		}
+	}
+}
+
+func (l *smuxListener) Accept() (net.Conn, error) {
+	select {
+	case st := <-l.streams:
+		return st, nil
+	case <-l.die:
+		return nil, l.err
+	}
+}
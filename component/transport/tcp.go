@@ -9,7 +9,12 @@ import (
 type TransTCP struct {
 	net.TCPAddr
 	ipType uint8
-	Smux   bool
+	// streams share connections to remote if set
+	Smux bool
+	pool *smuxPool
+	// dials connections to remote, which tls, websocket and sessions of
+	// smux are over
+	dial func() (net.Conn, error)
 }
 
 type tcpOptionFunc func(t *TransTCP)
@@ -34,6 +39,8 @@ func NewTransTCP(network, addr string, opts ...tcpOptionFunc) (*TransTCP, error)
 	for _, opt := range opts {
 		opt(t)
 	}
+	t.dial = t.dialTCP
+	t.pool = &smuxPool{dial: func() (net.Conn, error) { return t.dial() }}
 
 	return t, nil
 }
@@ -53,7 +60,22 @@ func (t *TransTCP) Address() net.Addr {
 	return &t.TCPAddr
 }
 
+// DialStream dials remote, or opens a stream to remote in a pooled
+// session if smux is set
 func (t *TransTCP) DialStream() (net.Conn, error) {
+	if t.Smux {
+		return t.pool.open()
+	}
+	return t.dial()
+}
+
+// SetDial replaces dialing remote from local interface by d, e.g. to
+// go through another egress
+func (t *TransTCP) SetDial(d func() (net.Conn, error)) {
+	t.dial = d
+}
+
+func (t *TransTCP) dialTCP() (net.Conn, error) {
 	lAddr := &net.TCPAddr{Port: 0}
 	if t.ipType == ipv4 {
 		lIP, err := iface.GetIPv4()
@@ -80,10 +102,15 @@ func (t *TransTCP) DialPacket() (net.PacketConn, error) {
 	return nil, errNotSupported
 }
 
+// ListenStream listens on address, streams of every client session are
+// accepted if smux is set
 func (t *TransTCP) ListenStream() (net.Listener, error) {
 	l, err := net.ListenTCP("tcp", &t.TCPAddr)
 	if err != nil {
-		return l, err
+		return nil, err
+	}
+	if t.Smux {
+		return newSmuxListener(l), nil
 	}
 	return l, nil
 }
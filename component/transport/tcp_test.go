@@ -0,0 +1,119 @@
+package transport
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSetDialSmux(t *testing.T) {
+	server, err := NewTransTCP("tcp", "127.0.0.1:0", WithSmux(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := server.ListenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+
+	client, err := NewTransTCP("tcp", l.Addr().String(), WithSmux(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dials := 0
+	client.SetDial(func() (net.Conn, error) {
+		dials++
+		return net.Dial("tcp", l.Addr().String())
+	})
+	for i := 0; i < 3; i++ {
+		c, err := client.DialStream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		c.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("echo %q %v", buf, err)
+		}
+	}
+	// streams share the session dialed by d
+	if dials != 1 {
+		t.Errorf("dialed %v times", dials)
+	}
+}
+
+func TestSmuxPoolSlowDial(t *testing.T) {
+	server, _ := NewTransTCP("tcp", "127.0.0.1:0", WithSmux(true))
+	l, err := server.ListenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+
+	// the first dial hangs till released
+	dialing, release := make(chan struct{}), make(chan struct{})
+	dials := 0
+	p := &smuxPool{dial: func() (net.Conn, error) {
+		if dials++; dials == 1 {
+			close(dialing)
+			<-release
+		}
+		return net.Dial("tcp", l.Addr().String())
+	}}
+	slow := make(chan error, 1)
+	go func() {
+		st, err := p.open()
+		if err == nil {
+			st.Close()
+		}
+		slow <- err
+	}()
+	<-dialing
+
+	opened := make(chan error, 1)
+	go func() {
+		st, err := p.open()
+		if err == nil {
+			st.Close()
+		}
+		opened <- err
+	}()
+	select {
+	case err := <-opened:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stream blocked by slow dial")
+	}
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	if len(p.sessions) != 2 {
+		t.Errorf("%v sessions", len(p.sessions))
+	}
+	p.mu.Unlock()
+}
@@ -0,0 +1,175 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+var errPinMismatch = errors.New("certificate not pinned")
+
+// time allowed for handshakes of tls and websocket with remote
+var handshakeTimeout = 10 * time.Second
+
+// TLSOptions configures tls of transport
+type TLSOptions struct {
+	// client mode, name to verify certificate of server and sent in SNI
+	ServerName string
+	ALPN       []string
+	// 1.0, 1.1, 1.2 or 1.3
+	MinVersion string
+	// certificates to verify peer, client certificates are required in
+	// server mode if it is set
+	CA string
+	// certificate of server, or of client for mutual tls
+	Cert string
+	Key  string
+	// client mode, chain of server certificate is not verified
+	SkipCertVerify bool
+	// base64 of SHA256 of SubjectPublicKeyInfo, leaf certificate of peer
+	// has to match one of them
+	Pins []string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewTLSConfig builds tls config of client or server from o
+func NewTLSConfig(o TLSOptions, server bool) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         o.ServerName,
+		NextProtos:         o.ALPN,
+		InsecureSkipVerify: o.SkipCertVerify,
+	}
+	if o.MinVersion != "" {
+		v, ok := tlsVersions[o.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid tls version %v", o.MinVersion)
+		}
+		config.MinVersion = v
+	}
+	if o.Cert != "" || o.Key != "" {
+		pair, err := tls.LoadX509KeyPair(o.Cert, o.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	if server && len(config.Certificates) == 0 {
+		return nil, errors.New("tls server needs cert and key")
+	}
+	if o.CA != "" {
+		pem, err := os.ReadFile(o.CA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %v", o.CA)
+		}
+		if server {
+			config.ClientCAs = pool
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			config.RootCAs = pool
+		}
+	}
+	if len(o.Pins) > 0 {
+		pins := make([][]byte, 0, len(o.Pins))
+		for _, v := range o.Pins {
+			pin, err := base64.StdEncoding.DecodeString(v)
+			if err != nil || len(pin) != sha256.Size {
+				return nil, fmt.Errorf("invalid pin %v", v)
+			}
+			pins = append(pins, pin)
+		}
+		if server && config.ClientAuth == tls.NoClientCert {
+			config.ClientAuth = tls.RequireAnyClientCert
+		}
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPin(cs, pins)
+		}
+	}
+	return config, nil
+}
+
+// verifyPin checks SPKI hash of peer leaf certificate
+func verifyPin(cs tls.ConnectionState, pins [][]byte) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errPinMismatch
+	}
+	hash := sha256.Sum256(cs.PeerCertificates[0].RawSubjectPublicKeyInfo)
+	for _, pin := range pins {
+		if bytes.Equal(pin, hash[:]) {
+			return nil
+		}
+	}
+	return errPinMismatch
+}
+
+// TransTLS is tls over TransTCP, sessions of smux are over tls
+type TransTLS struct {
+	*TransTCP
+	config *tls.Config
+}
+
+func NewTransTLS(network, addr string, config *tls.Config, opts ...tcpOptionFunc) (*TransTLS, error) {
+	tcp, err := NewTransTCP(network, addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	t := &TransTLS{TransTCP: tcp, config: config}
+	t.pool.dial = t.dialTLS
+	return t, nil
+}
+
+func (t *TransTLS) DialStream() (net.Conn, error) {
+	if t.Smux {
+		return t.pool.open()
+	}
+	return t.dialTLS()
+}
+
+func (t *TransTLS) dialTLS() (net.Conn, error) {
+	c, err := t.dial()
+	if err != nil {
+		return nil, err
+	}
+	return t.ClientConn(c)
+}
+
+// ClientConn wraps c dialed elsewhere by tls and handshakes, c is closed
+// if it fails or takes longer than handshakeTimeout
+func (t *TransTLS) ClientConn(c net.Conn) (net.Conn, error) {
+	c.SetDeadline(time.Now().Add(handshakeTimeout))
+	tc := tls.Client(c, t.config)
+	if err := tc.Handshake(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.SetDeadline(time.Time{})
+	return tc, nil
+}
+
+func (t *TransTLS) ListenStream() (net.Listener, error) {
+	l, err := net.ListenTCP("tcp", &t.TCPAddr)
+	if err != nil {
+		return nil, err
+	}
+	tl := tls.NewListener(l, t.config)
+	if t.Smux {
+		return newSmuxListener(tl), nil
+	}
+	return tl, nil
+}
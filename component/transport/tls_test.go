@@ -0,0 +1,148 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCert writes self-signed certificate and key to dir
+func newTestCert(t *testing.T, dir, name string) (cert, key string, pin string) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		DNSNames:              []string{"example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, _ := x509.MarshalECPrivateKey(k)
+	cert, key = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key")
+	os.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600)
+	c, _ := x509.ParseCertificate(der)
+	hash := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+	return cert, key, base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	sCert, sKey, sPin := newTestCert(t, dir, "server")
+	cCert, cKey, _ := newTestCert(t, dir, "client")
+	config, err := NewTLSConfig(TLSOptions{Cert: sCert, Key: sKey, CA: cCert, MinVersion: "1.3"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, _ := NewTransTLS("tcp", "127.0.0.1:0", config)
+	l, err := server.ListenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+
+	cases := []struct {
+		name string
+		o    TLSOptions
+		ok   bool
+	}{
+		{"mutual", TLSOptions{ServerName: "example.com", CA: sCert, Cert: cCert, Key: cKey}, true},
+		{"pinned", TLSOptions{SkipCertVerify: true, Cert: cCert, Key: cKey, Pins: []string{sPin}}, true},
+		{"unknown ca", TLSOptions{ServerName: "example.com", Cert: cCert, Key: cKey}, false},
+		{"pin mismatch", TLSOptions{SkipCertVerify: true, Cert: cCert, Key: cKey,
+			Pins: []string{base64.StdEncoding.EncodeToString(make([]byte, 32))}}, false},
+		{"no client cert", TLSOptions{ServerName: "example.com", CA: sCert}, false},
+	}
+	for _, c := range cases {
+		config, err := NewTLSConfig(c.o, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, _ := NewTransTLS("tcp", l.Addr().String(), config)
+		tcp, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// certificate of client is refused after handshake in tls 1.3
+		conn, err := client.ClientConn(tcp)
+		if err == nil {
+			conn.Write([]byte("hi"))
+			_, err = io.ReadFull(conn, make([]byte, 2))
+			conn.Close()
+		}
+		if (err == nil) != c.ok {
+			t.Errorf("%v: %v", c.name, err)
+		}
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	saved := handshakeTimeout
+	handshakeTimeout = 100 * time.Millisecond
+	defer func() { handshakeTimeout = saved }()
+
+	// remote accepts but never answers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	client, _ := NewTransTLS("tcp", l.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	tcp, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.ClientConn(tcp)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("handshake with silent remote succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handshake with silent remote not timed out")
+	}
+}
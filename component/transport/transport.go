@@ -20,6 +20,11 @@ type TransType string
 const (
     TypeStream TransType = "TCP"
     TypePacket TransType = "UDP"
+    // tls over tcp, Type of it is TypeStream
+    TypeTLS TransType = "TLS"
+    // websocket over tcp or tls, Type of them is TypeStream
+    TypeWS  TransType = "WS"
+    TypeWSS TransType = "WSS"
 )
 
 const (
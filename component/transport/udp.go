@@ -2,11 +2,16 @@ package transport
 
 import (
 	"net"
+
+	"github.com/intxff/rdcross/component/faketcp"
+	"github.com/intxff/rdcross/component/iface"
 )
 
 type TransUDP struct {
 	net.UDPAddr
 	fakeTCP bool
+	// fakes connections to remote if fakeTCP is set
+	dialer *faketcp.Dialer
 }
 
 type udpOptionFunc func(u *TransUDP)
@@ -24,6 +29,9 @@ func NewTransUDP(network, addr string, opts ...udpOptionFunc) (*TransUDP, error)
 	for _, opt := range opts {
 		opt(u)
 	}
+	if u.fakeTCP {
+		u.dialer = faketcp.NewDialer(u.tcpAddr())
+	}
 
 	return u, nil
 }
@@ -49,7 +57,40 @@ func (t *TransUDP) Type() TransType {
 func (t *TransUDP) DialStream() (net.Conn, error) {
 	return nil, errNotSupported
 }
+
+// FakeTCP tells whether datagrams are carried in faked tcp segments
+func (t *TransUDP) FakeTCP() bool {
+	return t.fakeTCP
+}
+
+func (t *TransUDP) tcpAddr() *net.TCPAddr {
+	return &net.TCPAddr{IP: t.IP, Port: t.Port, Zone: t.Zone}
+}
+
+// DialPacket dials remote, datagrams are sent to remote regardless of
+// address if fakeTCP is set
 func (t *TransUDP) DialPacket() (net.PacketConn, error) {
+	if t.fakeTCP {
+		lAddr := &net.TCPAddr{}
+		if t.IP.To4() != nil {
+			lIP, err := iface.GetIPv4()
+			if err != nil {
+				return nil, err
+			}
+			lAddr.IP = lIP
+		} else {
+			lIP, err := iface.GetIPv6()
+			if err != nil {
+				return nil, err
+			}
+			lAddr.IP = lIP
+		}
+		conn, err := t.dialer.Dial(lAddr)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
 	conn, err := net.DialUDP(t.Network(), nil, &t.UDPAddr)
 	if err != nil {
 		return nil, err
@@ -60,6 +101,13 @@ func (t *TransUDP) ListenStream() (net.Listener, error) {
 	return nil, errNotSupported
 }
 func (t *TransUDP) ListenPacket() (net.PacketConn, error) {
+	if t.fakeTCP {
+		l, err := faketcp.Listen(t.tcpAddr())
+		if err != nil {
+			return nil, err
+		}
+		return l, nil
+	}
 	conn, err := net.ListenUDP(t.Network(), &t.UDPAddr)
 	if err != nil {
 		return nil, err
@@ -70,3 +118,11 @@ func (t *TransUDP) ListenPacket() (net.PacketConn, error) {
 func (t *TransUDP) ListenAddr() net.Addr {
 	return &t.UDPAddr
 }
+
+// Close removes what faked connections to remote have set up
+func (t *TransUDP) Close() error {
+	if t.dialer != nil {
+		return t.dialer.Close()
+	}
+	return nil
+}
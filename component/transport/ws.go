@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/intxff/rdcross/component/websocket"
+)
+
+// TransWS is websocket over TransTCP, or over tls if config of tls is
+// set, sessions of smux are over websocket
+type TransWS struct {
+	*TransTCP
+	tls *tls.Config
+	ws  *websocket.Config
+}
+
+func NewTransWS(network, addr string, tlsConfig *tls.Config, wsConfig *websocket.Config, opts ...tcpOptionFunc) (*TransWS, error) {
+	tcp, err := NewTransTCP(network, addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	t := &TransWS{TransTCP: tcp, tls: tlsConfig, ws: wsConfig}
+	t.pool.dial = t.dialWS
+	return t, nil
+}
+
+func (t *TransWS) DialStream() (net.Conn, error) {
+	if t.Smux {
+		return t.pool.open()
+	}
+	return t.dialWS()
+}
+
+func (t *TransWS) dialWS() (net.Conn, error) {
+	c, err := t.dial()
+	if err != nil {
+		return nil, err
+	}
+	return t.ClientConn(c)
+}
+
+// ClientConn wraps c dialed elsewhere by tls if set and websocket, c is
+// closed if handshakes fail or take longer than handshakeTimeout
+func (t *TransWS) ClientConn(c net.Conn) (net.Conn, error) {
+	raw := c
+	raw.SetDeadline(time.Now().Add(handshakeTimeout))
+	if t.tls != nil {
+		tc := tls.Client(c, t.tls)
+		if err := tc.Handshake(); err != nil {
+			c.Close()
+			return nil, err
+		}
+		c = tc
+	}
+	wc, err := websocket.Client(c, t.ws)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	raw.SetDeadline(time.Time{})
+	return wc, nil
+}
+
+func (t *TransWS) ListenStream() (net.Listener, error) {
+	l, err := net.ListenTCP("tcp", &t.TCPAddr)
+	if err != nil {
+		return nil, err
+	}
+	var inner net.Listener = l
+	if t.tls != nil {
+		inner = tls.NewListener(l, t.tls)
+	}
+	wl := &wsListener{Listener: inner, config: t.ws}
+	if t.Smux {
+		return newSmuxListener(wl), nil
+	}
+	return wl, nil
+}
+
+// wsListener accepts websocket connections, handshake of them is done
+// on the first Read or Write so slow clients do not block Accept
+type wsListener struct {
+	net.Listener
+	config *websocket.Config
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return websocket.Server(c, l.config), nil
+}
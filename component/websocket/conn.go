@@ -0,0 +1,208 @@
+package websocket
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Read waits so long for early data to be written before handshake
+// is done without it
+const earlyDataWait = 200 * time.Millisecond
+
+// Conn is stream connection over websocket, every Write is a binary
+// frame
+type Conn struct {
+	net.Conn
+	config *Config
+	client bool
+	br     *bufio.Reader
+
+	// handshake is done by the first Read or Write
+	hsMu   sync.Mutex
+	hsDone bool
+	hsErr  error
+	ready  chan struct{}
+
+	// reading frame, remaining bytes of its payload
+	rMu     sync.Mutex
+	current header
+	pos     int64
+	pending []byte
+
+	wMu       sync.Mutex
+	closeOnce sync.Once
+}
+
+// Client creates client of websocket on c, handshake is delayed to the
+// first Write for early data if it is enabled
+func Client(c net.Conn, config *Config) (*Conn, error) {
+	ws := newConn(c, config, true)
+	if config.MaxEarlyData > 0 {
+		return ws, nil
+	}
+	if _, err := ws.handshake(nil); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// Server creates server of websocket on c, handshake is done by the
+// first Read or Write
+func Server(c net.Conn, config *Config) *Conn {
+	return newConn(c, config, false)
+}
+
+func newConn(c net.Conn, config *Config, client bool) *Conn {
+	return &Conn{
+		Conn:   c,
+		config: config,
+		client: client,
+		br:     bufio.NewReader(c),
+		ready:  make(chan struct{}),
+	}
+}
+
+// handshake returns bytes of early sent in handshake, it is done once
+func (c *Conn) handshake(early []byte) (int, error) {
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	if c.hsDone {
+		return 0, c.hsErr
+	}
+	c.hsDone = true
+	defer close(c.ready)
+
+	if !c.client {
+		c.pending, c.hsErr = serverHandshake(c.Conn, c.br, c.config)
+		return 0, c.hsErr
+	}
+	if len(early) > c.config.MaxEarlyData {
+		early = early[:c.config.MaxEarlyData]
+	}
+	if c.hsErr = clientHandshake(c.Conn, c.br, c.config, early); c.hsErr != nil {
+		return 0, c.hsErr
+	}
+	return len(early), nil
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	select {
+	case <-c.ready:
+	default:
+		if c.client {
+			// early data may come soon
+			timer := time.NewTimer(earlyDataWait)
+			select {
+			case <-c.ready:
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+		if _, err := c.handshake(nil); err != nil {
+			return 0, err
+		}
+	}
+	if c.hsErr != nil {
+		return 0, c.hsErr
+	}
+
+	c.rMu.Lock()
+	defer c.rMu.Unlock()
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	for c.pos == c.current.length {
+		h, err := readHeader(c.br)
+		if err != nil {
+			return 0, err
+		}
+		switch h.opcode {
+		case opContinuation, opText, opBinary:
+			c.current, c.pos = h, 0
+			continue
+		}
+		payload := make([]byte, h.length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return 0, err
+		}
+		if h.masked {
+			maskBytes(h.key, 0, payload)
+		}
+		switch h.opcode {
+		case opClose:
+			c.writeClose()
+			return 0, io.EOF
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return 0, err
+			}
+		case opPong:
+		default:
+			return 0, ErrBadFrame
+		}
+	}
+
+	if rest := c.current.length - c.pos; int64(len(b)) > rest {
+		b = b[:rest]
+	}
+	n, err := c.br.Read(b)
+	if c.current.masked {
+		maskBytes(c.current.key, c.pos, b[:n])
+	}
+	c.pos += int64(n)
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	n := 0
+	select {
+	case <-c.ready:
+		if c.hsErr != nil {
+			return 0, c.hsErr
+		}
+	default:
+		var err error
+		if n, err = c.handshake(b); err != nil {
+			return 0, err
+		}
+	}
+	if n == len(b) {
+		return n, nil
+	}
+	if err := c.writeFrame(opBinary, b[n:]); err != nil {
+		return n, err
+	}
+	return len(b), nil
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	frame := appendFrame(make([]byte, 0, 14+len(payload)), opcode, payload, c.client)
+	c.wMu.Lock()
+	defer c.wMu.Unlock()
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// writeClose sends close frame once
+func (c *Conn) writeClose() {
+	c.closeOnce.Do(func() {
+		c.writeFrame(opClose, []byte{closeNormal >> 8, closeNormal & 0xff})
+	})
+}
+
+// Close sends close frame if handshake is done, then closes connection
+func (c *Conn) Close() error {
+	select {
+	case <-c.ready:
+		if c.hsErr == nil {
+			c.writeClose()
+		}
+	default:
+	}
+	return c.Conn.Close()
+}
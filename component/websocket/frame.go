@@ -0,0 +1,126 @@
+// Carry stream connections in binary frames of websocket (RFC 6455),
+// so they pass through http reverse proxies and CDNs
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// frame
+// +-+-+-+-+-------+-+-------------+-------------------------------+
+// |F|R|R|R| opcode|M| Payload len |    Extended payload length    |
+// |I|S|S|S|  (4)  |A|     (7)     |             (16/64)           |
+// |N|V|V|V|       |S|             |                               |
+// | |1|2|3|       |K|             |                               |
+// +-+-+-+-+-------+-+-------------+-------------------------------+
+// |                 Masking-key, if MASK set to 1                 |
+// +---------------------------------------------------------------+
+// |                         Payload Data                          |
+// +---------------------------------------------------------------+
+
+const (
+	opContinuation = byte(0x0)
+	opText         = byte(0x1)
+	opBinary       = byte(0x2)
+	opClose        = byte(0x8)
+	opPing         = byte(0x9)
+	opPong         = byte(0xa)
+
+	finBit  = byte(0x80)
+	maskBit = byte(0x80)
+
+	// payload of control frames
+	maxControlSize = 125
+	closeNormal    = 1000
+)
+
+var (
+	ErrBadHandshake = errors.New("websocket handshake failed")
+	ErrBadFrame     = errors.New("bad websocket frame")
+)
+
+type header struct {
+	fin    bool
+	opcode byte
+	length int64
+	masked bool
+	key    [4]byte
+}
+
+func readHeader(r io.Reader) (header, error) {
+	var (
+		h   header
+		buf [8]byte
+	)
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return h, err
+	}
+	h.fin, h.opcode = buf[0]&finBit != 0, buf[0]&0x0f
+	h.masked = buf[1]&maskBit != 0
+	switch l := buf[1] &^ maskBit; l {
+	case 126:
+		if _, err := io.ReadFull(r, buf[:2]); err != nil {
+			return h, err
+		}
+		h.length = int64(binary.BigEndian.Uint16(buf[:2]))
+	case 127:
+		if _, err := io.ReadFull(r, buf[:8]); err != nil {
+			return h, err
+		}
+		h.length = int64(binary.BigEndian.Uint64(buf[:8]))
+		if h.length < 0 {
+			return h, ErrBadFrame
+		}
+	default:
+		h.length = int64(l)
+	}
+	if h.opcode >= opClose && (h.length > maxControlSize || !h.fin) {
+		return h, ErrBadFrame
+	}
+	if h.masked {
+		if _, err := io.ReadFull(r, h.key[:]); err != nil {
+			return h, err
+		}
+	}
+	return h, nil
+}
+
+// appendFrame appends a whole frame, payload is masked by random key if
+// mask is set
+func appendFrame(b []byte, opcode byte, payload []byte, mask bool) []byte {
+	b = append(b, finBit|opcode)
+	var m byte
+	if mask {
+		m = maskBit
+	}
+	switch l := len(payload); {
+	case l < 126:
+		b = append(b, m|byte(l))
+	case l <= 0xffff:
+		b = append(b, m|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(l))
+	default:
+		b = append(b, m|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(l))
+	}
+	if !mask {
+		return append(b, payload...)
+	}
+	var key [4]byte
+	rand.Read(key[:])
+	b = append(b, key[:]...)
+	start := len(b)
+	b = append(b, payload...)
+	maskBytes(key, 0, b[start:])
+	return b
+}
+
+// maskBytes masks b in place, pos is offset of b in payload
+func maskBytes(key [4]byte, pos int64, b []byte) {
+	for i := range b {
+		b[i] ^= key[(pos+int64(i))%4]
+	}
+}
@@ -0,0 +1,138 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// default header carrying early data, browsers can set it too
+const defaultEarlyDataHeader = "Sec-WebSocket-Protocol"
+
+type Config struct {
+	Path string
+	// Host header, address of remote if empty
+	Host   string
+	Header http.Header
+	// client mode, the first bytes written up to it are sent in header
+	// of handshake, which saves a round trip
+	MaxEarlyData    int
+	EarlyDataHeader string
+}
+
+func (c *Config) earlyDataHeader() string {
+	if c.EarlyDataHeader == "" {
+		return defaultEarlyDataHeader
+	}
+	return c.EarlyDataHeader
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// clientHandshake sends upgrade request with early data and reads the
+// response
+func clientHandshake(c net.Conn, br *bufio.Reader, config *Config, early []byte) error {
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	path := config.Path
+	if path == "" {
+		path = "/"
+	}
+	host := config.Host
+	if host == "" {
+		host = c.RemoteAddr().String()
+	}
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: path},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       host,
+	}
+	if u, err := url.ParseRequestURI(path); err == nil {
+		req.URL = u
+	}
+	for k, v := range config.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(early) > 0 {
+		req.Header.Set(config.earlyDataHeader(), base64.RawURLEncoding.EncodeToString(early))
+	}
+	if err := req.Write(c); err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return fmt.Errorf("%w: %v", ErrBadHandshake, resp.Status)
+	}
+	return nil
+}
+
+// serverHandshake reads upgrade request and answers it, returns early
+// data in request
+func serverHandshake(c net.Conn, br *bufio.Reader, config *Config) ([]byte, error) {
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, err
+	}
+	reject := func(status int) ([]byte, error) {
+		fmt.Fprintf(c, "HTTP/1.1 %03d %s\r\nConnection: close\r\n\r\n", status, http.StatusText(status))
+		return nil, ErrBadHandshake
+	}
+	if config.Path != "" && req.URL.Path != config.Path {
+		return reject(http.StatusNotFound)
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != http.MethodGet || key == "" ||
+		!strings.EqualFold(req.Header.Get("Upgrade"), "websocket") ||
+		req.Header.Get("Sec-WebSocket-Version") != "13" {
+		return reject(http.StatusBadRequest)
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n"
+	var early []byte
+	name := config.earlyDataHeader()
+	if v := req.Header.Get(name); v != "" {
+		if early, err = base64.RawURLEncoding.DecodeString(v); err == nil &&
+			http.CanonicalHeaderKey(name) == defaultEarlyDataHeader {
+			// clients expect the protocol they ask for
+			resp += defaultEarlyDataHeader + ": " + v + "\r\n"
+		} else if err != nil {
+			// a real header of the name, not early data
+			early = nil
+		}
+	}
+	if _, err := c.Write([]byte(resp + "\r\n")); err != nil {
+		return nil, err
+	}
+	return early, nil
+}
@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestWebSocket(t *testing.T) {
+	for _, early := range []int{0, 16, 4096} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := make([]byte, 100000)
+		rand.Read(request)
+
+		done := make(chan error, 1)
+		go func() {
+			c, err := l.Accept()
+			if err != nil {
+				done <- err
+				return
+			}
+			sc := Server(c, &Config{Path: "/ws"})
+			defer sc.Close()
+			got := make([]byte, len(request))
+			if _, err := io.ReadFull(sc, got); err != nil {
+				done <- err
+				return
+			}
+			if !bytes.Equal(got, request) {
+				t.Errorf("early %v: request mismatch", early)
+			}
+			_, err = sc.Write([]byte("response"))
+			done <- err
+		}()
+
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := &Config{
+			Path:         "/ws?ed=2048",
+			Host:         "example.com",
+			Header:       http.Header{"User-Agent": {"test"}},
+			MaxEarlyData: early,
+		}
+		cc, err := Client(c, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cc.Write(request); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len("response"))
+		if _, err := io.ReadFull(cc, got); err != nil || string(got) != "response" {
+			t.Errorf("early %v: response %q %v", early, got, err)
+		}
+		if err := <-done; err != nil {
+			t.Errorf("early %v: %v", early, err)
+		}
+		// close frame from server ends stream
+		if _, err := cc.Read(got); err != io.EOF {
+			t.Errorf("early %v: read after close: %v", early, err)
+		}
+		cc.Close()
+		l.Close()
+	}
+}
+
+func TestBadPath(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		sc := Server(c, &Config{Path: "/ws"})
+		sc.Read(make([]byte, 1))
+		sc.Close()
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Client(c, &Config{Path: "/other"}); err == nil {
+		t.Error("handshake on wrong path succeeded")
+	}
+	c.Close()
+}
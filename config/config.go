@@ -8,6 +8,7 @@ import (
 
 	"github.com/intxff/rdcross/egress"
 	"github.com/intxff/rdcross/egress/direct"
+	eg "github.com/intxff/rdcross/egress/general"
 	"github.com/intxff/rdcross/egress/reject"
 	"github.com/intxff/rdcross/ingress"
 	"github.com/intxff/rdcross/ingress/tun"
@@ -67,7 +68,12 @@ func (c *RdConfig) ParseIngress() (map[string]ingress.Ingress, error) {
 	ingresses := make(map[string]ingress.Ingress)
 	for i := 0; i < len(c.Ingress); i++ {
 		if c.Ingress[i].Type() == ingress.TypeTun {
-			c.Ingress[i].(*tun.Tun).WithDNS(c.DNS.Listen)
+			t := c.Ingress[i].(*tun.Tun)
+			t.WithDNS(c.DNS.Listen)
+			// ipv6 fake ips are only routed by tun with ipv6 range
+			if c.DNS.FakeIP.Enable && c.DNS.FakeIP.Cidr6 != "" && !t.IPv6() {
+				return nil, fmt.Errorf("tun %v needs cidr6 for ipv6 fake ip", t.Name())
+			}
 		}
 		name := c.Ingress[i].Name()
 		if _, exist := ingresses[name]; exist {
@@ -107,6 +113,13 @@ func (c *RdConfig) ParseEgress() (map[string]egress.Egress, error) {
 	reject := reject.NewReject()
 	egresses[direct.Name()] = direct
 	egresses[reject.Name()] = reject
+	for _, e := range c.Egress {
+		if g, ok := e.(*eg.General); ok {
+			if err := g.BindDialer(egresses); err != nil {
+				return nil, err
+			}
+		}
+	}
 	return egresses, nil
 }
 
@@ -161,8 +174,8 @@ func (c *RdConfig) ParseRouter(t *trie.Trie, e map[string]egress.Egress) router.
 
 	// check prior,if not set, set to default sort
 	if len(r.Prior) == 0 {
-		log.Info("PRIOR not set, use default: ROUTE PRGPATH PRGNAME DOMAIN GEOIP")
-		r.Prior = append(r.Prior, "ROUTE", "PRGPATH", "PRGNAME", "DOMAIN", "GEOIP")
+		log.Info("PRIOR not set, use default: ROUTE USER PRGPATH PRGNAME DOMAIN GEOIP")
+		r.Prior = append(r.Prior, "ROUTE", "USER", "PRGPATH", "PRGNAME", "DOMAIN", "GEOIP")
 	}
 
 	return r
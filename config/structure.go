@@ -25,6 +25,7 @@ type RdConfig struct {
 	Rule         []string     `yaml:"rule"`
 	DNS          dns.DNS      `yaml:"dns"`
 	Log          log.Log      `yaml:"log"`
+	API          string       `yaml:"api"`
 	Path         string
 	Dir          string
 }
@@ -0,0 +1,273 @@
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/intxff/rdcross/log"
+	"github.com/intxff/rdcross/util"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	BlockNXDomain = "nxdomain"
+	BlockZero     = "zero"
+	BlockRefused  = "refused"
+)
+
+type Block struct {
+	// nxdomain, zero or refused
+	Response string      `yaml:"response"`
+	Lists    []BlockList `yaml:"lists"`
+	// never blocked, exact domain or wildcard like +.example.com
+	Allow []string `yaml:"allow"`
+}
+
+// BlockList is a file in hosts format, AdGuard/ABP domain syntax
+// like ||example.com^ or one domain per line
+type BlockList struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+}
+
+// domainSet matches exact domains and domains with their subdomains
+type domainSet struct {
+	exact  map[string]struct{}
+	suffix map[string]struct{}
+}
+
+func newDomainSet() *domainSet {
+	return &domainSet{
+		exact:  make(map[string]struct{}),
+		suffix: make(map[string]struct{}),
+	}
+}
+
+// add takes exact domain or +.domain for domain and subdomains
+func (s *domainSet) add(pattern string) {
+	pattern = normalize(pattern)
+	if strings.HasPrefix(pattern, "+.") {
+		s.suffix[pattern[2:]] = struct{}{}
+		return
+	}
+	s.exact[pattern] = struct{}{}
+}
+
+func (s *domainSet) match(domain string) bool {
+	if _, exist := s.exact[domain]; exist {
+		return true
+	}
+	for {
+		if _, exist := s.suffix[domain]; exist {
+			return true
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			return false
+		}
+		domain = domain[i+1:]
+	}
+}
+
+func (s *domainSet) len() int {
+	return len(s.exact) + len(s.suffix)
+}
+
+type blockList struct {
+	name    string
+	domains *domainSet
+	hits    atomic.Uint64
+}
+
+type blocker struct {
+	response string
+	lists    []*blockList
+	allow    *domainSet
+}
+
+func newBlocker(b *Block) (*blocker, error) {
+	t := &blocker{
+		response: strings.ToLower(b.Response),
+		allow:    newDomainSet(),
+	}
+	switch t.response {
+	case "":
+		t.response = BlockNXDomain
+	case BlockNXDomain, BlockZero, BlockRefused:
+	default:
+		return nil, fmt.Errorf("invalid block response %v", b.Response)
+	}
+	for _, v := range b.Allow {
+		t.allow.add(v)
+	}
+	for _, v := range b.Lists {
+		l, err := loadBlockList(v, t.allow)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("[DNS] block list loaded",
+			zap.String("name", l.name),
+			zap.Int("domains", l.domains.len()))
+		t.lists = append(t.lists, l)
+	}
+	return t, nil
+}
+
+// loadBlockList parses list file, exceptions like @@||example.com^
+// are put into allow
+func loadBlockList(b BlockList, allow *domainSet) (*blockList, error) {
+	path, err := util.GetAbsPath(b.Path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l := &blockList{name: b.Name, domains: newDomainSet()}
+	if l.name == "" {
+		l.name = b.Path
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '!' || line[0] == '#' || line[0] == '[' {
+			continue
+		}
+		set := l.domains
+		if strings.HasPrefix(line, "@@") {
+			set, line = allow, line[2:]
+		}
+		if pattern, ok := parseBlockRule(line); ok {
+			set.add(pattern)
+		}
+	}
+	return l, scanner.Err()
+}
+
+// parseBlockRule turns a rule into pattern of domainSet. Rules with
+// modifiers, paths or wildcards are not for dns and skipped.
+func parseBlockRule(line string) (string, bool) {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	// ||example.com^ blocks domain and subdomains
+	if strings.HasPrefix(line, "||") {
+		line = strings.TrimSuffix(line[2:], "^")
+		if !validDomain(line) {
+			return "", false
+		}
+		return "+." + line, true
+	}
+	fields := strings.Fields(line)
+	switch {
+	// plain domain
+	case len(fields) == 1:
+		if !validDomain(fields[0]) {
+			return "", false
+		}
+		return fields[0], true
+	// hosts format, only the first domain of line is taken
+	case len(fields) > 1 && net.ParseIP(fields[0]) != nil:
+		if !validDomain(fields[1]) {
+			return "", false
+		}
+		switch fields[1] {
+		case "localhost", "localhost.localdomain", "local", "broadcasthost":
+			return "", false
+		}
+		return fields[1], true
+	}
+	return "", false
+}
+
+func validDomain(s string) bool {
+	if s == "" || strings.ContainsAny(s, "/*$|^:@ ") {
+		return false
+	}
+	_, ok := dns.IsDomainName(s)
+	return ok
+}
+
+// match returns the list blocking domain
+func (b *blocker) match(domain string) (*blockList, bool) {
+	domain = normalize(domain)
+	if b.allow.match(domain) {
+		return nil, false
+	}
+	for _, l := range b.lists {
+		if l.domains.match(domain) {
+			return l, true
+		}
+	}
+	return nil, false
+}
+
+// Stats gets blocked queries of every list
+func (b *blocker) Stats() map[string]uint64 {
+	out := make(map[string]uint64, len(b.lists))
+	for _, l := range b.lists {
+		out[l.name] = l.hits.Load()
+	}
+	return out
+}
+
+var block *blocker
+
+// BlockStats gets blocked queries of every block list
+func BlockStats() map[string]uint64 {
+	if block == nil {
+		return nil
+	}
+	return block.Stats()
+}
+
+// blockDNS answers blocked domains, others are passed to next
+type blockDNS struct {
+	*blocker
+	next dns.Handler
+}
+
+func newBlockDNS(b *blocker, next dns.Handler) *blockDNS {
+	block = b
+	return &blockDNS{blocker: b, next: next}
+}
+
+func (h *blockDNS) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	q := r.Question[0]
+	l, blocked := h.match(q.Name)
+	if !blocked {
+		h.next.ServeDNS(w, r)
+		return
+	}
+	l.hits.Add(1)
+	annotate(w, func(e *QueryEntry) { e.Blocked = l.name })
+	log.Debug("[DNS] blocked",
+		zap.String("domain", q.Name),
+		zap.String("list", l.name))
+
+	m := new(dns.Msg)
+	switch h.response {
+	case BlockRefused:
+		m.SetRcode(r, dns.RcodeRefused)
+	case BlockZero:
+		m.SetReply(r)
+		hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: hostsTTL}
+		switch q.Qtype {
+		case dns.TypeA:
+			m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: net.IPv4zero.To4()})
+		case dns.TypeAAAA:
+			m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr, AAAA: net.IPv6zero})
+		}
+	default:
+		m.SetRcode(r, dns.RcodeNameError)
+	}
+	w.WriteMsg(m)
+}
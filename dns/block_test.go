@@ -0,0 +1,52 @@
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlockList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.txt")
+	content := `! adguard comment
+# hosts comment
+||ads.example.com^
+||tracker.net^$third-party
+@@||good.ads.example.com^
+0.0.0.0 exact.example.org
+127.0.0.1 localhost
+plain.example.io
+||allowed.example.org^
+example.com/banner.png
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := newBlocker(&Block{
+		Lists: []BlockList{{Name: "test", Path: path}},
+		Allow: []string{"+.a.allowed.example.org"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		"ads.example.com.":       true,
+		"x.ads.example.com.":     true,
+		"good.ads.example.com.":  false,
+		"tracker.net.":           false,
+		"exact.example.org.":     true,
+		"sub.exact.example.org.": false,
+		"plain.example.io.":      true,
+		"localhost.":             false,
+		"example.com.":           false,
+		"a.allowed.example.org.": false,
+		"ADS.Example.COM.":       true,
+		"notads.example.com.":    false,
+	}
+	for domain, want := range cases {
+		if _, got := b.match(domain); got != want {
+			t.Errorf("match(%v) = %v, want %v", domain, got, want)
+		}
+	}
+}
@@ -3,14 +3,25 @@ package dns
 import "github.com/miekg/dns"
 
 type defaultDNS struct {
-	Upstream  []string
+	resolver *_Resolver
 }
 
 func newDeafaultDNS(d *DNS) *defaultDNS {
-    return &defaultDNS{Upstream: d.Upstream}
+    return &defaultDNS{resolver: resolver}
 }
 
 func (d *defaultDNS) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
-    r, _ = asyncQuery(r, d.Upstream)
-    w.WriteMsg(r)
+    forward(w, r, d.resolver)
+}
+
+// forward answers r with the real answer from upstream
+func forward(w dns.ResponseWriter, r *dns.Msg, res *_Resolver) {
+    m, u, err := res.exchange(r)
+    if err != nil {
+        m = new(dns.Msg)
+        m.SetRcode(r, dns.RcodeServerFailure)
+    } else {
+        annotate(w, func(e *QueryEntry) { e.Upstream = u.String() })
+    }
+    w.WriteMsg(m)
 }
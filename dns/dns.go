@@ -1,35 +1,85 @@
 package dns
 
 import (
-	"net"
-	"strconv"
-	"strings"
-	"time"
+	"errors"
 
 	"github.com/intxff/rdcross/component/fakeip"
-	"github.com/intxff/rdcross/component/iface"
+	"github.com/intxff/rdcross/egress"
 	"github.com/miekg/dns"
 )
 
 type DNS struct {
-	Enable   bool     `yaml:"enable"`
-	Listen   string   `yaml:"listen"`
-	Upstream []string `yaml:"upstream"`
-	FakeIP   FakeIP   `yaml:"fakeip"`
+	Enable   bool        `yaml:"enable"`
+	Listen   string      `yaml:"listen"`
+	Upstream []*Upstream `yaml:"upstream"`
+	// queried along with upstream, taken if answer of upstream is
+	// filtered by fallback filter
+	Fallback       []*Upstream    `yaml:"fallback"`
+	FallbackFilter FallbackFilter `yaml:"fallback_filter"`
+	FakeIP         FakeIP         `yaml:"fakeip"`
+	// static records answered before fakeip and upstream
+	Hosts     Hosts    `yaml:"hosts"`
+	HostsFile []string `yaml:"hosts_file"`
+	Block     Block    `yaml:"block"`
+	// ipv4-only, ipv6-only, prefer-v4 or prefer-v6 for domains of
+	// connections, prefer-v4 by default
+	Strategy string   `yaml:"strategy"`
+	QueryLog QueryLog `yaml:"query_log"`
+	DNSSEC   DNSSEC   `yaml:"dnssec"`
 }
 
 type FakeIP struct {
 	Enable bool   `yaml:"enable"`
 	Cidr   string `yaml:"cidr"`
-	Ttl    int    `yaml:"ttl"`
+	// optional ipv6 range for AAAA answers
+	Cidr6 string `yaml:"cidr6"`
+	Ttl   int    `yaml:"ttl"`
+	// domains answered with real ip, e.g. +.lan, time.windows.com
+	Filter []string `yaml:"filter"`
+	// keep mapping in config dir across restarts
+	Persist bool `yaml:"persist"`
 }
 
 type DNSServer struct {
 	*dns.Server
 }
 
-func (d *DNS) NewServer(pool *fakeip.FakeIP) *DNSServer {
+// NewServer creates dns server, e is used by upstreams which
+// send queries through egress. pool6 may be nil. dir is where
+// mmdb of fallback filter is kept.
+func (d *DNS) NewServer(dir string, pool, pool6 *fakeip.FakeIP, e map[string]egress.Egress) (*DNSServer, error) {
+	for _, u := range append(d.Upstream, d.Fallback...) {
+		if err := u.bind(e); err != nil {
+			return nil, err
+		}
+	}
+	if len(d.Fallback) != 0 {
+		f, err := newFallbackFilter(&d.FallbackFilter, dir)
+		if err != nil {
+			return nil, err
+		}
+		resolver.Fallback = d.Fallback
+		resolver.filter = f
+	}
+	h, err := newHosts(d.Hosts, d.HostsFile)
+	if err != nil {
+		return nil, err
+	}
+	strategy, err := parseStrategy(d.Strategy)
+	if err != nil {
+		return nil, err
+	}
 	resolver.Upstream = d.Upstream
+	resolver.hosts = h
+	resolver.strategy = strategy
+	if d.DNSSEC.Enable {
+		v, err := newValidator(&d.DNSSEC, resolver.query)
+		if err != nil {
+			return nil, err
+		}
+		resolver.validator = v
+	}
+
 	t := &DNSServer{
 		Server: &dns.Server{
 			Addr:    d.Listen,
@@ -38,65 +88,58 @@ func (d *DNS) NewServer(pool *fakeip.FakeIP) *DNSServer {
 		},
 	}
 	if d.FakeIP.Enable {
-		t.Handler = newFakeIPDNS(d.Upstream, pool)
+		t.Handler = newFakeIPDNS(resolver, pool, pool6, d.FakeIP.Ttl, d.FakeIP.Filter)
+	}
+	if len(d.Block.Lists) != 0 {
+		b, err := newBlocker(&d.Block)
+		if err != nil {
+			return nil, err
+		}
+		t.Handler = newBlockDNS(b, t.Handler)
+	}
+	// hosts wins over block lists
+	if !h.empty() {
+		t.Handler = newHostsDNS(h, t.Handler)
 	}
-	return t
+	if d.QueryLog.Enable {
+		l, err := newQueryLog(&d.QueryLog)
+		if err != nil {
+			return nil, err
+		}
+		t.Handler = newQueryLogDNS(l, t.Handler)
+	}
+	return t, nil
 }
 
-func asyncQuery(m *dns.Msg, upstream []string) (*dns.Msg, error) {
+// asyncQuery sends m to all upstream, the first answer wins
+func asyncQuery(m *dns.Msg, upstream []*Upstream) (*dns.Msg, *Upstream, error) {
 	type response struct {
 		m *dns.Msg
+		u *Upstream
 		e error
 	}
 
 	var (
-		l   = len(upstream)
-		rs  response
+		l  = len(upstream)
+		rs response
 	)
+	if l == 0 {
+		return nil, nil, errors.New("no upstream")
+	}
 	res := make(chan response, l)
 
 	for i := 0; i < l; i++ {
-		lIP, err := iface.GetIP()
-        if err != nil {
-            return nil, err
-        }
 		go func(i int) {
-			var rs *dns.Msg
-			// bind to avoid route decision
-			rAddrString := strings.Split(upstream[i], ":")
-			rIP := net.ParseIP(rAddrString[0])
-			rPort, _ := strconv.Atoi(rAddrString[1])
-			rAddr := &net.UDPAddr{
-				IP:   rIP,
-				Port: rPort,
-			}
-			conn, err := net.DialUDP("udp", &net.UDPAddr{IP: lIP}, rAddr)
-            if err != nil {
-				res <- response{nil, err}
-				return
-            }
-            dnsConn := &dns.Conn{Conn: conn}
-            dnsConn.SetWriteDeadline(time.Now().Add(1*time.Second))
-            err = dnsConn.WriteMsg(m)
-			if err != nil {
-				res <- response{nil, err}
-				return
-			}
-            dnsConn.SetReadDeadline(time.Now().Add(1*time.Second))
-            rs, err = dnsConn.ReadMsg()
-            if err != nil {
-				res <- response{nil, err}
-				return
-            }
-			res <- response{rs, err}
+			rs, err := upstream[i].Exchange(m)
+			res <- response{rs, upstream[i], err}
 		}(i)
 	}
 
 	for i := 0; i < l; i++ {
 		rs = <-res
 		if rs.e == nil {
-			return rs.m, rs.e
+			return rs.m, rs.u, rs.e
 		}
 	}
-	return rs.m, rs.e
+	return rs.m, nil, rs.e
 }
@@ -0,0 +1,475 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/intxff/rdcross/log"
+	"github.com/intxff/rdcross/util/lru"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+// DS of root KSK-2017
+const rootAnchor = ". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"
+
+const (
+	// zones whose state is kept
+	zoneCacheSize = 4096
+	minZoneTTL    = 60 * time.Second
+	maxZoneTTL    = time.Hour
+)
+
+var (
+	errBogus    = errors.New("dnssec bogus")
+	errInsecure = errors.New("dnssec insecure")
+)
+
+type DNSSEC struct {
+	Enable bool `yaml:"enable"`
+	// DS records of trust anchors, root KSK by default
+	TrustAnchor []string `yaml:"trust_anchor"`
+}
+
+type zoneKind int
+
+const (
+	// zone with verified keys
+	zoneSecure zoneKind = iota
+	// in or under a delegation proven unsigned
+	zoneInsecure
+	// secure, but not a zone cut
+	zoneNone
+)
+
+type zoneState struct {
+	kind   zoneKind
+	keys   []*dns.DNSKEY
+	expire time.Time
+}
+
+// validator checks answers by chain of trust from anchors, zones on
+// the chain are resolved by query which skips validation
+type validator struct {
+	anchors map[string][]*dns.DS
+	query   func(m *dns.Msg) (*dns.Msg, *Upstream, error)
+	zones   *lru.LRU
+}
+
+func newValidator(d *DNSSEC, query func(m *dns.Msg) (*dns.Msg, *Upstream, error)) (*validator, error) {
+	v := &validator{
+		anchors: make(map[string][]*dns.DS),
+		query:   query,
+		zones:   lru.New(zoneCacheSize),
+	}
+	anchors := d.TrustAnchor
+	if len(anchors) == 0 {
+		anchors = []string{rootAnchor}
+	}
+	for _, s := range anchors {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			return nil, err
+		}
+		ds, ok := rr.(*dns.DS)
+		if !ok {
+			return nil, fmt.Errorf("trust anchor %v is not ds", s)
+		}
+		zone := dns.CanonicalName(ds.Hdr.Name)
+		v.anchors[zone] = append(v.anchors[zone], ds)
+	}
+	return v, nil
+}
+
+// exchange sends m with DO and validates the answer. Bogus answer is
+// an error, secure one is marked by AD.
+func (v *validator) exchange(m *dns.Msg) (*dns.Msg, *Upstream, error) {
+	q := m.Copy()
+	setDO(q)
+	q.CheckingDisabled = true
+	r, u, err := v.query(q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// client does validation itself with CD
+	if !m.CheckingDisabled && (r.Rcode == dns.RcodeSuccess || r.Rcode == dns.RcodeNameError) {
+		secure, err := v.validate(r)
+		if err != nil {
+			log.Debug("[DNS] dnssec validation failed",
+				zap.String("domain", m.Question[0].Name),
+				zap.Error(err))
+			return nil, u, err
+		}
+		r.AuthenticatedData = secure
+	}
+	r.CheckingDisabled = m.CheckingDisabled
+
+	// drop records client did not ask for
+	if opt := m.IsEdns0(); opt == nil || !opt.Do() {
+		r.Answer = stripDNSSEC(r.Answer)
+		r.Ns = stripDNSSEC(r.Ns)
+		r.Extra = stripDNSSEC(r.Extra)
+		if opt == nil {
+			extra := r.Extra[:0]
+			for _, rr := range r.Extra {
+				if rr.Header().Rrtype != dns.TypeOPT {
+					extra = append(extra, rr)
+				}
+			}
+			r.Extra = extra
+		}
+	}
+	return r, u, nil
+}
+
+// validate checks every rrset in answer and authority. Unsigned rrsets
+// are accepted only if they are proven insecure, negative answers and
+// wildcard expansions must come with proofs of non-existence.
+func (v *validator) validate(m *dns.Msg) (bool, error) {
+	secure := true
+	var rrs []dns.RR
+	rrs = append(rrs, m.Answer...)
+	for _, rr := range m.Ns {
+		// referral ns are not signed by parent
+		if rr.Header().Rrtype != dns.TypeNS {
+			rrs = append(rrs, rr)
+		}
+	}
+	sets := groupRRsets(rrs)
+	if len(sets) == 0 {
+		sets = append(sets, &rrset{name: m.Question[0].Name})
+	}
+
+	for _, set := range sets {
+		err := errInsecure
+		if len(set.sigs) != 0 {
+			err = v.verify(set.rrs, set.sigs)
+		} else if !v.insecure(set.name) {
+			err = errBogus
+		}
+		switch err {
+		case nil:
+		case errInsecure:
+			secure = false
+		default:
+			return false, err
+		}
+	}
+	// signatures say nothing about names left out, they are proven by
+	// nsec or nsec3 in secure zones
+	if secure {
+		if err := v.complete(m); err != nil {
+			return false, err
+		}
+	}
+	return secure, nil
+}
+
+// insecure reports whether name is proven to be in unsigned zone
+func (v *validator) insecure(name string) bool {
+	st, err := v.zone(name)
+	return err == nil && st.kind == zoneInsecure
+}
+
+// verify checks rrset by any of sigs, errInsecure is returned if
+// signer zone is unsigned
+func (v *validator) verify(rrs []dns.RR, sigs []*dns.RRSIG) error {
+	hdr := rrs[0].Header()
+	err := errBogus
+	for _, sig := range sigs {
+		if !dns.IsSubDomain(sig.SignerName, hdr.Name) {
+			continue
+		}
+		keys, e := v.keys(sig.SignerName)
+		if e == errInsecure {
+			return e
+		}
+		if e != nil {
+			err = e
+			continue
+		}
+		for _, k := range keys {
+			if k.KeyTag() == sig.KeyTag && sig.Verify(k, rrs) == nil &&
+				sig.ValidityPeriod(time.Now()) {
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+// keys gets verified keys of signer zone
+func (v *validator) keys(signer string) ([]*dns.DNSKEY, error) {
+	st, err := v.zone(signer)
+	if err != nil {
+		return nil, err
+	}
+	switch st.kind {
+	case zoneInsecure:
+		return nil, errInsecure
+	case zoneNone:
+		return nil, fmt.Errorf("%w: signer %v is not a zone", errBogus, signer)
+	}
+	return st.keys, nil
+}
+
+// zone gets state of name on the chain of trust, parents are resolved
+// first since anything under an unsigned delegation is insecure
+func (v *validator) zone(name string) (*zoneState, error) {
+	name = dns.CanonicalName(name)
+	if t, exist := v.zones.Get(name); exist {
+		if st := t.(*zoneState); time.Now().Before(st.expire) {
+			return st, nil
+		}
+	}
+
+	var (
+		st  *zoneState
+		err error
+	)
+	if ds, exist := v.anchors[name]; exist {
+		st, err = v.dnskeys(name, ds)
+	} else if name == "." {
+		// without anchor of root, zones out of anchors can't be proven
+		st = &zoneState{kind: zoneInsecure, expire: time.Now().Add(maxZoneTTL)}
+	} else {
+		st, err = v.delegation(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	v.zones.Put(name, st)
+	return st, nil
+}
+
+// delegation finds out whether name is a signed zone, an unsigned
+// delegation or not a zone cut by querying its DS
+func (v *validator) delegation(name string) (*zoneState, error) {
+	parent, err := v.zone(parentName(name))
+	if err != nil {
+		return nil, err
+	}
+	if parent.kind == zoneInsecure {
+		return parent, nil
+	}
+
+	r, err := v.lookup(name, dns.TypeDS)
+	if err != nil {
+		return nil, err
+	}
+	expire := time.Now().Add(minZoneTTL)
+	for _, set := range groupRRsets(append(r.Answer, r.Ns...)) {
+		// records about name are signed by zones above it
+		sigs := set.sigs[:0]
+		for _, sig := range set.sigs {
+			if !strings.EqualFold(sig.SignerName, name) {
+				sigs = append(sigs, sig)
+			}
+		}
+		set.sigs = sigs
+		if len(set.sigs) == 0 {
+			// signed parent never gives unsigned answer
+			if set.rrtype == dns.TypeNS {
+				continue
+			}
+			return nil, fmt.Errorf("%w: unsigned %v of %v", errBogus,
+				dns.TypeToString[set.rrtype], set.name)
+		}
+		switch err := v.verify(set.rrs, set.sigs); err {
+		case nil:
+		case errInsecure:
+			return &zoneState{kind: zoneInsecure, expire: expire}, nil
+		default:
+			return nil, err
+		}
+		if set.rrtype != dns.TypeDS || !strings.EqualFold(set.name, name) {
+			continue
+		}
+		ds := make([]*dns.DS, 0, len(set.rrs))
+		for _, rr := range set.rrs {
+			ds = append(ds, rr.(*dns.DS))
+		}
+		return v.dnskeys(name, ds)
+	}
+
+	// no ds, a delegation without it is unsigned
+	if unsignedDelegation(name, r.Ns) {
+		return &zoneState{kind: zoneInsecure, expire: expire}, nil
+	}
+	return &zoneState{kind: zoneNone, expire: expire}, nil
+}
+
+// dnskeys gets keys of zone, which must match one of ds and sign
+// themselves
+func (v *validator) dnskeys(zone string, ds []*dns.DS) (*zoneState, error) {
+	r, err := v.lookup(zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		keys []*dns.DNSKEY
+		rrs  []dns.RR
+		sigs []*dns.RRSIG
+	)
+	for _, rr := range r.Answer {
+		switch t := rr.(type) {
+		case *dns.DNSKEY:
+			keys = append(keys, t)
+			rrs = append(rrs, t)
+		case *dns.RRSIG:
+			if t.TypeCovered == dns.TypeDNSKEY {
+				sigs = append(sigs, t)
+			}
+		}
+	}
+
+	for _, k := range keys {
+		if !matchDS(k, ds) {
+			continue
+		}
+		for _, sig := range sigs {
+			if sig.KeyTag == k.KeyTag() && sig.Verify(k, rrs) == nil &&
+				sig.ValidityPeriod(time.Now()) {
+				ttl := time.Duration(rrs[0].Header().Ttl) * time.Second
+				if ttl < minZoneTTL {
+					ttl = minZoneTTL
+				}
+				if ttl > maxZoneTTL {
+					ttl = maxZoneTTL
+				}
+				return &zoneState{kind: zoneSecure, keys: keys, expire: time.Now().Add(ttl)}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: no valid dnskey of %v", errBogus, zone)
+}
+
+func (v *validator) lookup(name string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	setDO(m)
+	m.CheckingDisabled = true
+	r, _, err := v.query(m)
+	if err != nil {
+		return nil, err
+	}
+	if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("query %v of %v: %v", dns.TypeToString[qtype],
+			name, dns.RcodeToString[r.Rcode])
+	}
+	return r, nil
+}
+
+func matchDS(k *dns.DNSKEY, ds []*dns.DS) bool {
+	for _, d := range ds {
+		t := k.ToDS(d.DigestType)
+		if t != nil && t.KeyTag == d.KeyTag && t.Algorithm == d.Algorithm &&
+			strings.EqualFold(t.Digest, d.Digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// unsignedDelegation checks denial of DS, name is an unsigned
+// delegation if it has NS but no DS, or is covered by opt-out NSEC3
+func unsignedDelegation(name string, ns []dns.RR) bool {
+	for _, rr := range ns {
+		switch t := rr.(type) {
+		case *dns.NSEC:
+			if strings.EqualFold(t.Hdr.Name, name) {
+				return hasType(t.TypeBitMap, dns.TypeNS) && !hasType(t.TypeBitMap, dns.TypeDS)
+			}
+		case *dns.NSEC3:
+			if t.Match(name) {
+				return hasType(t.TypeBitMap, dns.TypeNS) && !hasType(t.TypeBitMap, dns.TypeDS)
+			}
+			if t.Cover(name) && t.Flags&1 == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasType(bitmap []uint16, t uint16) bool {
+	for _, v := range bitmap {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+type rrset struct {
+	name   string
+	rrtype uint16
+	rrs    []dns.RR
+	sigs   []*dns.RRSIG
+}
+
+// groupRRsets groups records by name and type with their signatures
+func groupRRsets(rrs []dns.RR) []*rrset {
+	var sets []*rrset
+	index := make(map[string]*rrset)
+	get := func(name string, rrtype uint16) *rrset {
+		key := dns.CanonicalName(name) + "/" + dns.TypeToString[rrtype]
+		set, exist := index[key]
+		if !exist {
+			set = &rrset{name: name, rrtype: rrtype}
+			index[key] = set
+			sets = append(sets, set)
+		}
+		return set
+	}
+	for _, rr := range rrs {
+		switch t := rr.(type) {
+		case *dns.RRSIG:
+			set := get(t.Hdr.Name, t.TypeCovered)
+			set.sigs = append(set.sigs, t)
+		case *dns.OPT:
+		default:
+			set := get(rr.Header().Name, rr.Header().Rrtype)
+			set.rrs = append(set.rrs, rr)
+		}
+	}
+	// signatures without records are meaningless
+	out := sets[:0]
+	for _, set := range sets {
+		if len(set.rrs) != 0 {
+			out = append(out, set)
+		}
+	}
+	return out
+}
+
+func parentName(name string) string {
+	i, end := dns.NextLabel(name, 0)
+	if end {
+		return "."
+	}
+	return name[i:]
+}
+
+func setDO(m *dns.Msg) {
+	if opt := m.IsEdns0(); opt != nil {
+		opt.SetDo()
+		return
+	}
+	m.SetEdns0(dns.DefaultMsgSize, true)
+}
+
+func stripDNSSEC(rrs []dns.RR) []dns.RR {
+	out := rrs[:0]
+	for _, rr := range rrs {
+		switch rr.Header().Rrtype {
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+			continue
+		}
+		out = append(out, rr)
+	}
+	return out
+}
@@ -0,0 +1,204 @@
+package dns
+
+import (
+	"crypto"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type testKey struct {
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newTestKey(t *testing.T, zone string) testKey {
+	k := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := k.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{k, priv.(crypto.Signer)}
+}
+
+func (k testKey) sign(t *testing.T, rrs ...dns.RR) []dns.RR {
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrs[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+		KeyTag:     k.key.KeyTag(),
+		SignerName: k.key.Hdr.Name,
+		Algorithm:  k.key.Algorithm,
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+	}
+	if err := sig.Sign(k.priv, rrs); err != nil {
+		t.Fatal(err)
+	}
+	return append(rrs, sig)
+}
+
+func TestValidator(t *testing.T) {
+	root := newTestKey(t, ".")
+	example := newTestKey(t, "example.")
+	wrong := newTestKey(t, "example.")
+	rr := func(s string) dns.RR {
+		r, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	nsec := func(name string, types ...uint16) *dns.NSEC {
+		return &dns.NSEC{
+			Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 3600},
+			NextDomain: "zzz." + name,
+			TypeBitMap: types,
+		}
+	}
+
+	nsecNext := func(name, next string, types ...uint16) *dns.NSEC {
+		n := nsec(name, types...)
+		n.NextDomain = next
+		return n
+	}
+	// the only nsec3 of zone matches apex and covers any other name
+	nsec3 := &dns.NSEC3{
+		Hdr: dns.RR_Header{
+			Name:   dns.HashName("example.", dns.SHA1, 0, "") + ".example.",
+			Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 3600,
+		},
+		Hash:       dns.SHA1,
+		NextDomain: dns.HashName("example.", dns.SHA1, 0, ""),
+		TypeBitMap: []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeDNSKEY, dns.TypeNSEC3PARAM},
+	}
+	soa := example.sign(t, rr("example. 60 IN SOA ns.example. admin.example. 1 7200 3600 1209600 60"))
+	// a record of *.example. expanded to name
+	expand := func(name string) []dns.RR {
+		rrs := example.sign(t, rr("*.example. 60 IN A 192.0.2.5"))
+		for _, rr := range rrs {
+			rr.Header().Name = name
+		}
+		return rrs
+	}
+
+	type records struct {
+		rcode      int
+		answer, ns []dns.RR
+	}
+	zone := map[string]records{
+		"./DNSKEY":            {answer: root.sign(t, root.key)},
+		"example./DS":         {answer: root.sign(t, example.key.ToDS(dns.SHA256))},
+		"example./DNSKEY":     {answer: example.sign(t, example.key)},
+		"www.example./A":      {answer: example.sign(t, rr("www.example. 60 IN A 192.0.2.1"))},
+		"bad.example./A":      {answer: wrong.sign(t, rr("bad.example. 60 IN A 192.0.2.2"))},
+		"unsigned.example./A": {answer: []dns.RR{rr("unsigned.example. 60 IN A 192.0.2.3")}},
+		"unsigned.example./DS": {
+			ns: example.sign(t, nsec("unsigned.example.", dns.TypeA, dns.TypeNSEC)),
+		},
+		// delegation without ds
+		"insecure./DS": {
+			ns: root.sign(t, nsec("insecure.", dns.TypeNS, dns.TypeNSEC)),
+		},
+		"www.insecure./A": {answer: []dns.RR{rr("www.insecure. 60 IN A 192.0.2.4")}},
+		"gone.example./A": {
+			rcode: dns.RcodeNameError,
+			ns: append(soa, example.sign(t, nsecNext("example.", "www.example.",
+				dns.TypeSOA, dns.TypeNSEC))...),
+		},
+		// signed soa replayed without nsec
+		"forged.example./A": {rcode: dns.RcodeNameError, ns: soa},
+		"txt.example./A": {
+			ns: append(soa, example.sign(t, nsecNext("txt.example.", "www.example.",
+				dns.TypeTXT, dns.TypeNSEC))...),
+		},
+		// nsec of name says A exists
+		"nodata.example./A": {
+			ns: append(soa, example.sign(t, nsecNext("nodata.example.", "www.example.",
+				dns.TypeA, dns.TypeNSEC))...),
+		},
+		// nsec covering the name proves nothing about its types
+		"cover.example./A": {
+			ns: append(soa, example.sign(t, nsecNext("a.example.", "www.example.",
+				dns.TypeA, dns.TypeNSEC))...),
+		},
+		"n3.example./A": {
+			rcode: dns.RcodeNameError,
+			ns:    append(soa, example.sign(t, nsec3)...),
+		},
+		"n3data.example./A": {ns: append(soa, example.sign(t, nsec3)...)},
+		"wild.example./A": {
+			answer: expand("wild.example."),
+			ns: example.sign(t, nsecNext("a.example.", "www.example.",
+				dns.TypeA, dns.TypeNSEC)),
+		},
+		"host.example./A": {answer: expand("host.example.")},
+	}
+	query := func(m *dns.Msg) (*dns.Msg, *Upstream, error) {
+		if opt := m.IsEdns0(); opt == nil || !opt.Do() {
+			t.Errorf("query %v without DO", m.Question[0].Name)
+		}
+		q := m.Question[0]
+		r := new(dns.Msg)
+		r.SetReply(m)
+		records := zone[q.Name+"/"+dns.TypeToString[q.Qtype]]
+		r.Rcode, r.Answer, r.Ns = records.rcode, records.answer, records.ns
+		return r, nil, nil
+	}
+
+	v, err := newValidator(&DNSSEC{
+		Enable:      true,
+		TrustAnchor: []string{root.key.ToDS(dns.SHA256).String()},
+	}, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		secure bool
+		bogus  bool
+	}{
+		{"www.example.", true, false},
+		{"bad.example.", false, true},
+		{"unsigned.example.", false, true},
+		{"www.insecure.", false, false},
+		{"gone.example.", true, false},
+		{"forged.example.", false, true},
+		{"txt.example.", true, false},
+		{"nodata.example.", false, true},
+		{"cover.example.", false, true},
+		{"n3.example.", true, false},
+		{"n3data.example.", false, true},
+		{"wild.example.", true, false},
+		{"host.example.", false, true},
+	}
+	for _, tt := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tt.name, dns.TypeA)
+		r, _, err := v.exchange(m)
+		if tt.bogus {
+			if err == nil {
+				t.Errorf("%v: want bogus", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		if r.AuthenticatedData != tt.secure {
+			t.Errorf("%v: AD %v, want %v", tt.name, r.AuthenticatedData, tt.secure)
+		}
+		// client without DO gets no signatures
+		for _, rr := range r.Answer {
+			if rr.Header().Rrtype == dns.TypeRRSIG {
+				t.Errorf("%v: unexpected rrsig", tt.name)
+			}
+		}
+	}
+}
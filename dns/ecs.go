@@ -0,0 +1,100 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	ECSPassthrough = "passthrough"
+	ECSStrip       = "strip"
+)
+
+// ecs decides EDNS Client Subnet option sent to upstream. It is
+// passthrough, strip or a subnet like 1.2.3.0/24 which is injected.
+type ecs struct {
+	mode   string
+	subnet *dns.EDNS0_SUBNET
+}
+
+func parseECS(s string) (*ecs, error) {
+	switch strings.ToLower(s) {
+	case "", ECSPassthrough:
+		return &ecs{mode: ECSPassthrough}, nil
+	case ECSStrip:
+		return &ecs{mode: ECSStrip}, nil
+	}
+
+	ip, cidr, err := net.ParseCIDR(s)
+	if err != nil {
+		if ip = net.ParseIP(s); ip == nil {
+			return nil, fmt.Errorf("invalid ecs %v", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			bits = 8 * net.IPv4len
+		}
+		cidr = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	}
+	subnet := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET}
+	ones, _ := cidr.Mask.Size()
+	subnet.SourceNetmask = uint8(ones)
+	if v4 := cidr.IP.To4(); v4 != nil {
+		subnet.Family, subnet.Address = 1, v4
+	} else {
+		subnet.Family, subnet.Address = 2, cidr.IP
+	}
+	return &ecs{mode: s, subnet: subnet}, nil
+}
+
+func (e *ecs) String() string {
+	return e.mode
+}
+
+// query gets message sent to upstream, m is copied if it is changed
+// since it is shared by all upstreams
+func (e *ecs) query(m *dns.Msg) *dns.Msg {
+	if e == nil || e.mode == ECSPassthrough {
+		return m
+	}
+	q := m.Copy()
+	opt := q.IsEdns0()
+	if e.subnet == nil {
+		if opt != nil {
+			removeECS(opt)
+		}
+		return q
+	}
+	if opt == nil {
+		q.SetEdns0(dns.DefaultMsgSize, false)
+		opt = q.IsEdns0()
+	}
+	removeECS(opt)
+	subnet := *e.subnet
+	opt.Option = append(opt.Option, &subnet)
+	return q
+}
+
+// answer removes ecs which is not from client out of answer
+func (e *ecs) answer(r *dns.Msg) {
+	if e == nil || e.mode == ECSPassthrough || r == nil {
+		return
+	}
+	if opt := r.IsEdns0(); opt != nil {
+		removeECS(opt)
+	}
+}
+
+func removeECS(opt *dns.OPT) {
+	options := opt.Option[:0]
+	for _, o := range opt.Option {
+		if o.Option() == dns.EDNS0SUBNET {
+			continue
+		}
+		options = append(options, o)
+	}
+	opt.Option = options
+}
@@ -0,0 +1,131 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// subnets gets ecs options in opt record of m
+func subnets(m *dns.Msg) []*dns.EDNS0_SUBNET {
+	var out []*dns.EDNS0_SUBNET
+	if opt := m.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if s, ok := o.(*dns.EDNS0_SUBNET); ok {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// clientQuery has ecs of client and a cookie
+func clientQuery() *dns.Msg {
+	m := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	m.SetEdns0(1232, true)
+	opt := m.IsEdns0()
+	opt.Option = append(opt.Option,
+		&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.IPv4(198, 51, 100, 0).To4()},
+		&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708"})
+	return m
+}
+
+func TestParseECS(t *testing.T) {
+	cases := []struct {
+		s      string
+		mode   string
+		family uint16
+		mask   uint8
+		addr   string
+	}{
+		{"", ECSPassthrough, 0, 0, ""},
+		{"Strip", ECSStrip, 0, 0, ""},
+		{"203.0.113.7/24", "203.0.113.7/24", 1, 24, "203.0.113.0"},
+		{"203.0.113.7", "203.0.113.7", 1, 32, "203.0.113.7"},
+		{"2001:db8::1/56", "2001:db8::1/56", 2, 56, "2001:db8::"},
+	}
+	for _, c := range cases {
+		e, err := parseECS(c.s)
+		if err != nil || e.mode != c.mode {
+			t.Errorf("%q: %v %v", c.s, e, err)
+			continue
+		}
+		if c.family == 0 {
+			if e.subnet != nil {
+				t.Errorf("%q: subnet %v", c.s, e.subnet)
+			}
+			continue
+		}
+		if s := e.subnet; s.Family != c.family || s.SourceNetmask != c.mask || s.Address.String() != c.addr {
+			t.Errorf("%q: subnet %v", c.s, s)
+		}
+	}
+	if _, err := parseECS("nowhere"); err == nil {
+		t.Error("invalid ecs accepted")
+	}
+}
+
+func TestECSQuery(t *testing.T) {
+	// passthrough sends query of client as it is
+	e, _ := parseECS(ECSPassthrough)
+	m := clientQuery()
+	if q := e.query(m); q != m || len(subnets(q)) != 1 {
+		t.Errorf("passthrough: %v", q)
+	}
+
+	// strip keeps other options and do bit
+	e, _ = parseECS(ECSStrip)
+	q := e.query(m)
+	if len(subnets(q)) != 0 || len(q.IsEdns0().Option) != 1 || !q.IsEdns0().Do() {
+		t.Errorf("strip: %v", q)
+	}
+	plain := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	if q := e.query(plain); q.IsEdns0() != nil {
+		t.Errorf("strip added opt: %v", q)
+	}
+
+	// inject replaces ecs of client, and adds opt if there is none
+	e, _ = parseECS("203.0.113.0/24")
+	for _, m := range []*dns.Msg{clientQuery(), plain} {
+		q := e.query(m)
+		s := subnets(q)
+		if len(s) != 1 || s[0].Address.String() != "203.0.113.0" || s[0].SourceNetmask != 24 {
+			t.Errorf("inject: %v", q)
+		}
+	}
+	if q := e.query(clientQuery()); len(q.IsEdns0().Option) != 2 {
+		t.Errorf("inject dropped other options: %v", q)
+	}
+
+	// query of client is shared by upstreams, so it is never changed
+	if s := subnets(m); len(s) != 1 || s[0].Address.String() != "198.51.100.0" || len(m.IsEdns0().Option) != 2 {
+		t.Errorf("query of client changed: %v", m)
+	}
+}
+
+func TestECSAnswer(t *testing.T) {
+	answer := func() *dns.Msg {
+		r := new(dns.Msg).SetReply(clientQuery())
+		r.SetEdns0(1232, false)
+		r.IsEdns0().Option = append(r.IsEdns0().Option,
+			&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, SourceScope: 16, Address: net.IPv4(203, 0, 113, 0).To4()})
+		return r
+	}
+	cases := []struct {
+		ecs  string
+		kept int
+	}{
+		{ECSPassthrough, 1},
+		{ECSStrip, 0},
+		{"203.0.113.0/24", 0},
+	}
+	for _, c := range cases {
+		e, _ := parseECS(c.ecs)
+		r := answer()
+		e.answer(r)
+		if len(subnets(r)) != c.kept {
+			t.Errorf("%v: %v", c.ecs, r)
+		}
+	}
+}
@@ -11,61 +11,153 @@ import (
 var fake *fakeipDNS
 
 func GetDomainByIP(ip net.IP) (string, bool) {
-	return fake.fakeip.GetDomainByIP(ip)
+	if fake == nil {
+		return "", false
+	}
+	return fake.domainByIP(ip)
 }
 
 type fakeipDNS struct {
 	fakeip   *fakeip.FakeIP
-	upstream []string
+	fakeip6  *fakeip.FakeIP
+	ttl      uint32
+	resolver *_Resolver
+	filter   *domainSet
 }
 
-func newFakeIPDNS(upstream []string, pool *fakeip.FakeIP) *fakeipDNS {
+// pool6 is optional, AAAA queries get empty answer without it
+func newFakeIPDNS(res *_Resolver, pool, pool6 *fakeip.FakeIP, ttl int, filter []string) *fakeipDNS {
 	t := &fakeipDNS{
 		fakeip:   pool,
-		upstream: upstream,
+		fakeip6:  pool6,
+		ttl:      uint32(ttl),
+		resolver: res,
+		filter:   newDomainSet(),
+	}
+	for _, v := range filter {
+		t.filter.add(v)
 	}
 	fake = t
 	return t
 }
 
+// filtered domains need real ip
+func (h *fakeipDNS) filtered(qname string) bool {
+	return h.filter.len() != 0 && h.filter.match(normalize(qname))
+}
+
+func (h *fakeipDNS) domainByIP(ip net.IP) (string, bool) {
+	if domain, exist := h.fakeip.GetDomainByIP(ip); exist {
+		return domain, true
+	}
+	if h.fakeip6 != nil {
+		return h.fakeip6.GetDomainByIP(ip)
+	}
+	return "", false
+}
+
+// lookupOrPut gets fake ip of domain, a new one is put if not exist
+func lookupOrPut(w dns.ResponseWriter, pool *fakeip.FakeIP, domain string) net.IP {
+	ip, exist := pool.GetIPByDomain(domain)
+	if !exist {
+		ip = pool.Put(domain)
+	}
+	annotate(w, func(e *QueryEntry) {
+		e.FakeIP = ip.String()
+	})
+	return ip
+}
+
+func (h *fakeipDNS) header(q dns.Question) dns.RR_Header {
+	return dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: h.ttl}
+}
+
 func (h *fakeipDNS) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
-	var (
-		rr    dns.RR
-		ip    net.IP
-		exist bool
-	)
-	qname := r.Question[0].Name
-	qtype := r.Question[0].Qtype
+	q := r.Question[0]
+	if h.filtered(q.Name) {
+		forward(w, r, h.resolver)
+		return
+	}
 	m := new(dns.Msg)
 	m.SetReply(r)
 	m.Compress = true
 
-	if ip, exist = h.fakeip.GetIPByDomain(qname); !exist {
-		ip = h.fakeip.Put(qname)
+	switch q.Qtype {
+	case dns.TypeA:
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: h.header(q),
+			A:   lookupOrPut(w, h.fakeip, q.Name).To4(),
+		})
+	case dns.TypeAAAA:
+		// without v6 pool answer nodata, client will fall back to A
+		if h.fakeip6 != nil {
+			m.Answer = append(m.Answer, &dns.AAAA{
+				Hdr:  h.header(q),
+				AAAA: lookupOrPut(w, h.fakeip6, q.Name).To16(),
+			})
+		}
+	case dns.TypePTR:
+		ptrIP := net.ParseIP(ExtractAddressFromReverse(q.Name))
+		domain, exist := h.domainByIP(ptrIP)
+		if !exist {
+			forward(w, r, h.resolver)
+			return
+		}
+		m.Answer = append(m.Answer, &dns.PTR{
+			Hdr: h.header(q),
+			Ptr: domain,
+		})
+	case dns.TypeHTTPS, dns.TypeSVCB:
+		h.serveSVCB(w, r)
+		return
+	default:
+		// MX, TXT, SRV and others carry no address to fake
+		forward(w, r, h.resolver)
+		return
+	}
+	w.WriteMsg(m)
+}
+
+// serveSVCB answers real HTTPS/SVCB records without address hints,
+// so that clients connect to addresses from A/AAAA which are faked
+func (h *fakeipDNS) serveSVCB(w dns.ResponseWriter, r *dns.Msg) {
+	m, u, err := h.resolver.exchange(r)
+	if err != nil {
+		m = new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		w.WriteMsg(m)
+		return
 	}
-    if qtype == dns.TypePTR {
-        ptrIP := ExtractAddressFromReverse(r.Question[0].Name)
-        domain, exist := h.fakeip.GetDomainByIP(net.ParseIP(ptrIP))
-        if !exist {
-            domain = ""
-        }
-        rr = &dns.PTR {
-			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 0},
-            Ptr: domain,
-        }
-    }
-	if qtype == dns.TypeA {
-		rr = &dns.A{
-			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 0},
-			A:   ip,
+	annotate(w, func(e *QueryEntry) { e.Upstream = u.String() })
+	for _, rr := range m.Answer {
+		var svcb *dns.SVCB
+		switch v := rr.(type) {
+		case *dns.HTTPS:
+			svcb = &v.SVCB
+		case *dns.SVCB:
+			svcb = v
+		default:
+			continue
+		}
+		values := svcb.Value[:0]
+		for _, kv := range svcb.Value {
+			switch kv.(type) {
+			case *dns.SVCBIPv4Hint, *dns.SVCBIPv6Hint:
+				continue
+			}
+			values = append(values, kv)
 		}
+		svcb.Value = values
 	}
-	if qtype == dns.TypeAAAA {
-		rr = &dns.AAAA{
-			Hdr:  dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 0},
-			AAAA: ip.To16(),
+	// drop real addresses of target name in additional section
+	extra := m.Extra[:0]
+	for _, rr := range m.Extra {
+		switch rr.(type) {
+		case *dns.A, *dns.AAAA:
+			continue
 		}
+		extra = append(extra, rr)
 	}
-	m.Answer = append(m.Answer, rr)
+	m.Extra = extra
 	w.WriteMsg(m)
 }
@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/intxff/rdcross/component/mmdb"
+	"github.com/miekg/dns"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// FallbackFilter decides whether answer of primary upstream is
+// poisoned, then answer of fallback upstream is taken
+type FallbackFilter struct {
+	// iso code of country primary answers are expected in, e.g. CN.
+	// Private, loopback and link local addresses have no country and
+	// are always expected.
+	GeoIP string `yaml:"geoip"`
+	// bogus ips returned by poisoning
+	IPCidr []string `yaml:"ipcidr"`
+}
+
+type fallbackFilter struct {
+	country string
+	// iso code of country of ip, nil if geoip is not set
+	lookup func(ip net.IP) (string, error)
+	bogus  []*net.IPNet
+}
+
+// newFallbackFilter loads mmdb in dir if geoip is set
+func newFallbackFilter(f *FallbackFilter, dir string) (*fallbackFilter, error) {
+	t := &fallbackFilter{country: strings.ToUpper(f.GeoIP)}
+	for _, v := range f.IPCidr {
+		_, cidr, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fallback ipcidr %v", v)
+		}
+		t.bogus = append(t.bogus, cidr)
+	}
+	if t.country != "" {
+		path := filepath.Join(dir, "mmdb")
+		if err := mmdb.InitMMDB(path); err != nil {
+			return nil, err
+		}
+		t.lookup = countryOf(mmdb.Instance(path))
+	}
+	return t, nil
+}
+
+func countryOf(db *geoip2.Reader) func(ip net.IP) (string, error) {
+	return func(ip net.IP) (string, error) {
+		country, err := db.Country(ip)
+		if err != nil {
+			return "", err
+		}
+		return country.Country.IsoCode, nil
+	}
+}
+
+// local tells ip is not routed on internet, so it has no country
+func local(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified()
+}
+
+// accept reports whether every address in m is trustable
+func (f *fallbackFilter) accept(m *dns.Msg) bool {
+	for _, rr := range m.Answer {
+		var ip net.IP
+		switch v := rr.(type) {
+		case *dns.A:
+			ip = v.A
+		case *dns.AAAA:
+			ip = v.AAAA
+		default:
+			continue
+		}
+		for _, cidr := range f.bogus {
+			if cidr.Contains(ip) {
+				return false
+			}
+		}
+		if f.lookup != nil && !local(ip) {
+			country, err := f.lookup(ip)
+			if err != nil || country != f.country {
+				return false
+			}
+		}
+	}
+	return true
+}
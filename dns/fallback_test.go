@@ -0,0 +1,105 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// stubUpstream serves answer on a local udp port, which is shut down
+// when test ends
+func stubUpstream(t *testing.T, answer dns.HandlerFunc) *Upstream {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: answer}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+	u, err := ParseUpstream(pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func newTestFilter(t *testing.T) *fallbackFilter {
+	f, err := newFallbackFilter(&FallbackFilter{IPCidr: []string{"198.18.0.0/15"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.country = "CN"
+	countries := map[string]string{"203.0.113.1": "CN", "198.51.100.1": "US"}
+	f.lookup = func(ip net.IP) (string, error) {
+		if c, exist := countries[ip.String()]; exist {
+			return c, nil
+		}
+		return "", errors.New("not found")
+	}
+	return f
+}
+
+func TestFallbackFilter(t *testing.T) {
+	f := newTestFilter(t)
+	cases := []struct {
+		rr     string
+		accept bool
+	}{
+		{"a.example. 60 IN A 203.0.113.1", true},
+		{"a.example. 60 IN A 198.18.0.1", false},
+		{"a.example. 60 IN A 198.51.100.1", false},
+		{"a.example. 60 IN A 192.0.2.1", false},
+		// no country, still expected
+		{"a.example. 60 IN A 192.168.1.1", true},
+		{"a.example. 60 IN AAAA fd00::1", true},
+		{"a.example. 60 IN CNAME b.example.", true},
+	}
+	for _, c := range cases {
+		rr, err := dns.NewRR(c.rr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := new(dns.Msg)
+		m.Answer = []dns.RR{rr}
+		if f.accept(m) != c.accept {
+			t.Errorf("%v: accepted %v", c.rr, !c.accept)
+		}
+	}
+}
+
+func TestFallbackQuery(t *testing.T) {
+	reply := func(ip string) dns.HandlerFunc {
+		return func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg).SetReply(r)
+			rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A " + ip)
+			m.Answer = append(m.Answer, rr)
+			w.WriteMsg(m)
+		}
+	}
+	cases := []struct {
+		name    string
+		primary string
+		want    string
+	}{
+		{"primary wins", "203.0.113.1", "203.0.113.1"},
+		{"bogus ip", "198.18.0.1", "198.51.100.9"},
+		{"geoip mismatch", "198.51.100.1", "198.51.100.9"},
+	}
+	fallback := stubUpstream(t, reply("198.51.100.9"))
+	for _, c := range cases {
+		primary := stubUpstream(t, reply(c.primary))
+		r := &_Resolver{Upstream: []*Upstream{primary}, Fallback: []*Upstream{fallback}, filter: newTestFilter(t)}
+		m, u, err := r.query(new(dns.Msg).SetQuestion("example.com.", dns.TypeA))
+		if err != nil || len(m.Answer) != 1 {
+			t.Fatalf("%v: %v %v", c.name, m, err)
+		}
+		if got := m.Answer[0].(*dns.A).A.String(); got != c.want {
+			t.Errorf("%v: answer %v, want %v", c.name, got, c.want)
+		}
+		if (u == primary) != (c.want == c.primary) {
+			t.Errorf("%v: answered by wrong upstream", c.name)
+		}
+	}
+}
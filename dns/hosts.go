@@ -0,0 +1,285 @@
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/intxff/rdcross/log"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// max cname records followed within hosts
+const maxCnameChain = 8
+
+// Hosts maps a domain to ips or to another domain as cname. Domain
+// may be a wildcard like +.example.com
+type Hosts map[string][]string
+
+func (h *Hosts) UnmarshalYAML(value *yaml.Node) error {
+	temp := make(map[string]any)
+	if err := value.Decode(&temp); err != nil {
+		return err
+	}
+	*h = make(Hosts)
+	for k, v := range temp {
+		switch t := v.(type) {
+		case string:
+			(*h)[k] = []string{t}
+		case []any:
+			for _, e := range t {
+				s, ok := e.(string)
+				if !ok {
+					return fmt.Errorf("invalid hosts entry %v", k)
+				}
+				(*h)[k] = append((*h)[k], s)
+			}
+		default:
+			return fmt.Errorf("invalid hosts entry %v", k)
+		}
+	}
+	return nil
+}
+
+type hostEntry struct {
+	ipv4  []net.IP
+	ipv6  []net.IP
+	cname string
+}
+
+type hosts struct {
+	exact map[string]*hostEntry
+	// wildcard +.domain by domain, it matches domain and subdomains
+	wild map[string]*hostEntry
+	// ip to domain for PTR
+	reverse map[string]string
+}
+
+func normalize(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+// newHosts loads files in /etc/hosts format, static entries override
+// entries of the same domain in files
+func newHosts(static Hosts, files []string) (*hosts, error) {
+	entries := make(map[string]*hostEntry)
+	h := &hosts{
+		exact:   make(map[string]*hostEntry),
+		wild:    make(map[string]*hostEntry),
+		reverse: make(map[string]string),
+	}
+	// static domains in the same form as those in files
+	overridden := make(map[string]bool, len(static))
+	for domain := range static {
+		overridden[normalize(domain)] = true
+	}
+
+	add := func(domain, value string) error {
+		domain = normalize(domain)
+		e, exist := entries[domain]
+		if !exist {
+			e = &hostEntry{}
+			entries[domain] = e
+		}
+		ip := net.ParseIP(value)
+		switch {
+		case ip == nil:
+			if len(e.ipv4)+len(e.ipv6) != 0 {
+				return fmt.Errorf("hosts %v: cname can't coexist with ip", domain)
+			}
+			e.cname = dns.Fqdn(normalize(value))
+			return nil
+		case e.cname != "":
+			return fmt.Errorf("hosts %v: cname can't coexist with ip", domain)
+		case ip.To4() != nil:
+			e.ipv4 = append(e.ipv4, ip.To4())
+		default:
+			e.ipv6 = append(e.ipv6, ip)
+		}
+		if !strings.HasPrefix(domain, "+.") {
+			if _, exist := h.reverse[ip.String()]; !exist {
+				h.reverse[ip.String()] = dns.Fqdn(domain)
+			}
+		}
+		return nil
+	}
+
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for n := 1; scanner.Scan(); n++ {
+			line := scanner.Text()
+			if i := strings.IndexByte(line, '#'); i >= 0 {
+				line = line[:i]
+			}
+			fields := strings.Fields(line)
+			if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+				continue
+			}
+			for _, domain := range fields[1:] {
+				if overridden[normalize(domain)] {
+					continue
+				}
+				if err := add(domain, fields[0]); err != nil {
+					log.Error("[DNS] invalid hosts entry",
+						zap.String("file", path), zap.Int("line", n), zap.Error(err))
+				}
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for domain, values := range static {
+		for _, v := range values {
+			if err := add(domain, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for domain, e := range entries {
+		if strings.HasPrefix(domain, "+.") {
+			h.wild[domain[2:]] = e
+			continue
+		}
+		h.exact[domain] = e
+	}
+	return h, nil
+}
+
+func (h *hosts) empty() bool {
+	return h == nil || len(h.exact)+len(h.wild) == 0
+}
+
+// lookup matches exact domain first, then the nearest wildcard
+func (h *hosts) lookup(name string) (*hostEntry, bool) {
+	if h.empty() {
+		return nil, false
+	}
+	name = normalize(name)
+	if e, exist := h.exact[name]; exist {
+		return e, true
+	}
+	for {
+		if e, exist := h.wild[name]; exist {
+			return e, true
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			return nil, false
+		}
+		name = name[i+1:]
+	}
+}
+
+// answer gets records of name from hosts. The last name of cname chain
+// is returned if it is not in hosts, it should be resolved elsewhere.
+func (h *hosts) answer(name string, qtype uint16) ([]dns.RR, string, bool) {
+	var rrs []dns.RR
+	e, exist := h.lookup(name)
+	if !exist {
+		return nil, "", false
+	}
+	for i := 0; i < maxCnameChain && e.cname != ""; i++ {
+		rrs = append(rrs, &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: hostsTTL},
+			Target: e.cname,
+		})
+		if qtype == dns.TypeCNAME {
+			return rrs, "", true
+		}
+		name = e.cname
+		if e, exist = h.lookup(name); !exist {
+			return rrs, name, true
+		}
+	}
+
+	switch qtype {
+	case dns.TypeA:
+		for _, ip := range e.ipv4 {
+			rrs = append(rrs, &dns.A{
+				Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: hostsTTL},
+				A:   ip,
+			})
+		}
+	case dns.TypeAAAA:
+		for _, ip := range e.ipv6 {
+			rrs = append(rrs, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: hostsTTL},
+				AAAA: ip,
+			})
+		}
+	}
+	return rrs, "", true
+}
+
+const hostsTTL = 10
+
+// hostsDNS answers domains in hosts, others are passed to next
+type hostsDNS struct {
+	hosts *hosts
+	next  dns.Handler
+}
+
+func newHostsDNS(h *hosts, next dns.Handler) *hostsDNS {
+	return &hostsDNS{hosts: h, next: next}
+}
+
+func (h *hostsDNS) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	q := r.Question[0]
+	if q.Qtype == dns.TypePTR {
+		ip := ExtractAddressFromReverse(q.Name)
+		if domain, exist := h.hosts.reverse[ip]; exist && ip != "" {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Answer = append(m.Answer, &dns.PTR{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: hostsTTL},
+				Ptr: domain,
+			})
+			w.WriteMsg(m)
+			return
+		}
+	}
+
+	rrs, target, exist := h.hosts.answer(q.Name, q.Qtype)
+	if !exist {
+		h.next.ServeDNS(w, r)
+		return
+	}
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Answer = rrs
+	// follow cname out of hosts
+	if target != "" {
+		sub := r.Copy()
+		sub.Question[0].Name = target
+		cw := &captureWriter{ResponseWriter: w}
+		h.next.ServeDNS(cw, sub)
+		if cw.msg != nil {
+			m.Rcode = cw.msg.Rcode
+			m.Answer = append(m.Answer, cw.msg.Answer...)
+		}
+	}
+	w.WriteMsg(m)
+}
+
+// captureWriter keeps the answer instead of sending it to client
+type captureWriter struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (c *captureWriter) WriteMsg(m *dns.Msg) error {
+	c.msg = m
+	return nil
+}
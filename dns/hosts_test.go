@@ -0,0 +1,60 @@
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	content := `# comment
+10.0.0.1 router.lan
+10.0.0.2 printer.lan
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h, err := newHosts(Hosts{
+		"Router.lan":     {"192.168.1.1"},
+		"+.Dev.LAN.":     {"127.0.0.1"},
+		"NAS.lan":        {"Router.LAN"},
+		"storage.b.corp": {"10.1.1.1"},
+	}, []string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		want string
+	}{
+		// static entry overrides file regardless of case
+		{"router.lan.", "192.168.1.1"},
+		{"ROUTER.LAN.", "192.168.1.1"},
+		{"printer.lan.", "10.0.0.2"},
+		{"dev.lan.", "127.0.0.1"},
+		{"a.b.dev.lan.", "127.0.0.1"},
+		{"nas.lan.", "192.168.1.1"},
+		// labels are matched from the same level
+		{"storage.a.b.corp.", ""},
+		{"router.x.lan.", ""},
+	}
+	for _, c := range cases {
+		rrs, _, exist := h.answer(c.name, dns.TypeA)
+		got := ""
+		if exist && len(rrs) > 0 {
+			if a, ok := rrs[len(rrs)-1].(*dns.A); ok {
+				got = a.A.String()
+			}
+		}
+		if got != c.want {
+			t.Errorf("%v: got %q, want %q", c.name, got, c.want)
+		}
+	}
+	if e, _ := h.lookup("router.lan"); len(e.ipv4) != 1 {
+		t.Errorf("file entry merged into static one: %v", e.ipv4)
+	}
+}
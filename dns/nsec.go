@@ -0,0 +1,255 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// complete checks the answer of verified m is not cut short: wildcard
+// expansions need proof of no closer match, NXDOMAIN and NODATA need
+// proof of no such name or type. Records of proofs are verified ahead.
+func (v *validator) complete(m *dns.Msg) error {
+	var (
+		nsec  []*dns.NSEC
+		nsec3 []*dns.NSEC3
+	)
+	for _, rr := range m.Ns {
+		switch t := rr.(type) {
+		case *dns.NSEC:
+			nsec = append(nsec, t)
+		case *dns.NSEC3:
+			nsec3 = append(nsec3, t)
+		}
+	}
+
+	// signature of expanded wildcard has less labels than its owner
+	for _, set := range groupRRsets(m.Answer) {
+		for _, sig := range set.sigs {
+			if int(sig.Labels) >= dns.CountLabel(set.name) {
+				continue
+			}
+			if !provenWildcard(set.name, int(sig.Labels), nsec, nsec3) {
+				return fmt.Errorf("%w: no proof of wildcard expansion %v", errBogus, set.name)
+			}
+		}
+	}
+
+	// the name asked at last in a chain of cname
+	q := m.Question[0]
+	target := q.Name
+	for i := 0; i < len(m.Answer); i++ {
+		next := target
+		for _, rr := range m.Answer {
+			if c, ok := rr.(*dns.CNAME); ok && strings.EqualFold(c.Hdr.Name, target) {
+				next = c.Target
+			}
+		}
+		if next == target {
+			break
+		}
+		target = next
+	}
+	for _, rr := range m.Answer {
+		hdr := rr.Header()
+		if strings.EqualFold(hdr.Name, target) &&
+			(hdr.Rrtype == q.Qtype || q.Qtype == dns.TypeANY) {
+			return nil
+		}
+	}
+	if q.Qtype == dns.TypeCNAME && target != q.Name {
+		return nil
+	}
+	// cname into unsigned zone
+	if v.insecure(target) {
+		return nil
+	}
+
+	if m.Rcode == dns.RcodeNameError {
+		if provenNXDomain(target, nsec, nsec3) {
+			return nil
+		}
+		return fmt.Errorf("%w: no proof of nxdomain %v", errBogus, target)
+	}
+	if provenNoData(target, q.Qtype, nsec, nsec3) {
+		return nil
+	}
+	return fmt.Errorf("%w: no proof of nodata %v %v", errBogus, target,
+		dns.TypeToString[q.Qtype])
+}
+
+// provenNXDomain checks name and wildcard at its closest encloser do
+// not exist
+func provenNXDomain(name string, nsec []*dns.NSEC, nsec3 []*dns.NSEC3) bool {
+	for _, n := range nsec {
+		if !nsecCover(n, name) {
+			continue
+		}
+		wildcard := "*." + nsecEncloser(n, name)
+		for _, w := range nsec {
+			if nsecCover(w, wildcard) {
+				return true
+			}
+		}
+	}
+	if ce, _, ok := nsec3Encloser(name, nsec3); ok {
+		return nsec3Covered(nsec3, "*."+ce)
+	}
+	return false
+}
+
+// provenNoData checks name exists without qtype, or is expanded from a
+// wildcard without qtype
+func provenNoData(name string, qtype uint16, nsec []*dns.NSEC, nsec3 []*dns.NSEC3) bool {
+	for _, n := range nsec {
+		if strings.EqualFold(n.Hdr.Name, name) {
+			return noType(n.TypeBitMap, qtype)
+		}
+	}
+	for _, n := range nsec {
+		if !nsecCover(n, name) {
+			continue
+		}
+		wildcard := "*." + nsecEncloser(n, name)
+		for _, w := range nsec {
+			if strings.EqualFold(w.Hdr.Name, wildcard) {
+				return noType(w.TypeBitMap, qtype)
+			}
+		}
+	}
+
+	for _, n := range nsec3 {
+		if n.Match(name) {
+			return noType(n.TypeBitMap, qtype)
+		}
+	}
+	ce, optOut, ok := nsec3Encloser(name, nsec3)
+	if !ok {
+		return false
+	}
+	// unsigned delegation under opt-out has no nsec3 of its own
+	if qtype == dns.TypeDS && optOut {
+		return true
+	}
+	for _, w := range nsec3 {
+		if w.Match("*." + ce) {
+			return noType(w.TypeBitMap, qtype)
+		}
+	}
+	return false
+}
+
+// provenWildcard checks name expanded from wildcard with labels of
+// source does not exist itself
+func provenWildcard(name string, labels int, nsec []*dns.NSEC, nsec3 []*dns.NSEC3) bool {
+	for _, n := range nsec {
+		if nsecCover(n, name) {
+			return true
+		}
+	}
+	return nsec3Covered(nsec3, suffix(name, labels+1))
+}
+
+// noType checks type bitmap of existing name denies qtype. Bitmap of
+// delegation in parent zone tells nothing about records of child.
+func noType(bitmap []uint16, qtype uint16) bool {
+	if hasType(bitmap, qtype) || hasType(bitmap, dns.TypeCNAME) {
+		return false
+	}
+	if qtype != dns.TypeDS && hasType(bitmap, dns.TypeNS) && !hasType(bitmap, dns.TypeSOA) {
+		return false
+	}
+	return true
+}
+
+// nsecCover checks name falls between owner and next of n in canonical
+// order, the last nsec of zone wraps to apex
+func nsecCover(n *dns.NSEC, name string) bool {
+	owner, next := n.Hdr.Name, n.NextDomain
+	// names under delegation or dname are not in zone of n
+	if !strings.EqualFold(owner, name) && dns.IsSubDomain(owner, name) &&
+		(hasType(n.TypeBitMap, dns.TypeDNAME) ||
+			(hasType(n.TypeBitMap, dns.TypeNS) && !hasType(n.TypeBitMap, dns.TypeSOA))) {
+		return false
+	}
+	if canonicalCompare(owner, next) < 0 {
+		return canonicalCompare(owner, name) < 0 && canonicalCompare(name, next) < 0
+	}
+	return dns.IsSubDomain(next, name) &&
+		(canonicalCompare(owner, name) < 0 || canonicalCompare(name, next) < 0)
+}
+
+// nsecEncloser gets closest encloser of name covered by n, the longest
+// ancestor shared with owner or next
+func nsecEncloser(n *dns.NSEC, name string) string {
+	common := dns.CompareDomainName(n.Hdr.Name, name)
+	if c := dns.CompareDomainName(n.NextDomain, name); c > common {
+		common = c
+	}
+	return suffix(name, common)
+}
+
+// nsec3Encloser finds closest encloser of name by an nsec3 matching it
+// and another covering the next closer name, optOut tells whether the
+// covering one is opt-out
+func nsec3Encloser(name string, nsec3 []*dns.NSEC3) (ce string, optOut bool, ok bool) {
+	labels := dns.CountLabel(name)
+	for i := labels - 1; i >= 0; i-- {
+		ce = suffix(name, i)
+		matched := false
+		for _, n := range nsec3 {
+			if n.Match(ce) {
+				// a delegation or dname is not an encloser
+				matched = !hasType(n.TypeBitMap, dns.TypeDNAME) &&
+					(!hasType(n.TypeBitMap, dns.TypeNS) || hasType(n.TypeBitMap, dns.TypeSOA))
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		next := suffix(name, i+1)
+		for _, n := range nsec3 {
+			if n.Cover(next) {
+				return ce, n.Flags&1 == 1, true
+			}
+		}
+		return "", false, false
+	}
+	return "", false, false
+}
+
+func nsec3Covered(nsec3 []*dns.NSEC3, name string) bool {
+	for _, n := range nsec3 {
+		if n.Cover(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalCompare orders names as RFC 4034 section 6.1, labels are
+// compared from the rightmost case insensitively
+func canonicalCompare(a, b string) int {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// suffix gets the rightmost n labels of name
+func suffix(name string, n int) string {
+	if n <= 0 {
+		return "."
+	}
+	idx := dns.Split(name)
+	if n >= len(idx) {
+		return name
+	}
+	return name[idx[len(idx)-n]:]
+}
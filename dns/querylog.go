@@ -0,0 +1,223 @@
+package dns
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intxff/rdcross/log"
+	"github.com/intxff/rdcross/util"
+	"github.com/intxff/rdcross/util/lru"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+// entries kept in memory by default
+const defaultQueryLogSize = 1000
+
+// clients counted at most, the least recently seen is dropped
+const maxClients = 1024
+
+type QueryLog struct {
+	Enable bool `yaml:"enable"`
+	// entries kept in memory
+	Size int `yaml:"size"`
+	// optional file queries are appended to as json lines
+	File string `yaml:"file"`
+}
+
+// QueryEntry is a query handled by dns server
+type QueryEntry struct {
+	Time     time.Time     `json:"time"`
+	Client   string        `json:"client"`
+	Name     string        `json:"name"`
+	Type     string        `json:"type"`
+	Rcode    string        `json:"rcode"`
+	Answer   []string      `json:"answer,omitempty"`
+	Upstream string        `json:"upstream,omitempty"`
+	Latency  time.Duration `json:"latency"`
+	// answer was served from cache, always false as answers of upstream
+	// are not cached
+	Cached  bool   `json:"cached"`
+	FakeIP  string `json:"fakeip,omitempty"`
+	Blocked string `json:"blocked,omitempty"`
+}
+
+// ClientStat counts queries of a client
+type ClientStat struct {
+	Queries uint64 `json:"queries"`
+	Failed  uint64 `json:"failed"`
+	Blocked uint64 `json:"blocked"`
+}
+
+type queryLog struct {
+	mu      sync.Mutex
+	entries []QueryEntry
+	next    int
+	full    bool
+	clients *lru.LRU
+	file    *os.File
+	enc     *json.Encoder
+}
+
+func newQueryLog(q *QueryLog) (*queryLog, error) {
+	size := q.Size
+	if size <= 0 {
+		size = defaultQueryLogSize
+	}
+	l := &queryLog{
+		entries: make([]QueryEntry, size),
+		clients: lru.New(maxClients),
+	}
+	if q.File != "" {
+		path, err := util.GetAbsPath(q.File)
+		if err != nil {
+			return nil, err
+		}
+		if l.file, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+			return nil, err
+		}
+		l.enc = json.NewEncoder(l.file)
+	}
+	return l, nil
+}
+
+func (l *queryLog) add(e *QueryEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries[l.next] = *e
+	if l.next++; l.next == len(l.entries) {
+		l.next, l.full = 0, true
+	}
+
+	var stat *ClientStat
+	if v, exist := l.clients.Get(e.Client); exist {
+		stat = v.(*ClientStat)
+	} else {
+		stat = &ClientStat{}
+		l.clients.Put(e.Client, stat)
+	}
+	stat.Queries++
+	if e.Rcode != dns.RcodeToString[dns.RcodeSuccess] {
+		stat.Failed++
+	}
+	if e.Blocked != "" {
+		stat.Blocked++
+	}
+
+	if l.enc != nil {
+		if err := l.enc.Encode(e); err != nil {
+			log.Error("[DNS] failed to write query log", zap.Error(err))
+		}
+	}
+}
+
+// snapshot gets entries from oldest to newest
+func (l *queryLog) snapshot() []QueryEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full {
+		return append([]QueryEntry(nil), l.entries[:l.next]...)
+	}
+	out := make([]QueryEntry, 0, len(l.entries))
+	out = append(out, l.entries[l.next:]...)
+	return append(out, l.entries[:l.next]...)
+}
+
+func (l *queryLog) stats() map[string]ClientStat {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make(map[string]ClientStat, l.clients.Len())
+	l.clients.Range(func(k, v interface{}) bool {
+		out[k.(string)] = *v.(*ClientStat)
+		return true
+	})
+	return out
+}
+
+var queries *queryLog
+
+// Queries gets recent queries from oldest to newest
+func Queries() []QueryEntry {
+	if queries == nil {
+		return nil
+	}
+	return queries.snapshot()
+}
+
+// ClientStats gets query counters of recently seen clients
+func ClientStats() map[string]ClientStat {
+	if queries == nil {
+		return nil
+	}
+	return queries.stats()
+}
+
+// queryLogDNS records every query passing to next
+type queryLogDNS struct {
+	log  *queryLog
+	next dns.Handler
+}
+
+func newQueryLogDNS(l *queryLog, next dns.Handler) *queryLogDNS {
+	queries = l
+	return &queryLogDNS{log: l, next: next}
+}
+
+func (h *queryLogDNS) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	q := r.Question[0]
+	e := &QueryEntry{
+		Time: time.Now(),
+		Name: q.Name,
+		Type: dns.TypeToString[q.Qtype],
+	}
+	if addr := w.RemoteAddr(); addr != nil {
+		e.Client = addr.String()
+		if host, _, err := net.SplitHostPort(e.Client); err == nil {
+			e.Client = host
+		}
+	}
+
+	lw := &logWriter{ResponseWriter: w, entry: e}
+	h.next.ServeDNS(lw, r)
+
+	e.Latency = time.Since(e.Time)
+	if lw.msg != nil {
+		e.Rcode = dns.RcodeToString[lw.msg.Rcode]
+		for _, rr := range lw.msg.Answer {
+			e.Answer = append(e.Answer, strings.TrimPrefix(rr.String(), rr.Header().String()))
+		}
+	}
+	h.log.add(e)
+}
+
+// logWriter keeps the answer and entry filled by handlers
+type logWriter struct {
+	dns.ResponseWriter
+	entry *QueryEntry
+	msg   *dns.Msg
+}
+
+func (l *logWriter) WriteMsg(m *dns.Msg) error {
+	l.msg = m
+	return l.ResponseWriter.WriteMsg(m)
+}
+
+// annotate fills entry of query if it is logged
+func annotate(w dns.ResponseWriter, f func(e *QueryEntry)) {
+	for {
+		switch v := w.(type) {
+		case *logWriter:
+			f(v.entry)
+			return
+		case *captureWriter:
+			w = v.ResponseWriter
+		default:
+			return
+		}
+	}
+}
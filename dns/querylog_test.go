@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestQueryLogClients(t *testing.T) {
+	l, err := newQueryLog(&QueryLog{Enable: true, Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxClients+10; i++ {
+		l.add(&QueryEntry{Client: fmt.Sprintf("10.0.%v.%v", i/256, i%256), Rcode: "NOERROR"})
+	}
+	stats := l.stats()
+	if len(stats) != maxClients {
+		t.Errorf("%v clients kept", len(stats))
+	}
+	if _, exist := stats["10.0.0.0"]; exist {
+		t.Error("least recently seen client kept")
+	}
+	if len(l.snapshot()) != 10 {
+		t.Errorf("%v entries kept", len(l.snapshot()))
+	}
+}
+
+// stubWriter keeps the answer of dns server
+type stubWriter struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (s *stubWriter) WriteMsg(m *dns.Msg) error {
+	s.msg = m
+	return nil
+}
+
+func (s *stubWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5353}
+}
+
+func TestQueryLogUpstream(t *testing.T) {
+	answer := func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg).SetReply(r)
+		rr, _ := dns.NewRR("example.com. 300 IN A 192.0.2.1")
+		m.Answer = append(m.Answer, rr)
+		w.WriteMsg(m)
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(answer)}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+	u, err := ParseUpstream(pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := &_Resolver{Upstream: []*Upstream{u}}
+	l, _ := newQueryLog(&QueryLog{Enable: true})
+	h := newQueryLogDNS(l, &defaultDNS{resolver: res})
+	for i := 0; i < 2; i++ {
+		w := &stubWriter{}
+		h.ServeDNS(w, new(dns.Msg).SetQuestion("example.com.", dns.TypeA))
+		if w.msg == nil || len(w.msg.Answer) != 1 {
+			t.Fatalf("answer %v", w.msg)
+		}
+	}
+	entries := l.snapshot()
+	if len(entries) != 2 {
+		t.Fatalf("entries %+v", entries)
+	}
+	// both are answered by upstream
+	for _, e := range entries {
+		if e.Cached || e.Upstream == "" || e.Client != "10.0.0.1" {
+			t.Errorf("entry %+v", e)
+		}
+	}
+}
@@ -3,75 +3,190 @@ package dns
 import (
 	"fmt"
 	"net"
+	"strings"
+	"time"
 
 	"github.com/miekg/dns"
 )
 
+// strategy of ResolveIP
+const (
+	StrategyIPv4Only = "ipv4-only"
+	StrategyIPv6Only = "ipv6-only"
+	StrategyPreferV4 = "prefer-v4"
+	StrategyPreferV6 = "prefer-v6"
+)
+
+// time waited for the other family after preferred one is resolved,
+// Resolution Delay of RFC 8305
+const resolutionDelay = 50 * time.Millisecond
+
+func parseStrategy(s string) (string, error) {
+	switch s = strings.ToLower(s); s {
+	case "":
+		return StrategyPreferV4, nil
+	case StrategyIPv4Only, StrategyIPv6Only, StrategyPreferV4, StrategyPreferV6:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid dns strategy %v", s)
+}
+
 type _Resolver struct {
-	Upstream []string
+	Upstream  []*Upstream
+	Fallback  []*Upstream
+	filter    *fallbackFilter
+	hosts     *hosts
+	strategy  string
+	validator *validator
 }
 
 var resolver = new(_Resolver)
 
-func ResolveIP(domain string) ([]net.IP, error) {
-    if ips, err := ResolveIPv4(domain); err == nil {
-        return ips, err
-    }
+// Exchange queries upstream. With fallback set, primary and fallback
+// upstreams are queried concurrently, answer of primary is taken only
+// if passing fallback filter, since poisoned one always comes first.
+func (r *_Resolver) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	rs, _, err := r.exchange(m)
+	return rs, err
+}
 
-    return ResolveIPv6(domain)
+// exchange is Exchange also telling which upstream answers
+func (r *_Resolver) exchange(m *dns.Msg) (*dns.Msg, *Upstream, error) {
+	if r.validator != nil {
+		return r.validator.exchange(m)
+	}
+	return r.query(m)
 }
 
-func ResolveIPv4(domain string) ([]net.IP, error) {
-	out := make([]net.IP, 0)
-	if len(resolver.Upstream) == 0 {
-		ips, err := net.LookupIP(domain)
-		if err != nil {
-			return nil, err
-		}
-		for _, v := range ips {
-			if v.To4() != nil {
-				out = append(out, v.To4())
-			}
-		}
+// query gets answer from upstream without validation
+func (r *_Resolver) query(m *dns.Msg) (*dns.Msg, *Upstream, error) {
+	if len(r.Fallback) == 0 {
+		return asyncQuery(m, r.Upstream)
 	}
-	msg := new(dns.Msg)
-	msg.SetQuestion(dns.Fqdn(domain), dns.TypeA)
-	r, err := asyncQuery(msg, resolver.Upstream)
-	if err != nil {
-		return nil, err
+
+	type response struct {
+		m *dns.Msg
+		u *Upstream
+		e error
 	}
-	for _, v := range r.Answer {
-		if value, ok := v.(*dns.A); ok {
-			out = append(out, value.A)
+	fallback := make(chan response, 1)
+	go func() {
+		rs, u, err := asyncQuery(m, r.Fallback)
+		fallback <- response{rs, u, err}
+	}()
+
+	if rs, u, err := asyncQuery(m, r.Upstream); err == nil && r.filter.accept(rs) {
+		return rs, u, nil
+	}
+	rs := <-fallback
+	return rs.m, rs.u, rs.e
+}
+
+// ResolveIP resolves A and AAAA of domain in parallel by strategy,
+// addresses of preferred family come first
+func ResolveIP(domain string) ([]net.IP, error) {
+	prefer, other := dns.TypeA, dns.TypeAAAA
+	switch resolver.strategy {
+	case StrategyIPv4Only:
+		return ResolveIPv4(domain)
+	case StrategyIPv6Only:
+		return ResolveIPv6(domain)
+	case StrategyPreferV6:
+		prefer, other = dns.TypeAAAA, dns.TypeA
+	}
+
+	type response struct {
+		ips []net.IP
+		e   error
+	}
+	chPrefer, chOther := make(chan response, 1), make(chan response, 1)
+	go func() {
+		ips, err := resolve(domain, prefer)
+		chPrefer <- response{ips, err}
+	}()
+	go func() {
+		ips, err := resolve(domain, other)
+		chOther <- response{ips, err}
+	}()
+
+	var rsPrefer, rsOther response
+	select {
+	case rsPrefer = <-chPrefer:
+		// wait a moment for the other if preferred is enough to go,
+		// otherwise the other is all there is
+		if len(rsPrefer.ips) == 0 {
+			rsOther = <-chOther
+			break
+		}
+		select {
+		case rsOther = <-chOther:
+		case <-time.After(resolutionDelay):
+			rsOther.e = errTimeout
 		}
+	case rsOther = <-chOther:
+		rsPrefer = <-chPrefer
 	}
-	if len(out) == 0 {
-		return nil, fmt.Errorf("can't resolve domain %v\n", domain)
+
+	ips := append(rsPrefer.ips, rsOther.ips...)
+	if len(ips) == 0 {
+		if rsPrefer.e != nil {
+			return nil, rsPrefer.e
+		}
+		return nil, rsOther.e
 	}
-	return out, nil
+	return ips, nil
+}
+
+func ResolveIPv4(domain string) ([]net.IP, error) {
+	return resolve(domain, dns.TypeA)
 }
 
 func ResolveIPv6(domain string) ([]net.IP, error) {
-	out := make([]net.IP, 0)
-	if len(resolver.Upstream) == 0 {
+	return resolve(domain, dns.TypeAAAA)
+}
+
+func resolve(domain string, qtype uint16) ([]net.IP, error) {
+	// hosts first, cname out of hosts is resolved by upstream
+	if rrs, target, exist := resolver.hosts.answer(dns.Fqdn(domain), qtype); exist {
+		if target == "" {
+			return extractIPs(domain, rrs)
+		}
+		domain = target
+	}
+
+	if len(resolver.Upstream) == 0 && len(resolver.Fallback) == 0 {
 		ips, err := net.LookupIP(domain)
 		if err != nil {
 			return nil, err
 		}
+		out := make([]net.IP, 0)
 		for _, v := range ips {
-			if v.To16() != nil {
+			if (v.To4() != nil) == (qtype == dns.TypeA) {
 				out = append(out, v)
 			}
 		}
+		if len(out) == 0 {
+			return nil, fmt.Errorf("can't resolve domain %v\n", domain)
+		}
+		return out, nil
 	}
+
 	msg := new(dns.Msg)
-	msg.SetQuestion(dns.Fqdn(domain), dns.TypeAAAA)
-	r, err := asyncQuery(msg, resolver.Upstream)
+	msg.SetQuestion(dns.Fqdn(domain), qtype)
+	r, err := resolver.Exchange(msg)
 	if err != nil {
 		return nil, err
 	}
-	for _, v := range r.Answer {
-		if value, ok := v.(*dns.AAAA); ok {
+	return extractIPs(domain, r.Answer)
+}
+
+func extractIPs(domain string, rrs []dns.RR) ([]net.IP, error) {
+	out := make([]net.IP, 0)
+	for _, v := range rrs {
+		switch value := v.(type) {
+		case *dns.A:
+			out = append(out, value.A)
+		case *dns.AAAA:
 			out = append(out, value.AAAA)
 		}
 	}
@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestResolveIP(t *testing.T) {
+	// answers of each family of a name, nil is NODATA
+	type family struct {
+		delay time.Duration
+		ip    string
+		fail  bool
+	}
+	names := map[string]map[uint16]family{
+		"both.test.":    {dns.TypeA: {ip: "192.0.2.1"}, dns.TypeAAAA: {ip: "2001:db8::1"}},
+		"v6only.test.":  {dns.TypeAAAA: {delay: 200 * time.Millisecond, ip: "2001:db8::1"}},
+		"v4fail.test.":  {dns.TypeA: {fail: true}, dns.TypeAAAA: {delay: 200 * time.Millisecond, ip: "2001:db8::1"}},
+		"slowv6.test.":  {dns.TypeA: {ip: "192.0.2.1"}, dns.TypeAAAA: {delay: 500 * time.Millisecond, ip: "2001:db8::1"}},
+		"slowv4.test.":  {dns.TypeA: {delay: 100 * time.Millisecond, ip: "192.0.2.1"}, dns.TypeAAAA: {ip: "2001:db8::1"}},
+		"nothing.test.": {},
+	}
+	answer := func(w dns.ResponseWriter, r *dns.Msg) {
+		q := r.Question[0]
+		f := names[q.Name][q.Qtype]
+		time.Sleep(f.delay)
+		m := new(dns.Msg).SetReply(r)
+		if f.fail {
+			m.Rcode = dns.RcodeServerFailure
+		} else if f.ip != "" {
+			rr, _ := dns.NewRR(q.Name + " 60 IN " + dns.TypeToString[q.Qtype] + " " + f.ip)
+			m.Answer = append(m.Answer, rr)
+		}
+		w.WriteMsg(m)
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(answer)}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+	u, err := ParseUpstream(pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := *resolver
+	defer func() { *resolver = saved }()
+	resolver.Upstream = []*Upstream{u}
+
+	cases := []struct {
+		strategy string
+		name     string
+		want     []string
+	}{
+		{StrategyPreferV4, "both.test", []string{"192.0.2.1", "2001:db8::1"}},
+		{StrategyPreferV6, "both.test", []string{"2001:db8::1", "192.0.2.1"}},
+		{StrategyIPv4Only, "both.test", []string{"192.0.2.1"}},
+		{StrategyIPv6Only, "both.test", []string{"2001:db8::1"}},
+		// preferred family empty or failed, the other is waited for
+		{StrategyPreferV4, "v6only.test", []string{"2001:db8::1"}},
+		{StrategyPreferV4, "v4fail.test", []string{"2001:db8::1"}},
+		// the other is cut by resolution delay
+		{StrategyPreferV4, "slowv6.test", []string{"192.0.2.1"}},
+		// preferred is waited for and still first
+		{StrategyPreferV4, "slowv4.test", []string{"192.0.2.1", "2001:db8::1"}},
+		{StrategyPreferV4, "nothing.test", nil},
+	}
+	for _, c := range cases {
+		resolver.strategy = c.strategy
+		ips, err := ResolveIP(c.name)
+		if c.want == nil {
+			if err == nil {
+				t.Errorf("%v %v: resolved %v", c.strategy, c.name, ips)
+			}
+			continue
+		}
+		if err != nil || len(ips) != len(c.want) {
+			t.Errorf("%v %v: %v %v", c.strategy, c.name, ips, err)
+			continue
+		}
+		for i, ip := range ips {
+			if ip.String() != c.want[i] {
+				t.Errorf("%v %v: %v, want %v", c.strategy, c.name, ips, c.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intxff/rdcross/component/iface"
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/egress"
+	"github.com/miekg/dns"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	directTimeout = 1 * time.Second
+	tunnelTimeout = 3 * time.Second
+)
+
+var (
+	errTimeout       = errors.New("dns query timeout")
+	errSessionClosed = errors.New("udp session of upstream closed")
+	errSessionFull   = errors.New("no free id in udp session of upstream")
+)
+
+// Upstream is the dns server queries are forwarded to. It is written
+// as [udp://|tcp://]ip:port[#egress] in config, or as a map with addr
+// and ecs. Queries go through egress when it is set, otherwise
+// directly from physical interface.
+type Upstream struct {
+	Net    string
+	IP     net.IP
+	Port   int
+	Egress string
+
+	// EDNS Client Subnet sent to upstream
+	ecs *ecs
+
+	out     egress.Egress
+	muSess  sync.Mutex
+	session *packetSession
+}
+
+func ParseUpstream(s string) (*Upstream, error) {
+	u := &Upstream{Net: "udp"}
+	if i := strings.LastIndex(s, "#"); i >= 0 {
+		u.Egress = s[i+1:]
+		s = s[:i]
+		if u.Egress == "" {
+			return nil, fmt.Errorf("empty egress in upstream %v", s)
+		}
+	}
+	switch {
+	case strings.HasPrefix(s, "tcp://"):
+		u.Net, s = "tcp", strings.TrimPrefix(s, "tcp://")
+	case strings.HasPrefix(s, "udp://"):
+		s = strings.TrimPrefix(s, "udp://")
+	}
+
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.IP = net.ParseIP(host); u.IP == nil {
+		return nil, fmt.Errorf("invalid ip %v in upstream", host)
+	}
+	if u.Port, err = strconv.Atoi(port); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (u *Upstream) UnmarshalYAML(value *yaml.Node) error {
+	var s, subnet string
+	if value.Kind == yaml.MappingNode {
+		temp := struct {
+			Addr string `yaml:"addr"`
+			ECS  string `yaml:"ecs"`
+		}{}
+		if err := value.Decode(&temp); err != nil {
+			return err
+		}
+		s, subnet = temp.Addr, temp.ECS
+	} else if err := value.Decode(&s); err != nil {
+		return err
+	}
+	t, err := ParseUpstream(s)
+	if err != nil {
+		return err
+	}
+	if t.ecs, err = parseECS(subnet); err != nil {
+		return err
+	}
+	u.Net, u.IP, u.Port, u.Egress, u.ecs = t.Net, t.IP, t.Port, t.Egress, t.ecs
+	return nil
+}
+
+func (u *Upstream) String() string {
+	s := fmt.Sprintf("%v://%v", u.Net, net.JoinHostPort(u.IP.String(), strconv.Itoa(u.Port)))
+	if u.Egress != "" {
+		s += "#" + u.Egress
+	}
+	return s
+}
+
+// bind finds egress the upstream goes through
+func (u *Upstream) bind(e map[string]egress.Egress) error {
+	if u.Egress == "" {
+		return nil
+	}
+	out, exist := e[u.Egress]
+	if !exist {
+		return fmt.Errorf("egress %v of upstream %v not exist", u.Egress, u)
+	}
+	u.out = out
+	return nil
+}
+
+func (u *Upstream) metadata() *message.Metadata {
+	return message.NewMetadata().
+		WithRemoteIP(u.IP).
+		WithRemotePort(u.Port)
+}
+
+// Exchange sends m to upstream and waits for the answer
+func (u *Upstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	r, err := u.exchange(u.ecs.query(m))
+	u.ecs.answer(r)
+	return r, err
+}
+
+func (u *Upstream) exchange(m *dns.Msg) (*dns.Msg, error) {
+	if u.out == nil {
+		return u.exchangeDirect(m)
+	}
+	// egress without udp transport can only carry dns over tcp
+	_, packet := u.out.Transport()
+	if u.Net == "tcp" || (u.out.Type() == egress.TypeGeneral && packet == nil) {
+		return u.exchangeStream(m)
+	}
+	return u.exchangePacket(m)
+}
+
+func (u *Upstream) exchangeDirect(m *dns.Msg) (*dns.Msg, error) {
+	var (
+		lIP net.IP
+		c   net.Conn
+		err error
+	)
+	// bind to avoid route decision
+	if u.IP.To4() != nil {
+		lIP, err = iface.GetIPv4()
+	} else {
+		lIP, err = iface.GetIPv6()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if u.Net == "tcp" {
+		c, err = net.DialTCP("tcp", &net.TCPAddr{IP: lIP},
+			&net.TCPAddr{IP: u.IP, Port: u.Port})
+	} else {
+		c, err = net.DialUDP("udp", &net.UDPAddr{IP: lIP},
+			&net.UDPAddr{IP: u.IP, Port: u.Port})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return exchangeConn(c, m, directTimeout)
+}
+
+// exchangeStream sends dns over tcp through egress
+func (u *Upstream) exchangeStream(m *dns.Msg) (*dns.Msg, error) {
+	c := egress.DialStream(u.out, u.metadata())
+	return exchangeConn(c, m, tunnelTimeout)
+}
+
+// exchangeConn writes m into c and reads answer back, c is closed after
+func exchangeConn(c net.Conn, m *dns.Msg, timeout time.Duration) (*dns.Msg, error) {
+	defer c.Close()
+	dnsConn := &dns.Conn{Conn: c}
+	dnsConn.SetWriteDeadline(time.Now().Add(timeout))
+	if err := dnsConn.WriteMsg(m); err != nil {
+		return nil, err
+	}
+	dnsConn.SetReadDeadline(time.Now().Add(timeout))
+	return dnsConn.ReadMsg()
+}
+
+// exchangePacket sends dns over udp through egress. All queries to one
+// upstream share a udp session of egress, answers are matched by id.
+// A new session is created once the old one ends.
+func (u *Upstream) exchangePacket(m *dns.Msg) (*dns.Msg, error) {
+	u.muSess.Lock()
+	if u.session == nil {
+		u.session = newPacketSession(egress.DialPacket(u.out, u.metadata()), u.dropSession)
+	}
+	s := u.session
+	u.muSess.Unlock()
+	return s.exchange(m, tunnelTimeout)
+}
+
+// dropSession forgets s if it is still the session in use
+func (u *Upstream) dropSession(s *packetSession) {
+	u.muSess.Lock()
+	if u.session == s {
+		u.session = nil
+	}
+	u.muSess.Unlock()
+}
+
+type packetSession struct {
+	c       net.Conn
+	mu      sync.Mutex
+	closed  bool
+	nextID  uint16
+	pending map[uint16]chan *dns.Msg
+	// called once read loop ends
+	onClose func(*packetSession)
+}
+
+func newPacketSession(c net.Conn, onClose func(*packetSession)) *packetSession {
+	s := &packetSession{
+		c:       c,
+		pending: make(map[uint16]chan *dns.Msg),
+		onClose: onClose,
+	}
+	go s.loop()
+	return s
+}
+
+func (s *packetSession) loop() {
+	defer s.close()
+	buf := make([]byte, dns.MaxMsgSize)
+	for {
+		n, err := s.c.Read(buf)
+		if err != nil {
+			return
+		}
+		r := new(dns.Msg)
+		if err := r.Unpack(buf[:n]); err != nil {
+			continue
+		}
+		s.mu.Lock()
+		if ch, exist := s.pending[r.Id]; exist {
+			delete(s.pending, r.Id)
+			ch <- r
+		}
+		s.mu.Unlock()
+	}
+}
+
+// close fails pending queries at once and detaches session from upstream
+func (s *packetSession) close() {
+	s.mu.Lock()
+	s.closed = true
+	for id, ch := range s.pending {
+		close(ch)
+		delete(s.pending, id)
+	}
+	s.mu.Unlock()
+	s.c.Close()
+	if s.onClose != nil {
+		s.onClose(s)
+	}
+}
+
+// newID gets id not in flight, it must be called with lock held
+func (s *packetSession) newID() (uint16, error) {
+	for i := 0; i <= 0xffff; i++ {
+		s.nextID++
+		if _, exist := s.pending[s.nextID]; !exist {
+			return s.nextID, nil
+		}
+	}
+	return 0, errSessionFull
+}
+
+func (s *packetSession) exchange(m *dns.Msg, timeout time.Duration) (*dns.Msg, error) {
+	// rewrite id to avoid conflict between queries of different clients
+	q := m.Copy()
+	ch := make(chan *dns.Msg, 1)
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, errSessionClosed
+	}
+	id, err := s.newID()
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	q.Id = id
+	s.pending[id] = ch
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		// id may be taken by another query once answered
+		if s.pending[id] == ch {
+			delete(s.pending, id)
+		}
+		s.mu.Unlock()
+	}()
+
+	b, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.c.Write(b); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r, ok := <-ch:
+		if !ok {
+			return nil, errSessionClosed
+		}
+		r.Id = m.Id
+		return r, nil
+	case <-timer.C:
+		return nil, errTimeout
+	}
+}
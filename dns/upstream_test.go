@@ -0,0 +1,59 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestPacketSession(t *testing.T) {
+	c, peer := net.Pipe()
+	u := &Upstream{}
+	u.session = newPacketSession(c, u.dropSession)
+	s := u.session
+
+	// answer the first query, then end the session
+	go func() {
+		buf := make([]byte, dns.MaxMsgSize)
+		n, err := peer.Read(buf)
+		if err != nil {
+			return
+		}
+		q := new(dns.Msg)
+		q.Unpack(buf[:n])
+		r := new(dns.Msg).SetReply(q)
+		b, _ := r.Pack()
+		peer.Write(b)
+		peer.Read(buf)
+		peer.Close()
+	}()
+
+	m := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	m.Id = 1234
+	r, err := s.exchange(m, time.Second)
+	if err != nil || r.Id != 1234 {
+		t.Fatalf("answer %v %v", r, err)
+	}
+
+	start := time.Now()
+	if _, err := s.exchange(m, 5*time.Second); err != errSessionClosed {
+		t.Errorf("query on dead session: %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("query on dead session waited for timeout")
+	}
+	u.muSess.Lock()
+	if u.session != nil {
+		t.Error("dead session is kept")
+	}
+	u.muSess.Unlock()
+
+	// ids in flight are skipped after counter wraps
+	s = &packetSession{pending: map[uint16]chan *dns.Msg{0: nil, 1: nil}}
+	s.nextID = 0xffff
+	if id, _ := s.newID(); id != 2 {
+		t.Errorf("id %v in flight", id)
+	}
+}
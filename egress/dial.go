@@ -0,0 +1,195 @@
+package egress
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/intxff/rdcross/component/conn"
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/nat"
+)
+
+var errPipeClosed = errors.New("pipe closed")
+
+// DialStream gets a connection to remote in m through egress e.
+// Egress copies data between the other end of pipe and remote.
+func DialStream(e Egress, m *message.Metadata) net.Conn {
+	local, remote := net.Pipe()
+	go func() {
+		e.ProcessStream(&pipeStream{Conn: remote, metadata: m}, nil)
+		remote.Close()
+	}()
+	return local
+}
+
+type pipeStream struct {
+	net.Conn
+	metadata *message.Metadata
+}
+
+func (p *pipeStream) Metadata() *message.Metadata {
+	return p.metadata
+}
+
+func (p *pipeStream) ReadMux() (message.Message, error) {
+	return nil, errors.New("not supported")
+}
+
+func (p *pipeStream) WriteMux(msg message.Message) error {
+	return errors.New("not supported")
+}
+
+type pipeMsg struct {
+	payload  []byte
+	metadata *message.Metadata
+}
+
+func (p *pipeMsg) Metadata() *message.Metadata {
+	return p.metadata
+}
+
+func (p *pipeMsg) Others() any {
+	return nil
+}
+
+func (p *pipeMsg) Payload() []byte {
+	return p.payload
+}
+
+// pipe client takes a fake address which never appears in real
+// traffic, so that nat entries created by egress will not conflict
+var pipePort atomic.Uint32
+
+// DialPacket gets a connected packet connection to remote in m through
+// egress e. Every Write sends a packet and every Read receives one.
+func DialPacket(e Egress, m *message.Metadata) net.Conn {
+	port := int(pipePort.Add(1)%0xffff) + 1
+	p := &packetPipe{
+		e:        e,
+		metadata: *m,
+		cAddr:    &net.UDPAddr{IP: net.IPv4zero, Port: port},
+		replies:  make(chan []byte, 64),
+		closed:   make(chan struct{}),
+	}
+	p.metadata.WithClientIP(p.cAddr.IP).WithClientPort(port)
+	return p
+}
+
+// packetPipe is the client connection given to Egress.ProcessPacket,
+// packets written back by egress are queued for Read
+type packetPipe struct {
+	e        Egress
+	metadata message.Metadata
+	cAddr    *net.UDPAddr
+	replies  chan []byte
+	closed   chan struct{}
+	once     sync.Once
+	deadline atomic.Value
+}
+
+var _ conn.ProxyPacketConn = (*packetPipe)(nil)
+
+func (p *packetPipe) Read(b []byte) (int, error) {
+	var timeout <-chan time.Time
+	if d, ok := p.deadline.Load().(time.Time); ok && !d.IsZero() {
+		timer := time.NewTimer(time.Until(d))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case r := <-p.replies:
+		return copy(b, r), nil
+	case <-p.closed:
+		return 0, errPipeClosed
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+func (p *packetPipe) Write(b []byte) (int, error) {
+	select {
+	case <-p.closed:
+		return 0, errPipeClosed
+	default:
+	}
+
+	m := p.metadata
+	msg := &pipeMsg{payload: append([]byte(nil), b...), metadata: &m}
+	// reuse udp session created by egress if exists
+	if lc, exist := nat.New().Get(p.cAddr.String()); exist {
+		switch rc := lc.PacketConn.(type) {
+		case conn.ProxyPacketConn:
+			if err := rc.WriteMsgTo(msg, lc.Addr); err != nil {
+				return 0, err
+			}
+			return len(b), nil
+		case *net.UDPConn:
+			return rc.WriteTo(b, lc.Addr)
+		}
+	}
+	p.e.ProcessPacket(p, msg)
+	return len(b), nil
+}
+
+func (p *packetPipe) Close() error {
+	p.once.Do(func() {
+		close(p.closed)
+	})
+	return nil
+}
+
+func (p *packetPipe) LocalAddr() net.Addr {
+	return p.cAddr
+}
+
+func (p *packetPipe) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: p.metadata.RemoteIP, Port: p.metadata.RemotePort}
+}
+
+func (p *packetPipe) SetDeadline(t time.Time) error {
+	return p.SetReadDeadline(t)
+}
+
+func (p *packetPipe) SetReadDeadline(t time.Time) error {
+	p.deadline.Store(t)
+	return nil
+}
+
+func (p *packetPipe) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (p *packetPipe) Metadata() *message.Metadata {
+	return &p.metadata
+}
+
+func (p *packetPipe) ReadFrom(b []byte) (int, net.Addr, error) {
+	return 0, nil, errors.New("not supported")
+}
+
+func (p *packetPipe) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if err := p.WriteMsgTo(&pipeMsg{payload: b}, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (p *packetPipe) ReadMsgFrom() (message.Message, net.Addr, error) {
+	return nil, nil, errors.New("not supported")
+}
+
+// WriteMsgTo is called by egress to send packets from remote back
+func (p *packetPipe) WriteMsgTo(msg message.Message, addr net.Addr) error {
+	select {
+	case <-p.closed:
+		return errPipeClosed
+	case p.replies <- append([]byte(nil), msg.Payload()...):
+	default:
+		// drop like a full socket buffer
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package direct
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/intxff/rdcross/component/iface"
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/dns"
+)
+
+// Connection Attempt Delay of RFC 8305
+const attemptDelay = 250 * time.Millisecond
+
+var errNoAddress = errors.New("no address of remote")
+
+// remoteIPs gets addresses of remote, domain is resolved in both
+// families with preferred family first
+func remoteIPs(m *message.Metadata) ([]net.IP, error) {
+	if m.Domain != "" {
+		return dns.ResolveIP(m.Domain)
+	}
+	if m.RemoteIP == nil {
+		return nil, errNoAddress
+	}
+	return []net.IP{m.RemoteIP}, nil
+}
+
+// localIP gets address of physical interface in family of ip
+func localIP(ip net.IP) (net.IP, error) {
+	if ip.To4() != nil {
+		return iface.GetIPv4()
+	}
+	return iface.GetIPv6()
+}
+
+// interleave alternates families of ips, starting with family of the
+// first one
+func interleave(ips []net.IP) []net.IP {
+	if len(ips) == 0 {
+		return ips
+	}
+	var first, second []net.IP
+	v4 := ips[0].To4() != nil
+	for _, ip := range ips {
+		if (ip.To4() != nil) == v4 {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+	out := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
+
+// dialTCP connects to port of ips from physical interfaces, see race
+func dialTCP(ips []net.IP, port int) (*net.TCPConn, error) {
+	c, err := race(ips, func(ctx context.Context, ip net.IP) (net.Conn, error) {
+		lIP, err := localIP(ip)
+		if err != nil {
+			return nil, err
+		}
+		d := net.Dialer{LocalAddr: &net.TCPAddr{IP: lIP}}
+		return d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.(*net.TCPConn), nil
+}
+
+// race races connections to ips as Happy Eyeballs of RFC 8305. A new
+// attempt starts when the last one fails or is pending for attemptDelay,
+// the first established connection wins and the others are canceled.
+func race(ips []net.IP, dial func(ctx context.Context, ip net.IP) (net.Conn, error)) (net.Conn, error) {
+	if len(ips) == 0 {
+		return nil, errNoAddress
+	}
+	ips = interleave(ips)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type result struct {
+		c net.Conn
+		e error
+	}
+	results := make(chan result, len(ips))
+	attempt := func(ip net.IP) {
+		c, err := dial(ctx, ip)
+		results <- result{c, err}
+	}
+
+	var (
+		next    int
+		running int
+		delay   <-chan time.Time
+		lastErr error
+	)
+	start := func() {
+		go attempt(ips[next])
+		next++
+		running++
+		delay = nil
+		if next < len(ips) {
+			delay = time.After(attemptDelay)
+		}
+	}
+
+	start()
+	for running > 0 {
+		select {
+		case <-delay:
+			start()
+		case rs := <-results:
+			running--
+			if rs.e == nil {
+				// losers established later are closed
+				go func(n int) {
+					for ; n > 0; n-- {
+						if rs := <-results; rs.c != nil {
+							rs.c.Close()
+						}
+					}
+				}(running)
+				return rs.c, nil
+			}
+			lastErr = rs.e
+			if next < len(ips) {
+				start()
+			}
+		}
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,106 @@
+package direct
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestInterleave(t *testing.T) {
+	v4a, v4b := net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 2)
+	v6a, v6b := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+	cases := []struct {
+		in, want []net.IP
+	}{
+		{nil, nil},
+		{[]net.IP{v4a, v4b, v6a, v6b}, []net.IP{v4a, v6a, v4b, v6b}},
+		{[]net.IP{v6a, v6b, v4a}, []net.IP{v6a, v4a, v6b}},
+		{[]net.IP{v4a, v4b}, []net.IP{v4a, v4b}},
+	}
+	for _, c := range cases {
+		got := interleave(c.in)
+		if len(got) != len(c.want) {
+			t.Errorf("%v: %v", c.in, got)
+			continue
+		}
+		for i := range got {
+			if !got[i].Equal(c.want[i]) {
+				t.Errorf("%v: %v, want %v", c.in, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestRace(t *testing.T) {
+	v4, v6 := net.IPv4(192, 0, 2, 1), net.ParseIP("2001:db8::1")
+	errRefused := errors.New("refused")
+	stall := func(ctx context.Context) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	cases := []struct {
+		name string
+		// behaviours of v4 and v6
+		v4, v6 string
+		win    net.IP
+		// least and most time taken
+		min, max time.Duration
+	}{
+		{"first wins", "ok", "ok", v4, 0, attemptDelay},
+		{"failed first", "fail", "ok", v6, 0, attemptDelay},
+		{"stalled first", "stall", "ok", v6, attemptDelay, 2 * attemptDelay},
+		{"all failed", "fail", "fail", nil, 0, attemptDelay},
+	}
+	for _, c := range cases {
+		canceled := make(chan struct{}, 2)
+		dial := func(ctx context.Context, ip net.IP) (net.Conn, error) {
+			how := c.v4
+			if ip.To4() == nil {
+				how = c.v6
+			}
+			switch how {
+			case "ok":
+				a, b := net.Pipe()
+				b.Close()
+				return &addrConn{Conn: a, ip: ip}, nil
+			case "stall":
+				defer func() { canceled <- struct{}{} }()
+				return stall(ctx)
+			}
+			return nil, errRefused
+		}
+		start := time.Now()
+		conn, err := race([]net.IP{v4, v6}, dial)
+		took := time.Since(start)
+		if took < c.min || took > c.max {
+			t.Errorf("%v: took %v", c.name, took)
+		}
+		if c.win == nil {
+			if err != errRefused {
+				t.Errorf("%v: %v", c.name, err)
+			}
+			continue
+		}
+		if err != nil || !conn.(*addrConn).ip.Equal(c.win) {
+			t.Errorf("%v: %v %v", c.name, conn, err)
+			continue
+		}
+		conn.Close()
+		if c.v4 == "stall" {
+			select {
+			case <-canceled:
+			case <-time.After(time.Second):
+				t.Errorf("%v: stalled attempt not canceled", c.name)
+			}
+		}
+	}
+}
+
+// addrConn tells which address is connected
+type addrConn struct {
+	net.Conn
+	ip net.IP
+}
@@ -11,12 +11,10 @@ import (
 	"time"
 
 	"github.com/intxff/rdcross/component/conn"
-	"github.com/intxff/rdcross/component/iface"
 	"github.com/intxff/rdcross/component/message"
 	"github.com/intxff/rdcross/component/nat"
 	"github.com/intxff/rdcross/component/proxy"
 	"github.com/intxff/rdcross/component/transport"
-	"github.com/intxff/rdcross/dns"
 	"github.com/intxff/rdcross/egress"
 	"github.com/intxff/rdcross/log"
 	"go.uber.org/zap"
@@ -79,35 +77,22 @@ func (d *Direct) logString(s string) string {
 	return fmt.Sprintf("[Direct]: %v", s)
 }
 
+// ProcessStream relays c to remote, msg is always nil since no proxy
+// muxes messages in a connection
 func (d *Direct) ProcessStream(c conn.ProxyStreamConn, msg message.Message) {
-	if msg != nil {
-		d.processMsgStream(c, msg)
-	} else {
-		d.processStream(c)
-	}
+	d.processStream(c)
 }
 
 func (d *Direct) processStream(c conn.ProxyStreamConn) {
 	// dial remote to get remote connection
-	rAddr := &net.TCPAddr{Port: c.Metadata().RemotePort}
 	m := c.Metadata()
-	if m.RemoteIP != nil {
-		rAddr.IP = m.RemoteIP
-	}
-	if m.Domain != "" {
-		ips, err := dns.ResolveIPv4(m.Domain)
-		if err != nil {
-			log.Error(d.logString("failed to resolve domain"),
-				zap.Error(err))
-			return
-		}
-		rAddr.IP = ips[0]
+	ips, err := remoteIPs(m)
+	if err != nil {
+		log.Error(d.logString("failed to resolve domain"),
+			zap.Error(err))
+		return
 	}
-    lIP, err := iface.GetIPv4()
-    if err != nil {
-        return
-    }
-	rc, err := net.DialTCP("tcp", &net.TCPAddr{IP: lIP, Port: 0}, rAddr)
+	rc, err := dialTCP(ips, m.RemotePort)
 	if err != nil {
 		log.Error("failed to dial remote",
 			zap.Error(err))
@@ -153,32 +138,6 @@ func (d *Direct) processStream(c conn.ProxyStreamConn) {
 	}
 }
 
-func (d *Direct) processMsgStream(c conn.ProxyStreamConn, msg message.Message) {
-	/* // dial remote to get remote connection
-	remoteStream, _ := g.Transport()
-	rc, err := remoteStream.DialStream()
-	if err != nil {
-		log.Error("failed to dial remote", zap.Error(err))
-	}
-	defer rc.Close()
-
-	// send msg to remote, only do once
-	_, err = rc.Write(msg.Payload())
-	if err != nil {
-		log.Error("Error when writing message to remote", zap.Error(err))
-		return
-	}
-
-	// take response from remote then send to client
-	_, err = io.Copy(c, rc)
-	if err != nil {
-		if err != io.EOF {
-			log.Error("Error when copying remote response to client", zap.Error(err))
-			return
-		}
-	} */
-}
-
 func (d *Direct) ProcessPacket(c conn.ProxyPacketConn, msg message.Message) {
 	buf := make([]byte, 10*1024)
 	m := msg.Metadata()
@@ -186,26 +145,21 @@ func (d *Direct) ProcessPacket(c conn.ProxyPacketConn, msg message.Message) {
 	// gnat
 	gnat := nat.New()
 
-	// get target address
-	rAddr := &net.UDPAddr{Port: m.RemotePort}
-	if m.RemoteIP != nil {
-		rAddr.IP = m.RemoteIP
-	}
-	if m.Domain != "" {
-		ips, err := dns.ResolveIPv4(m.Domain)
-		if err != nil {
-			log.Error(d.logString("failed to resolve domain"),
-				zap.Error(err))
-			return
-		}
-		rAddr.IP = ips[0]
+	// get target address, udp takes the preferred one
+	ips, err := remoteIPs(m)
+	if err != nil {
+		log.Error(d.logString("failed to resolve domain"),
+			zap.Error(err))
+		return
 	}
+	rAddr := &net.UDPAddr{IP: ips[0], Port: m.RemotePort}
 
 	// listener to do nat map
-    lIP, err := iface.GetIPv4()
-    if err != nil {
-        return
-    }
+	lIP, err := localIP(rAddr.IP)
+	if err != nil {
+		log.Error(d.logString("no interface"), zap.Error(err))
+		return
+	}
 	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: lIP, Port: 0})
 	if err != nil {
 		log.Error(d.logString("failed to listen udp"), zap.Error(err))
@@ -214,7 +168,7 @@ func (d *Direct) ProcessPacket(c conn.ProxyPacketConn, msg message.Message) {
 
 	// add to nat then copy remote response to client connection
 	cAddr := net.UDPAddr{IP: msg.Metadata().ClientIP, Port: msg.Metadata().ClientPort}
-	gnat.Set(cAddr.String(), nat.LinkPacketConn{PacketConn: l, Addr: l.LocalAddr()})
+	gnat.Set(cAddr.String(), nat.LinkPacketConn{PacketConn: l, Addr: rAddr})
 
 	go func() {
 		defer func() {
@@ -1,10 +1,12 @@
 package general
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	nethttp "net/http"
 	"os"
 	"strings"
 	"sync"
@@ -17,10 +19,15 @@ import (
 	"github.com/intxff/rdcross/component/message"
 	"github.com/intxff/rdcross/component/nat"
 	"github.com/intxff/rdcross/component/proxy"
+	"github.com/intxff/rdcross/component/proxy/http"
 	"github.com/intxff/rdcross/component/proxy/none"
 	"github.com/intxff/rdcross/component/proxy/shadowsocks"
 	"github.com/intxff/rdcross/component/proxy/socks"
+	"github.com/intxff/rdcross/component/proxy/trojan"
+	"github.com/intxff/rdcross/component/proxy/vless"
+	"github.com/intxff/rdcross/component/proxy/vmess"
 	"github.com/intxff/rdcross/component/transport"
+	"github.com/intxff/rdcross/component/websocket"
 	"github.com/intxff/rdcross/egress"
 	"github.com/intxff/rdcross/log"
 	"github.com/intxff/rdcross/util"
@@ -33,6 +40,9 @@ type General struct {
 	tranStream transport.Transport
 	tranPacket transport.Transport
 	proxy      proxy.Proxy
+	// transport connections are tunneled through dialer if set
+	dialerName string
+	dialer     egress.Egress
 	conns      sync.Map
 	status     atomic.Int32
 }
@@ -49,6 +59,42 @@ func NewGeneral(name string, p proxy.Proxy, stream, packet transport.Transport)
 	return g
 }
 
+// WithDialer tunnels transport connections through egress e
+func (g *General) WithDialer(e egress.Egress) *General {
+	g.dialer = e
+	if e != nil {
+		g.dialerName = e.Name()
+	}
+	g.chain()
+	return g
+}
+
+// BindDialer finds dialer by name in e, chains looping back are refused
+func (g *General) BindDialer(e map[string]egress.Egress) error {
+	if g.dialerName == "" {
+		return nil
+	}
+	seen := map[string]bool{g.name: true}
+	for name := g.dialerName; name != ""; {
+		if seen[name] {
+			return fmt.Errorf("dialer of egress %v loops at %v", g.name, name)
+		}
+		seen[name] = true
+		d, exist := e[name]
+		if !exist {
+			return fmt.Errorf("dialer %v of egress %v not exist", name, g.name)
+		}
+		next, ok := d.(*General)
+		if !ok {
+			break
+		}
+		name = next.dialerName
+	}
+	g.dialer = e[g.dialerName]
+	g.chain()
+	return nil
+}
+
 func (g *General) Type() egress.EgressType {
 	return egress.TypeGeneral
 }
@@ -84,24 +130,24 @@ func (g *General) Close() <-chan struct{} {
 		value.(io.Closer).Close()
 		return true
 	})
+	if c, ok := g.tranPacket.(io.Closer); ok {
+		c.Close()
+	}
 
 	ch <- struct{}{}
 	return ch
 }
 
+// ProcessStream relays c to remote, msg is always nil since no proxy
+// muxes messages in a connection
 func (g *General) ProcessStream(c conn.ProxyStreamConn, msg message.Message) {
 	g.status.Store(egress.Running)
-	if msg != nil {
-		g.processMsgStream(c, msg)
-	} else {
-		g.processStream(c)
-	}
+	g.processStream(c)
 }
 
 func (g *General) processStream(c conn.ProxyStreamConn) {
 	// dial remote to get remote connection
-	remoteStream, _ := g.Transport()
-	rc, err := remoteStream.DialStream()
+	rc, err := g.dialStream()
 	if err != nil {
 		log.Error(g.logString("failed to dial remote"),
 			zap.Error(err))
@@ -111,13 +157,13 @@ func (g *General) processStream(c conn.ProxyStreamConn) {
 	remoteAddr := rc.RemoteAddr().String()
 	localAddr := rc.LocalAddr().String()
 
-	g.conns.Store(rc.LocalAddr().String(), rc)
+	g.conns.Store(rc, rc)
 	log.Info(g.logString("connected to remote"),
 		zap.String("local", localAddr),
 		zap.String("remote", remoteAddr))
 
 	defer func() {
-		g.conns.Delete(rc.LocalAddr().String())
+		g.conns.Delete(rc)
 		rc.Close()
 		log.Info(g.logString("connect closed"),
 			zap.String("local", localAddr),
@@ -163,66 +209,21 @@ func (g *General) processStream(c conn.ProxyStreamConn) {
 	}
 }
 
-func (g *General) processMsgStream(c conn.ProxyStreamConn, msg message.Message) {
-	/* // dial remote to get remote connection
-	remoteStream, _ := g.Transport()
-	rc, err := remoteStream.DialStream()
-	if err != nil {
-		log.Error("failed to dial remote", zap.Error(err))
-	}
-	defer rc.Close()
-
-	// send msg to remote, only do once
-	_, err = rc.Write(msg.Payload())
-	if err != nil {
-		log.Error("Error when writing message to remote", zap.Error(err))
-		return
-	}
-
-	// take response from remote then send to client
-	_, err = io.Copy(c, rc)
-	if err != nil {
-		if err != io.EOF {
-			log.Error("Error when copying remote response to client", zap.Error(err))
-			return
-		}
-	} */
-}
-
 func (g *General) ProcessPacket(c conn.ProxyPacketConn, msg message.Message) {
 	g.status.Store(egress.Running)
 	// gnat
 	gnat := nat.New()
 
-	// get target address
-	_, remotePacket := g.Transport()
-	rAddr := remotePacket.Address()
-
-	// bind to interface avoid route decision
-	lAddr := &net.UDPAddr{}
-	rIP := rAddr.(*net.UDPAddr).IP
-	if rIP.To4() != nil {
-		lIP, err := iface.GetIPv4()
-		if err != nil {
-			return
-		}
-		lAddr.IP = lIP
+	var (
+		sl    conn.ProxyPacketConn
+		rAddr net.Addr
+		err   error
+	)
+	if ps, ok := g.proxy.(proxy.PacketOverStream); ok {
+		sl, rAddr, err = g.dialPacketStream(ps, msg.Metadata())
 	} else {
-		lIP, err := iface.GetIPv6()
-		if err != nil {
-			return
-		}
-		lAddr.IP = lIP
-	}
-
-	// create udp listener to implement fullcone nat
-	l, err := net.ListenUDP("udp", lAddr)
-	if err != nil {
-		log.Error(g.logString("failed to listen udp"),
-			zap.Error(err))
-		return
+		sl, rAddr, err = g.dialPacket()
 	}
-	sl, err := g.proxy.ShadowPacketConn(l)
 	if err != nil {
 		log.Error(g.logString("failed to shadow connection"),
 			zap.Error(err))
@@ -231,7 +232,7 @@ func (g *General) ProcessPacket(c conn.ProxyPacketConn, msg message.Message) {
 
 	// add to nat then copy remote response to client connection
 	cAddr := net.UDPAddr{IP: msg.Metadata().ClientIP, Port: msg.Metadata().ClientPort}
-	gnat.Set(cAddr.String(), nat.LinkPacketConn{PacketConn: sl, Addr: sl.LocalAddr()})
+	gnat.Set(cAddr.String(), nat.LinkPacketConn{PacketConn: sl, Addr: rAddr})
 	log.Info(g.logString("udp nat created"),
 		zap.String("client", cAddr.String()),
 		zap.String("remote", rAddr.String()))
@@ -282,20 +283,151 @@ func (g *General) ProcessPacket(c conn.ProxyPacketConn, msg message.Message) {
 	}()
 }
 
+// dialStream dials stream transport, which goes through dialer if set
+func (g *General) dialStream() (net.Conn, error) {
+	remoteStream, _ := g.Transport()
+	return remoteStream.DialStream()
+}
+
+// chain makes stream transport dial remote through dialer, tls,
+// websocket and sessions of smux of the transport are over the chained
+// connections
+func (g *General) chain() {
+	t, ok := g.tranStream.(interface {
+		SetDial(func() (net.Conn, error))
+	})
+	if !ok || g.dialer == nil {
+		return
+	}
+	rAddr := g.tranStream.Address().(*net.TCPAddr)
+	dialer := g.dialer
+	t.SetDial(func() (net.Conn, error) {
+		m := message.NewMetadata().WithRemoteIP(rAddr.IP).WithRemotePort(rAddr.Port)
+		return &chainedStream{Conn: egress.DialStream(dialer, m), rAddr: rAddr}, nil
+	})
+}
+
+// dialPacket creates udp listener to implement fullcone nat, returns
+// it with address of remote
+func (g *General) dialPacket() (conn.ProxyPacketConn, net.Addr, error) {
+	// get target address
+	_, remotePacket := g.Transport()
+	rAddr := remotePacket.Address()
+	if g.dialer != nil {
+		uAddr := rAddr.(*net.UDPAddr)
+		m := message.NewMetadata().WithRemoteIP(uAddr.IP).WithRemotePort(uAddr.Port)
+		sl, err := g.proxy.ShadowPacketConn(&chainedPacket{Conn: egress.DialPacket(g.dialer, m)})
+		if err != nil {
+			return nil, nil, err
+		}
+		return sl, rAddr, nil
+	}
+
+	// faked tcp connection is dialed to remote by transport
+	if t, ok := remotePacket.(*transport.TransUDP); ok && t.FakeTCP() {
+		l, err := remotePacket.DialPacket()
+		if err != nil {
+			return nil, nil, err
+		}
+		sl, err := g.proxy.ShadowPacketConn(l)
+		if err != nil {
+			l.Close()
+			return nil, nil, err
+		}
+		return sl, rAddr, nil
+	}
+
+	// bind to interface avoid route decision
+	lAddr := &net.UDPAddr{}
+	rIP := rAddr.(*net.UDPAddr).IP
+	if rIP.To4() != nil {
+		lIP, err := iface.GetIPv4()
+		if err != nil {
+			return nil, nil, err
+		}
+		lAddr.IP = lIP
+	} else {
+		lIP, err := iface.GetIPv6()
+		if err != nil {
+			return nil, nil, err
+		}
+		lAddr.IP = lIP
+	}
+
+	l, err := net.ListenUDP("udp", lAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	sl, err := g.proxy.ShadowPacketConn(l)
+	if err != nil {
+		l.Close()
+		return nil, nil, err
+	}
+	return sl, rAddr, nil
+}
+
+// dialPacketStream dials stream transport for proxies carrying udp in
+// stream connection
+func (g *General) dialPacketStream(ps proxy.PacketOverStream, m *message.Metadata) (conn.ProxyPacketConn, net.Addr, error) {
+	rc, err := g.dialStream()
+	if err != nil {
+		return nil, nil, err
+	}
+	sl, err := ps.StreamPacketConn(rc, m)
+	if err != nil {
+		rc.Close()
+		return nil, nil, err
+	}
+	return sl, rc.RemoteAddr(), nil
+}
+
+// chainedStream is stream connection to remote through dialer, it
+// takes tcp addresses as proxies expect
+type chainedStream struct {
+	net.Conn
+	rAddr *net.TCPAddr
+}
+
+func (c *chainedStream) LocalAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (c *chainedStream) RemoteAddr() net.Addr {
+	return c.rAddr
+}
+
+// chainedPacket is packet connection to the only remote through
+// dialer, every packet goes to remote regardless of address
+type chainedPacket struct {
+	net.Conn
+}
+
+func (c *chainedPacket) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := c.Read(b)
+	return n, c.RemoteAddr(), err
+}
+
+func (c *chainedPacket) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.Write(b)
+}
+
 func (g *General) UnmarshalYAML(value *yaml.Node) error {
 	var (
 		name       string
+		dialer     string
 		p          proxy.Proxy
 		tranStream transport.Transport
 		tranPacket transport.Transport
 		err        error
 	)
-	for i := 0; i < 4; i++ {
-		k := value.Content[2*i]
-		v := value.Content[2*i+1]
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		k := value.Content[i]
+		v := value.Content[i+1]
 		switch k.Value {
 		case "name":
 			name = v.Value
+		case "dialer":
+			dialer = v.Value
 		case "proxy":
 			if p, err = unmarshalProxy(v); err != nil {
 				return err
@@ -307,6 +439,7 @@ func (g *General) UnmarshalYAML(value *yaml.Node) error {
 		}
 	}
 	g.name = name
+	g.dialerName = dialer
 	g.proxy = p
 	g.tranStream = tranStream
 	g.tranPacket = tranPacket
@@ -355,6 +488,40 @@ func unmarshalTran(value *yaml.Node) (transport.Transport, transport.Transport,
 			if tranStream, err = transport.NewTransTCP("tcp", addr, transport.WithSmux(smux)); err != nil {
 				return nil, nil, err
 			}
+		case transport.TypeTLS:
+			attrMay := map[string]any{
+				"smux": &smux,
+			}
+			if err = util.MayHave(t, attrMay); err != nil {
+				return nil, nil, err
+			}
+			config, err := unmarshalTLS(t, false)
+			if err != nil {
+				return nil, nil, err
+			}
+			if tranStream, err = transport.NewTransTLS("tcp", addr, config, transport.WithSmux(smux)); err != nil {
+				return nil, nil, err
+			}
+		case transport.TypeWS, transport.TypeWSS:
+			attrMay := map[string]any{
+				"smux": &smux,
+			}
+			if err = util.MayHave(t, attrMay); err != nil {
+				return nil, nil, err
+			}
+			var tlsConfig *tls.Config
+			if transport.TransType(strings.ToUpper(string(tType))) == transport.TypeWSS {
+				if tlsConfig, err = unmarshalTLS(t, false); err != nil {
+					return nil, nil, err
+				}
+			}
+			wsConfig, err := unmarshalWS(t)
+			if err != nil {
+				return nil, nil, err
+			}
+			if tranStream, err = transport.NewTransWS("tcp", addr, tlsConfig, wsConfig, transport.WithSmux(smux)); err != nil {
+				return nil, nil, err
+			}
 		case transport.TypePacket:
 			attrMay := map[string]any{
 				"faketcp": &faketcp,
@@ -374,6 +541,67 @@ func unmarshalTran(value *yaml.Node) (transport.Transport, transport.Transport,
 	return tranStream, tranPacket, nil
 }
 
+// unmarshalTLS gets tls config of transport from attributes in t
+func unmarshalTLS(t map[string]any, server bool) (*tls.Config, error) {
+	var (
+		o          transport.TLSOptions
+		alpn, pins []any
+		attrMay    = map[string]any{
+			"sni":              &o.ServerName,
+			"alpn":             &alpn,
+			"min-version":      &o.MinVersion,
+			"ca":               &o.CA,
+			"cert":             &o.Cert,
+			"key":              &o.Key,
+			"skip-cert-verify": &o.SkipCertVerify,
+			"pins":             &pins,
+		}
+	)
+	if err := util.MayHave(t, attrMay); err != nil {
+		return nil, err
+	}
+	for _, v := range alpn {
+		o.ALPN = append(o.ALPN, fmt.Sprint(v))
+	}
+	for _, v := range pins {
+		o.Pins = append(o.Pins, fmt.Sprint(v))
+	}
+	for _, path := range []*string{&o.CA, &o.Cert, &o.Key} {
+		if *path == "" {
+			continue
+		}
+		abs, err := util.GetAbsPath(*path)
+		if err != nil {
+			return nil, err
+		}
+		*path = abs
+	}
+	return transport.NewTLSConfig(o, server)
+}
+
+// unmarshalWS gets websocket config of transport from attributes in t
+func unmarshalWS(t map[string]any) (*websocket.Config, error) {
+	var (
+		config  websocket.Config
+		headers map[string]any
+		attrMay = map[string]any{
+			"path":              &config.Path,
+			"host":              &config.Host,
+			"headers":           &headers,
+			"max-early-data":    &config.MaxEarlyData,
+			"early-data-header": &config.EarlyDataHeader,
+		}
+	)
+	if err := util.MayHave(t, attrMay); err != nil {
+		return nil, err
+	}
+	config.Header = make(nethttp.Header)
+	for k, v := range headers {
+		config.Header.Set(k, fmt.Sprint(v))
+	}
+	return &config, nil
+}
+
 func unmarshalProxy(value *yaml.Node) (proxy.Proxy, error) {
 	var (
 		p     proxy.Proxy
@@ -395,7 +623,102 @@ func unmarshalProxy(value *yaml.Node) (proxy.Proxy, error) {
 
 	switch proxy.ProxyType(strings.ToUpper(string(pType))) {
 	case proxy.TypeSocks:
-		p = socks.NewProxySocks(proxy.ModeClient)
+		var (
+			username string
+			password string
+			attrMay  = map[string]any{
+				"username": &username,
+				"password": &password,
+			}
+		)
+		if err = util.MayHave(t, attrMay); err != nil {
+			return nil, err
+		}
+		p = socks.NewProxySocks(proxy.ModeClient).WithCredential(username, password)
+	case proxy.TypeHTTP:
+		var (
+			username string
+			password string
+			attrMay  = map[string]any{
+				"username": &username,
+				"password": &password,
+			}
+		)
+		if err = util.MayHave(t, attrMay); err != nil {
+			return nil, err
+		}
+		p = http.NewProxyHTTP(proxy.ModeClient).WithCredential(username, password)
+	case proxy.TypeTrojan:
+		var (
+			password string
+			attrMust = map[string]any{
+				"password": &password,
+			}
+		)
+		if err = util.MustHave(t, attrMust); err != nil {
+			return nil, err
+		}
+		var (
+			udp            bool
+			sni            string
+			skipCertVerify bool
+			attrMay        = map[string]any{
+				"udp":              &udp,
+				"sni":              &sni,
+				"skip-cert-verify": &skipCertVerify,
+			}
+		)
+		if err = util.MayHave(t, attrMay); err != nil {
+			return nil, err
+		}
+		config := &tls.Config{ServerName: sni, InsecureSkipVerify: skipCertVerify}
+		p = trojan.NewProxyTrojan(proxy.ModeClient, password, config, udp)
+	case proxy.TypeVMess:
+		var (
+			uuid     string
+			attrMust = map[string]any{
+				"uuid": &uuid,
+			}
+		)
+		if err = util.MustHave(t, attrMust); err != nil {
+			return nil, err
+		}
+		var (
+			security string
+			udp      bool
+			attrMay  = map[string]any{
+				"security": &security,
+				"udp":      &udp,
+			}
+		)
+		if err = util.MayHave(t, attrMay); err != nil {
+			return nil, err
+		}
+		if p, err = vmess.NewProxyVMess(proxy.ModeClient, uuid, security, udp); err != nil {
+			return nil, err
+		}
+	case proxy.TypeVLESS:
+		var (
+			uuid     string
+			attrMust = map[string]any{
+				"uuid": &uuid,
+			}
+		)
+		if err = util.MustHave(t, attrMust); err != nil {
+			return nil, err
+		}
+		var (
+			udp     bool
+			attrMay = map[string]any{
+				"udp": &udp,
+			}
+		)
+		if err = util.MayHave(t, attrMay); err != nil {
+			return nil, err
+		}
+		if p, err = vless.NewProxyVLESS(proxy.ModeClient, uuid, udp); err != nil {
+			return nil, err
+		}
 	case proxy.TypeNone:
 		p = none.NewProxyNone(proxy.ModeClient)
 	case proxy.TypeShadowsocks:
@@ -423,7 +746,7 @@ func unmarshalProxy(value *yaml.Node) (proxy.Proxy, error) {
 			return nil, err
 		}
 
-		p, err = shadowsocks.NewProxyShadowsocks(proxy.ModeClient, password, cipher, "", udp)
+		p, err = shadowsocks.NewProxyShadowsocks(proxy.ModeClient, password, cipher, key, udp)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,183 @@
+package general
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/intxff/rdcross/component/iface"
+	"github.com/intxff/rdcross/component/message"
+	"github.com/intxff/rdcross/component/proxy"
+	"github.com/intxff/rdcross/component/proxy/none"
+	"github.com/intxff/rdcross/component/transport"
+	"github.com/intxff/rdcross/egress"
+)
+
+// newEgress creates egress without proxy to stream and packet addresses
+func newEgress(t *testing.T, name, stream, packet string) *General {
+	ts, err := transport.NewTransTCP("tcp", stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp, err := transport.NewTransUDP("udp", packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewGeneral(name, none.NewProxyNone(proxy.ModeClient), ts, tp)
+}
+
+func TestChain(t *testing.T) {
+	// egress dials from physical interface
+	ip, err := iface.GetIPv4()
+	if err != nil {
+		t.Skip(err)
+	}
+	local := net.JoinHostPort(ip.String(), "0")
+
+	// echo server, reached by the hop through a counting relay
+	server, err := net.Listen("tcp", local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		for {
+			c, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+	relay, err := net.Listen("tcp", local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+	var relayed atomic.Int32
+	go func() {
+		for {
+			c, err := relay.Accept()
+			if err != nil {
+				return
+			}
+			relayed.Add(1)
+			go func() {
+				rc, err := net.Dial("tcp", server.Addr().String())
+				if err != nil {
+					c.Close()
+					return
+				}
+				go io.Copy(rc, c)
+				io.Copy(c, rc)
+				c.Close()
+			}()
+		}
+	}()
+	udp, err := net.ListenPacket("udp", local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	var hopped atomic.Int32
+	go func() {
+		b := make([]byte, 1500)
+		for {
+			n, from, err := udp.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			hopped.Add(1)
+			udp.WriteTo(b[:n], from)
+		}
+	}()
+
+	hop := newEgress(t, "hop", relay.Addr().String(), udp.LocalAddr().String())
+	// packets of out reach echo only through hop, which has no proxy
+	out := newEgress(t, "out", server.Addr().String(), net.JoinHostPort(ip.String(), "9"))
+	out.dialerName = "hop"
+	if err := out.BindDialer(map[string]egress.Egress{"hop": hop, "out": out}); err != nil {
+		t.Fatal(err)
+	}
+
+	// stream goes through transport of hop
+	m := message.NewMetadata().WithDomain("example.org").WithRemotePort(80)
+	c := egress.DialStream(out, m)
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	c.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("stream echo %q %v", buf, err)
+	}
+	c.Close()
+	if relayed.Load() != 1 {
+		t.Errorf("stream relayed %v times", relayed.Load())
+	}
+
+	// packet goes through chained packet of hop
+	p := egress.DialPacket(out, m)
+	defer p.Close()
+	p.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := p.Write([]byte("query")); err != nil {
+		t.Fatal(err)
+	}
+	buf = make([]byte, 16)
+	if n, err := p.Read(buf); err != nil || string(buf[:n]) != "query" {
+		t.Fatalf("packet echo %q %v", buf[:n], err)
+	}
+	if hopped.Load() != 1 {
+		t.Errorf("packet hopped %v times", hopped.Load())
+	}
+
+	// failed first hop ends stream of the next
+	dead, _ := net.Listen("tcp", local)
+	dead.Close()
+	broken := newEgress(t, "broken", dead.Addr().String(), udp.LocalAddr().String())
+	out2 := newEgress(t, "out2", server.Addr().String(), udp.LocalAddr().String()).WithDialer(broken)
+	c = egress.DialStream(out2, m)
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	c.Write([]byte("ping"))
+	if _, err := io.ReadFull(c, buf[:4]); err == nil {
+		t.Error("stream through failed hop echoed")
+	}
+	c.Close()
+}
+
+func TestBindDialer(t *testing.T) {
+	e := map[string]egress.Egress{}
+	for _, v := range []struct{ name, dialer string }{
+		{"a", "b"}, {"b", "a"}, {"self", "self"}, {"lost", "nowhere"}, {"ok", "c"}, {"c", ""},
+	} {
+		g := newEgress(t, v.name, "127.0.0.1:1", "127.0.0.1:1")
+		g.dialerName = v.dialer
+		e[v.name] = g
+	}
+	cases := []struct {
+		name string
+		err  string
+	}{
+		{"a", "loops"},
+		{"self", "loops"},
+		{"lost", "not exist"},
+		{"ok", ""},
+		{"c", ""},
+	}
+	for _, c := range cases {
+		err := e[c.name].(*General).BindDialer(e)
+		if c.err == "" {
+			if err != nil {
+				t.Errorf("%v: %v", c.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%v: %v, want %v", c.name, err, c.err)
+		}
+	}
+	if e["ok"].(*General).dialer != e["c"] {
+		t.Error("dialer not bound")
+	}
+}
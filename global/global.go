@@ -2,6 +2,7 @@ package global
 
 import (
 	"fmt"
+	"path/filepath"
 	"sync"
 
 	"github.com/intxff/rdcross/component/fakeip"
@@ -12,6 +13,7 @@ import (
 	"github.com/intxff/rdcross/log"
 	"github.com/intxff/rdcross/router"
 	"github.com/intxff/rdcross/util/trie"
+	"go.uber.org/zap"
 )
 
 const (
@@ -24,6 +26,12 @@ const (
 	Router       = "router"
 )
 
+// files in config dir to keep fake ip mapping
+const (
+	fakeipFile  = "fakeip.cache"
+	fakeipFile6 = "fakeip6.cache"
+)
+
 type ErrInvalidValue string
 
 func (e ErrInvalidValue) Error() string {
@@ -77,6 +85,13 @@ type resource struct {
 
 	muRouter sync.RWMutex
 	Router   *router.Router
+
+	muFakeIP sync.RWMutex
+	// fake ip pools persisted to files
+	FakeIP []*fakeip.FakeIP
+
+	// address restful api listens on, empty disables api
+	API string
 }
 
 func Register(key string, value ...any) error {
@@ -201,14 +216,60 @@ func registerEgressGroup(value ...any) error {
 	return nil
 }
 
+// GetIngress finds ingress by name
+func (r *resource) GetIngress(name string) (ingress.Ingress, bool) {
+	r.muIngress.RLock()
+	defer r.muIngress.RUnlock()
+	ing, exist := r.Ingress[name]
+	return ing, exist
+}
+
+// CloseFakeIP compacts and closes files of fake ip pools, it is called
+// at shutdown
+func (r *resource) CloseFakeIP() {
+	r.muFakeIP.RLock()
+	defer r.muFakeIP.RUnlock()
+	for _, pool := range r.FakeIP {
+		if err := pool.Close(); err != nil {
+			log.Error("[FakeIP] failed to close", zap.Error(err))
+		}
+	}
+}
+
 func Init(c *config.RdConfig) error {
 	// prepare shared resource: trie, nat,
-	var fakeipPool *fakeip.FakeIP
+	var fakeipPool, fakeipPool6 *fakeip.FakeIP
 	domainTrie := trie.New()
 	if c.DNS.FakeIP.Enable {
 		fakeipPool, _ = fakeip.New(c.DNS.FakeIP.Cidr)
+		if c.DNS.FakeIP.Cidr6 != "" {
+			var err error
+			if fakeipPool6, err = fakeip.New(c.DNS.FakeIP.Cidr6); err != nil {
+				return err
+			}
+		}
+		if c.DNS.FakeIP.Persist {
+			if err := fakeipPool.Persist(filepath.Join(c.Dir, fakeipFile)); err != nil {
+				return err
+			}
+			if fakeipPool6 != nil {
+				if err := fakeipPool6.Persist(filepath.Join(c.Dir, fakeipFile6)); err != nil {
+					return err
+				}
+			}
+		}
 	}
 
+	global.muFakeIP.Lock()
+	global.FakeIP = nil
+	for _, pool := range []*fakeip.FakeIP{fakeipPool, fakeipPool6} {
+		if pool != nil {
+			global.FakeIP = append(global.FakeIP, pool)
+		}
+	}
+	global.muFakeIP.Unlock()
+	global.API = c.API
+
 	// logger
 	if err := Register(Logger, c.ParseLog()); err != nil {
 		return err
@@ -239,7 +300,10 @@ func Init(c *config.RdConfig) error {
 
 	// dns
 	if c.DNS.Enable {
-		d := c.DNS.NewServer(fakeipPool)
+		d, err := c.DNS.NewServer(c.Dir, fakeipPool, fakeipPool6, eg)
+		if err != nil {
+			return err
+		}
 		if err := Register(DNS, d); err != nil {
 			return err
 		}
@@ -1,19 +1,29 @@
 package general
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strings"
 	"sync"
 	"sync/atomic"
 
 	"github.com/intxff/rdcross/component/conn"
+	"github.com/intxff/rdcross/component/message"
 	"github.com/intxff/rdcross/component/nat"
 	"github.com/intxff/rdcross/component/proxy"
+	"github.com/intxff/rdcross/component/proxy/http"
+	"github.com/intxff/rdcross/component/proxy/mixed"
 	"github.com/intxff/rdcross/component/proxy/none"
 	"github.com/intxff/rdcross/component/proxy/shadowsocks"
 	"github.com/intxff/rdcross/component/proxy/socks"
+	"github.com/intxff/rdcross/component/proxy/trojan"
+	"github.com/intxff/rdcross/component/proxy/vless"
+	"github.com/intxff/rdcross/component/proxy/vmess"
 	"github.com/intxff/rdcross/component/transport"
+	"github.com/intxff/rdcross/component/websocket"
 	"github.com/intxff/rdcross/ingress"
 	"github.com/intxff/rdcross/log"
 	"github.com/intxff/rdcross/router"
@@ -131,43 +141,29 @@ func (g *General) handleStream(t transport.Transport, r router.Router) {
 				zap.String("remote", sc.RemoteAddr().String()),
 				zap.String("local", sc.LocalAddr().String()))
 
-			g.conns.Store(c.LocalAddr().String(), sc)
+			g.conns.Store(sc, sc)
 
 			defer func() {
-				g.conns.Delete(sc.LocalAddr().String())
+				g.conns.Delete(sc)
 				sc.Close()
 				log.Info(g.logString("connection closed"),
 					zap.String("remote", sc.RemoteAddr().String()),
 					zap.String("local", sc.LocalAddr().String()))
 			}()
-			if g.proxy.TcpMux() {
-				/* for {
-					msg, err := sc.ReadMux()
-					msg.Metadata().WithIngress(g.Name())
-					if err != nil {
-						if err != io.EOF {
-							continue
-						}
-						log.Error("", zap.Error(err))
-					}
-					out := r.Dispatch(*msg.Metadata())
-					go out.ProcessStream(sc, msg)
-				} */
-			} else {
-				sc.Metadata().WithIngress(g.Name())
-				out := r.Dispatch(*(sc.Metadata()))
-				log.Info(g.logString("connection dispatched"),
-					zap.String("egress", out.Name()))
-				out.ProcessStream(sc, nil)
+			if ps, ok := sc.(conn.PacketStreamConn); ok && ps.PacketConn() != nil {
+				g.handlePacketStream(ps.PacketConn(), r)
+				return
 			}
+			sc.Metadata().WithIngress(g.Name())
+			out := r.Dispatch(*(sc.Metadata()))
+			log.Info(g.logString("connection dispatched"),
+				zap.String("egress", out.Name()))
+			out.ProcessStream(sc, nil)
 		}()
 	}
 }
 
 func (g *General) handlePacket(t transport.Transport, r router.Router) {
-	// nat
-	nat := nat.New()
-
 	// get connection
 	c, err := t.ListenPacket()
 	if err != nil {
@@ -209,21 +205,44 @@ func (g *General) handlePacket(t transport.Transport, r router.Router) {
 			continue
 		}
 
-		msg.Metadata().WithIngress(g.Name())
+		g.dispatchPacket(sc, msg, cAddr, r)
+	}
+}
 
-		// check whether exist in nat
-		if lc, exist := nat.Get(cAddr.String()); exist {
-			rc, rAddr := lc.PacketConn.(conn.ProxyPacketConn), lc.Addr
-			rc.WriteMsgTo(msg, rAddr)
-			continue
+// handlePacketStream handles udp packets carried in a stream connection,
+// which ends once a packet fails to read
+func (g *General) handlePacketStream(sc conn.ProxyPacketConn, r router.Router) {
+	for g.status.Load() != ingress.Closed {
+		msg, cAddr, err := sc.ReadMsgFrom()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Error(g.logString("failed to read"),
+					zap.String("remote", cAddr.String()),
+					zap.Error(err))
+			}
+			return
 		}
-		out := r.Dispatch(*msg.Metadata())
-		log.Info(g.logString("connection dispatched"),
-			zap.String("egress", out.Name()))
-		out.ProcessPacket(sc, msg)
+		g.dispatchPacket(sc, msg, cAddr, r)
 	}
 }
 
+// dispatchPacket sends msg by nat entry of client, or dispatches it to
+// egress
+func (g *General) dispatchPacket(sc conn.ProxyPacketConn, msg message.Message, cAddr net.Addr, r router.Router) {
+	msg.Metadata().WithIngress(g.Name())
+
+	// check whether exist in nat
+	if lc, exist := nat.New().Get(cAddr.String()); exist {
+		rc, rAddr := lc.PacketConn.(conn.ProxyPacketConn), lc.Addr
+		rc.WriteMsgTo(msg, rAddr)
+		return
+	}
+	out := r.Dispatch(*msg.Metadata())
+	log.Info(g.logString("connection dispatched"),
+		zap.String("egress", out.Name()))
+	out.ProcessPacket(sc, msg)
+}
+
 func (g *General) UnmarshalYAML(value *yaml.Node) error {
 	var (
 		name  string
@@ -298,6 +317,40 @@ func unmarshalTran(value *yaml.Node) (transport.Transport, error) {
 		if tran, err = transport.NewTransTCP("tcp", addr, transport.WithSmux(smux)); err != nil {
 			return nil, err
 		}
+	case transport.TypeTLS:
+		attrMay := map[string]any{
+			"smux": &smux,
+		}
+		if err = util.MayHave(t, attrMay); err != nil {
+			return nil, err
+		}
+		config, err := unmarshalTLS(t, true)
+		if err != nil {
+			return nil, err
+		}
+		if tran, err = transport.NewTransTLS("tcp", addr, config, transport.WithSmux(smux)); err != nil {
+			return nil, err
+		}
+	case transport.TypeWS, transport.TypeWSS:
+		attrMay := map[string]any{
+			"smux": &smux,
+		}
+		if err = util.MayHave(t, attrMay); err != nil {
+			return nil, err
+		}
+		var tlsConfig *tls.Config
+		if transport.TransType(strings.ToUpper(string(tType))) == transport.TypeWSS {
+			if tlsConfig, err = unmarshalTLS(t, true); err != nil {
+				return nil, err
+			}
+		}
+		wsConfig, err := unmarshalWS(t)
+		if err != nil {
+			return nil, err
+		}
+		if tran, err = transport.NewTransWS("tcp", addr, tlsConfig, wsConfig, transport.WithSmux(smux)); err != nil {
+			return nil, err
+		}
 	case transport.TypePacket:
 		attrMay := map[string]any{
 			"faketcp": &faketcp,
@@ -316,6 +369,59 @@ func unmarshalTran(value *yaml.Node) (transport.Transport, error) {
 	return tran, nil
 }
 
+// unmarshalTLS gets tls config of transport from attributes in t
+func unmarshalTLS(t map[string]any, server bool) (*tls.Config, error) {
+	var (
+		o          transport.TLSOptions
+		alpn, pins []any
+		attrMay    = map[string]any{
+			"sni":              &o.ServerName,
+			"alpn":             &alpn,
+			"min-version":      &o.MinVersion,
+			"ca":               &o.CA,
+			"cert":             &o.Cert,
+			"key":              &o.Key,
+			"skip-cert-verify": &o.SkipCertVerify,
+			"pins":             &pins,
+		}
+	)
+	if err := util.MayHave(t, attrMay); err != nil {
+		return nil, err
+	}
+	for _, v := range alpn {
+		o.ALPN = append(o.ALPN, fmt.Sprint(v))
+	}
+	for _, v := range pins {
+		o.Pins = append(o.Pins, fmt.Sprint(v))
+	}
+	for _, path := range []*string{&o.CA, &o.Cert, &o.Key} {
+		if *path == "" {
+			continue
+		}
+		abs, err := util.GetAbsPath(*path)
+		if err != nil {
+			return nil, err
+		}
+		*path = abs
+	}
+	return transport.NewTLSConfig(o, server)
+}
+
+// unmarshalWS gets websocket config of transport from attributes in t
+func unmarshalWS(t map[string]any) (*websocket.Config, error) {
+	var (
+		config  websocket.Config
+		attrMay = map[string]any{
+			"path":              &config.Path,
+			"early-data-header": &config.EarlyDataHeader,
+		}
+	)
+	if err := util.MayHave(t, attrMay); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
 func unmarshalProxy(value *yaml.Node) (proxy.Proxy, error) {
 	var (
 		p     proxy.Proxy
@@ -337,7 +443,148 @@ func unmarshalProxy(value *yaml.Node) (proxy.Proxy, error) {
 
 	switch proxy.ProxyType(strings.ToUpper(string(pType))) {
 	case proxy.TypeSocks:
-		p = socks.NewProxySocks(proxy.ModeServer)
+		var (
+			users   []any
+			attrMay = map[string]any{
+				"users": &users,
+			}
+		)
+		if err = util.MayHave(t, attrMay); err != nil {
+			return nil, err
+		}
+		s := socks.NewProxySocks(proxy.ModeServer)
+		err = unmarshalUsers(users, "password", func(name, password string) error {
+			s.AddUser(name, password)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		p = s
+	case proxy.TypeHTTP:
+		var (
+			users   []any
+			attrMay = map[string]any{
+				"users": &users,
+			}
+		)
+		if err = util.MayHave(t, attrMay); err != nil {
+			return nil, err
+		}
+		h := http.NewProxyHTTP(proxy.ModeServer)
+		err = unmarshalUsers(users, "password", func(name, password string) error {
+			h.AddUser(name, password)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		p = h
+	case proxy.TypeMixed:
+		var (
+			users   []any
+			attrMay = map[string]any{
+				"users": &users,
+			}
+		)
+		if err = util.MayHave(t, attrMay); err != nil {
+			return nil, err
+		}
+		m := mixed.NewProxyMixed()
+		err = unmarshalUsers(users, "password", func(name, password string) error {
+			m.AddUser(name, password)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		p = m
+	case proxy.TypeTrojan:
+		var (
+			password, cert, key string
+			attrMust            = map[string]any{
+				"password": &password,
+				"cert":     &cert,
+				"key":      &key,
+			}
+		)
+		if err = util.MustHave(t, attrMust); err != nil {
+			return nil, err
+		}
+		var (
+			users    []any
+			fallback string
+			attrMay  = map[string]any{
+				"users":    &users,
+				"fallback": &fallback,
+			}
+		)
+		if err = util.MayHave(t, attrMay); err != nil {
+			return nil, err
+		}
+		if cert, err = util.GetAbsPath(cert); err != nil {
+			return nil, err
+		}
+		if key, err = util.GetAbsPath(key); err != nil {
+			return nil, err
+		}
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config := &tls.Config{Certificates: []tls.Certificate{pair}}
+		tj := trojan.NewProxyTrojan(proxy.ModeServer, password, config, true).
+			WithFallback(fallback)
+		err = unmarshalUsers(users, "password", func(name, password string) error {
+			tj.AddUser(name, password)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		p = tj
+	case proxy.TypeVMess:
+		var (
+			uuid     string
+			security string
+			users    []any
+			attrMay  = map[string]any{
+				"uuid":     &uuid,
+				"security": &security,
+				"users":    &users,
+			}
+		)
+		if err = util.MayHave(t, attrMay); err != nil {
+			return nil, err
+		}
+		vm, err := vmess.NewProxyVMess(proxy.ModeServer, uuid, security, true)
+		if err != nil {
+			return nil, err
+		}
+		if err = unmarshalUsers(users, "uuid", vm.AddUser); err != nil {
+			return nil, err
+		}
+		p = vm
+	case proxy.TypeVLESS:
+		var (
+			uuid    string
+			users   []any
+			attrMay = map[string]any{
+				"uuid":  &uuid,
+				"users": &users,
+			}
+		)
+		if err = util.MayHave(t, attrMay); err != nil {
+			return nil, err
+		}
+		vl, err := vless.NewProxyVLESS(proxy.ModeServer, uuid, true)
+		if err != nil {
+			return nil, err
+		}
+		if err = unmarshalUsers(users, "uuid", vl.AddUser); err != nil {
+			return nil, err
+		}
+		p = vl
 	case proxy.TypeNone:
 		p = none.NewProxyNone(proxy.ModeServer)
 	case proxy.TypeShadowsocks:
@@ -356,19 +603,50 @@ func unmarshalProxy(value *yaml.Node) (proxy.Proxy, error) {
 		var (
 			udp     bool
 			key     string
+			users   []any
 			attrMay = map[string]any{
-				"udp": &udp,
-				"key": &key,
+				"udp":   &udp,
+				"key":   &key,
+				"users": &users,
 			}
 		)
 		if err = util.MayHave(t, attrMay); err != nil {
 			return nil, err
 		}
 
-		p, err = shadowsocks.NewProxyShadowsocks(proxy.ModeServer, password, cipher, "", udp)
+		ss, err := shadowsocks.NewProxyShadowsocks(proxy.ModeServer, password, cipher, key, udp)
 		if err != nil {
 			return nil, err
 		}
+		if err = unmarshalUsers(users, "password", ss.AddUser); err != nil {
+			return nil, err
+		}
+		p = ss
 	}
 	return p, nil
 }
+
+// unmarshalUsers adds every user with name and secret, which is in key
+// of the user, by add
+func unmarshalUsers(users []any, key string, add func(name, secret string) error) error {
+	for _, v := range users {
+		u, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("invalid user %v", v)
+		}
+		var (
+			name, secret string
+			attrMust     = map[string]any{
+				"name": &name,
+				key:    &secret,
+			}
+		)
+		if err := util.MustHave(u, attrMust); err != nil {
+			return err
+		}
+		if err := add(name, secret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
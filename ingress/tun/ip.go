@@ -69,18 +69,40 @@ var (
 type ipPacket []byte
 
 func (p ipPacket) version() ipVersion {
-	if p[0]&0xf0 == 0x06 {
+	if p[0]>>4 == 6 {
 		return ipv6
 	}
 	return ipv4
 }
 
+// headerLen is length of ip header, extension headers of ipv6 are not
+// supported
+func (p ipPacket) headerLen() int {
+	if p.version() == ipv6 {
+		return 40
+	}
+	return p.ihl()
+}
+
+// valid checks packet is long enough for its header
+func (p ipPacket) valid() bool {
+	if len(p) < 20 || (p.version() == ipv6 && len(p) < 40) {
+		return false
+	}
+	return len(p) >= p.headerLen()
+}
+
 func (p ipPacket) ihl() int {
 	return int(p[0]&0x0f) * 4
 }
 
 func (p ipPacket) protocol() netProtocol {
-	switch p[9] {
+	proto := p[9]
+	if p.version() == ipv6 {
+		// icmpv6 is 58, it is left to kernel
+		proto = p[6]
+	}
+	switch proto {
 	case 0x06:
 		return tcp
 	case 0x11:
@@ -92,7 +114,7 @@ func (p ipPacket) protocol() netProtocol {
 }
 
 func (p ipPacket) srcIP() net.IP {
-	if p[0]&0xf0 == 0x06 {
+	if p.version() == ipv6 {
 		ip := make([]byte, 16)
 		copy(ip, p[8:24])
 		return net.IP(ip)
@@ -115,24 +137,26 @@ func (p ipPacket) dstIP() net.IP {
 
 func (p ipPacket) setSrcIP(s net.IP) error {
 	if p.version() == ipv6 {
-		if s.To16() == nil {
+		if s.To4() != nil || s.To16() == nil {
 			return errInvalidIPv6
 		}
-		copy(p[8:24], []byte(s))
+		copy(p[8:24], s.To16())
+		return nil
 	}
 	if s.To4() == nil {
 		return errInvalidIPv4
 	}
-	copy(p[12:16], []byte(s))
+	copy(p[12:16], s.To4())
 	return nil
 }
 
 func (p ipPacket) setDstIP(s net.IP) error {
 	if p.version() == ipv6 {
-		if s.To16() == nil {
+		if s.To4() != nil || s.To16() == nil {
 			return errInvalidIPv6
 		}
-		copy(p[24:40], []byte(s))
+		copy(p[24:40], s.To16())
+		return nil
 	}
 	if s.To4() == nil {
 		return errInvalidIPv4
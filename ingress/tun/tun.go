@@ -6,7 +6,6 @@ import (
 	"io"
 	"net"
 	"os"
-	"runtime"
 	"strconv"
 	"strings"
 	"sync"
@@ -52,8 +51,12 @@ type Tun struct {
 	udpNat    sync.Map
 	tcpNat    sync.Map
 	ipPool    *fakeip.FakeIP
+	// optional ipv6 range, relay listens on ip6 too
+	cidr6     *net.IPNet
+	ip6       net.IP
+	ipPool6   *fakeip.FakeIP
 	hijack    []hijackEntry
-	dnsQuery  chan message.Message
+	dnsQuery  chan dnsQuery
 	dnsAddr   net.Addr
 }
 
@@ -98,9 +101,9 @@ func (h *hijackEntry) String() string {
 	return fmt.Sprintf("%v:%v", h.ip, h.port)
 }
 
-func NewTun(name, iprange string, mtu, port int, hijack []string, tdns string) (*Tun, error) {
+func NewTun(name, iprange, iprange6 string, mtu, port int, hijack []string, tdns string) (*Tun, error) {
 	t := &Tun{}
-	err := initTun(t, name, iprange, mtu, port, hijack)
+	err := initTun(t, name, iprange, iprange6, mtu, port, hijack)
 	if err != nil {
 		return nil, err
 	}
@@ -120,16 +123,16 @@ func (t *Tun) WithDNS(s string) error {
 	return nil
 }
 
-func initTun(t *Tun, name, iprange string, mtu, port int, hijack []string) error {
+func initTun(t *Tun, name, iprange, iprange6 string, mtu, port int, hijack []string) error {
 	ip, cidr, err := net.ParseCIDR(iprange)
 	if err != nil {
 		return err
 	}
 
-	hijacklist := make([]hijackEntry, len(hijack))
 	if len(hijack) == 0 {
 		hijack = []string{"0.0.0.0:53"}
 	}
+	hijacklist := make([]hijackEntry, len(hijack))
 	for k, v := range hijack {
 		e, err := newEntry(v)
 		if err != nil {
@@ -145,6 +148,20 @@ func initTun(t *Tun, name, iprange string, mtu, port int, hijack []string) error
 		return err
 	}
 
+	if iprange6 != "" {
+		ip6, cidr6, err := net.ParseCIDR(iprange6)
+		if err != nil {
+			return err
+		}
+		if ip6.To4() != nil {
+			return fmt.Errorf("invalid ipv6 range %v", iprange6)
+		}
+		if t.ipPool6, err = fakeip.New(iprange6); err != nil {
+			return err
+		}
+		t.ip6, t.cidr6 = ip6, cidr6
+	}
+
 	t.name = name
 	t.mtu = mtu
 	t.cidr = cidr
@@ -157,7 +174,7 @@ func initTun(t *Tun, name, iprange string, mtu, port int, hijack []string) error
 	t.tcpNat = sync.Map{}
 	t.status.Store(ingress.Ready)
 	t.hijack = hijacklist
-	t.dnsQuery = make(chan message.Message, 200)
+	t.dnsQuery = make(chan dnsQuery, 200)
 
 	return nil
 }
@@ -174,6 +191,11 @@ func (t *Tun) Type() ingress.IngressType {
 	return ingress.TypeTun
 }
 
+// IPv6 reports whether ipv6 packets are relayed
+func (t *Tun) IPv6() bool {
+	return t.ip6 != nil
+}
+
 func (t *Tun) Proxy() proxy.Proxy {
 	return nil
 }
@@ -182,11 +204,10 @@ func (t *Tun) Transport() []transport.Transport {
 	return nil
 }
 
-var wild = net.ParseIP("0.0.0.0")
-
+// unspecified ip of entry matches any ip of both families
 func (t *Tun) NeedHijack(ip net.IP, port int) bool {
 	for _, v := range t.hijack {
-		if (v.ip.Equal(ip) || v.ip.Equal(wild)) && v.port == port {
+		if (v.ip.Equal(ip) || v.ip.IsUnspecified()) && v.port == port {
 			return true
 		}
 	}
@@ -238,6 +259,21 @@ func (t *Tun) setup() error {
 		fmt.Sprintf("ip rule add from %v iif lo uidrange 0-4294967294 table 100000 preference 10050", t.ip),
 		"ip rule add from all nop preference 10060",
 	}
+	if t.ip6 != nil {
+		ones, _ := t.cidr6.Mask.Size()
+		addr6 := t.ip6.String() + "/" + strconv.Itoa(ones)
+		cmd = append(cmd,
+			fmt.Sprintf("ip -6 addr add %v dev %v nodad", addr6, t.Name()),
+			fmt.Sprintf("ip -6 route add default dev %v table 100000", t.Name()),
+			fmt.Sprintf("ip -6 rule add to %v table 100000 preference 10000", t.cidr6.String()),
+			"ip -6 rule add ipproto ipv6-icmp goto 10060 preference 10010",
+			"ip -6 rule add not dport 53 table main suppress_prefixlength 0 preference 10020",
+			"ip -6 rule add not iif lo table 100000 preference 10030",
+			"ip -6 rule add from :: iif lo uidrange 0-4294967294 table 100000 preference 10040",
+			fmt.Sprintf("ip -6 rule add from %v iif lo uidrange 0-4294967294 table 100000 preference 10050", t.ip6),
+			"ip -6 rule add from all nop preference 10060",
+		)
+	}
 	for _, v := range cmd {
 		if _, err := util.ExecCmd(v); err != nil {
 			t.clean()
@@ -258,6 +294,11 @@ func (t *Tun) clean() {
 		"ip rule delete preference 10050",
 		"ip rule delete preference 10060",
 	}
+	if t.ip6 != nil {
+		for _, v := range cmd {
+			cmd = append(cmd, strings.Replace(v, "ip ", "ip -6 ", 1))
+		}
+	}
 	for _, v := range cmd {
 		util.ExecCmd(v)
 	}
@@ -330,6 +371,9 @@ func (t *Tun) Run(r router.Router) {
 
 		// check packet type
 		packet := ipPacket(buffer[:n])
+		if !packet.valid() || (packet.version() == ipv6 && t.ip6 == nil) {
+			continue
+		}
 		switch packet.protocol() {
 		case tcp:
 			t.processStream(packet)
@@ -342,7 +386,7 @@ func (t *Tun) Run(r router.Router) {
 }
 
 func (t *Tun) processICMP(packet ipPacket) {
-	icmp := icmpPacket(packet[packet.ihl():])
+	icmp := icmpPacket(packet[packet.headerLen():])
 	icmp.setEchoReply()
 	srcIP, dstIP := packet.srcIP(), packet.dstIP()
 	packet.setSrcIP(dstIP)
@@ -351,14 +395,23 @@ func (t *Tun) processICMP(packet ipPacket) {
 	t.fd.Write(packet)
 }
 
+// family gets relay ip and pool of mapped ips for version of packet
+func (t *Tun) family(packet ipPacket) (net.IP, *fakeip.FakeIP) {
+	if packet.version() == ipv6 {
+		return t.ip6, t.ipPool6
+	}
+	return t.relayIP, t.ipPool
+}
+
 func (t *Tun) processStream(packet ipPacket) {
-	stream := tcpPacket(packet[packet.ihl():])
+	stream := tcpPacket(packet[packet.headerLen():])
+	relayIP, pool := t.family(packet)
 	srcIP, srcPort, dstIP, dstPort := packet.srcIP(), stream.srcPort(), packet.dstIP(), stream.dstPort()
 	srcAddr := &net.TCPAddr{IP: srcIP, Port: srcPort}
 	dstAddr := &net.TCPAddr{IP: dstIP, Port: dstPort}
 	// check where the packet come from
 	// from relay tcp listener
-	if srcIP.Equal(t.relayIP) && srcPort == t.relayPort {
+	if srcIP.Equal(relayIP) && srcPort == t.relayPort {
 		var (
 			realDst *net.TCPAddr
 			realSrc *net.TCPAddr
@@ -391,7 +444,7 @@ func (t *Tun) processStream(packet ipPacket) {
 			mappedIP = e.to.(*net.TCPAddr).IP
 		} else {
 			// get mapped address pair first from pool first
-			mappedIP = t.ipPool.Put(dstAddr.String())
+			mappedIP = pool.Put(dstAddr.String())
 			mappedAddr = &net.TCPAddr{IP: mappedIP, Port: srcPort}
 			entry := natEntry{from: dstAddr, to: mappedAddr}
 			entryReverse := natEntry{from: dstAddr, to: srcAddr}
@@ -401,7 +454,7 @@ func (t *Tun) processStream(packet ipPacket) {
 		}
 		// modify dst address to relay listener
 		packet.setSrcIP(mappedIP)
-		packet.setDstIP(t.relayIP)
+		packet.setDstIP(relayIP)
 		stream.setDstPort(t.relayPort)
 		// update checksum
 		stream.updateChecksum(packet)
@@ -420,13 +473,14 @@ func (t *Tun) processStream(packet ipPacket) {
 }
 
 func (t *Tun) processPacket(packet ipPacket) {
-	p := udpPacket(packet[packet.ihl():])
+	p := udpPacket(packet[packet.headerLen():])
+	relayIP, pool := t.family(packet)
 	srcIP, srcPort, dstIP, dstPort := packet.srcIP(), p.srcPort(), packet.dstIP(), p.dstPort()
 	srcAddr := &net.UDPAddr{IP: srcIP, Port: srcPort}
 	dstAddr := &net.UDPAddr{IP: dstIP, Port: dstPort}
 	// check where the packet come from
 	// from relay udp listener
-	if srcIP.Equal(t.relayIP) && srcPort == t.relayPort {
+	if srcIP.Equal(relayIP) && srcPort == t.relayPort {
 		var (
 			realDst *net.UDPAddr
 			realSrc *net.UDPAddr
@@ -455,7 +509,7 @@ func (t *Tun) processPacket(packet ipPacket) {
 			mappedIP = e.to.(*net.UDPAddr).IP
 		} else {
 			// get mapped address pair first from pool first
-			mappedIP = t.ipPool.Put(dstAddr.String())
+			mappedIP = pool.Put(dstAddr.String())
 			mappedAddr = &net.UDPAddr{IP: mappedIP, Port: srcPort}
 			entry := natEntry{from: dstAddr, to: mappedAddr}
 			entryReverse := natEntry{from: dstAddr, to: srcAddr}
@@ -465,7 +519,7 @@ func (t *Tun) processPacket(packet ipPacket) {
 		}
 		// modify dst address to relay listener
 		packet.setSrcIP(mappedIP)
-		packet.setDstIP(t.relayIP)
+		packet.setDstIP(relayIP)
 		p.setDstPort(t.relayPort)
 		// update checksum
 		packet.updateChecksum()
@@ -485,176 +539,69 @@ func (t *Tun) processPacket(packet ipPacket) {
 
 func (t *Tun) relay(r router.Router) error {
 	log.Info(t.logString("starting local relay"))
-	var relayErr = error(nil)
-	wg := sync.WaitGroup{}
-	wg.Add(3)
-	// tcp
-	go func() {
-		lAddr := &net.TCPAddr{IP: t.relayIP, Port: t.relayPort}
-
-		l, err := net.ListenTCP("tcp", lAddr)
-		if err != nil {
-			log.Error(t.logString("failed to listen relay tcp"),
-				zap.Error(err))
+	ips := []net.IP{t.relayIP}
+	if t.ip6 != nil {
+		ips = append(ips, t.ip6)
+	}
+	// every relay reports once it is ready or failed
+	ready := make(chan error, 2*len(ips)+1)
+	for _, ip := range ips {
+		go t.relayStream(r, ip, ready)
+		go t.relayPacket(r, ip, ready)
+	}
+	go t.hijackDNS(ready)
+
+	var relayErr error
+	for i := 0; i < cap(ready); i++ {
+		if err := <-ready; err != nil && relayErr == nil {
 			relayErr = err
-			wg.Done()
-			return
 		}
-		wg.Done()
-		defer l.Close()
-		log.Info(t.logString("local tcp relay started"),
-			zap.String("Addr", lAddr.String()))
-
-		for {
-			c, err := l.Accept()
-			if err != nil {
-				log.Error(t.logString("failed to accept connection"),
-					zap.Error(err))
-				continue
-			}
-
-			if t.status.Load() == ingress.Closed {
-				c.Close()
-				return
-			}
-
-			// handle per connection
-			go func() {
-				// get applications mapped ip and port
-				cAddr := c.RemoteAddr().(*net.TCPAddr)
-				m := message.NewMetadata()
-				m.WithIngress(t.Name()).WithClientIP(cAddr.IP).
-					WithClientPort(cAddr.Port)
-
-				// get real addr
-				entry, _ := t.tcpNat.Load(cAddr.String())
-				realDst := entry.(natEntry).from.(*net.TCPAddr)
-				realSrc := entry.(natEntry).to.(*net.TCPAddr)
-				var remoteAddr string
-				if domain, exist := dns.GetDomainByIP(realDst.IP); exist {
-					domain = domain[:len(domain)-1]
-					m.WithDomain(domain).WithRemotePort(realDst.Port)
-					if ip, err := dns.ResolveIP(domain); err != nil {
-						log.Error("failed to resolve", zap.Error(err))
-					} else {
-						m.WithRemoteIP(ip[0])
-					}
-					remoteAddr = domain
-				} else {
-					m.WithRemoteIP(realDst.IP).WithRemotePort(realDst.Port)
-					remoteAddr = realDst.String()
-				}
-				log.Info(t.logString("accept connection"),
-					zap.String("remote", remoteAddr),
-					zap.String("local", realSrc.String()))
+	}
+	return relayErr
+}
 
-				// construct proxy conn
-				pc := newTunStream(c, m)
-				t.conns.Store(pc.LocalAddr().String(), pc)
-				defer func() {
-					t.conns.Delete(pc.LocalAddr().String())
-					pc.Close()
-					log.Info(t.logString("connection closed"),
-						zap.String("remote", remoteAddr),
-						zap.String("local", realSrc.String()))
-				}()
-
-				// dispatch
-				out := r.Dispatch(*m)
-				log.Info(t.logString("connection dispatched"),
-					zap.String("egress", out.Name()))
-				out.ProcessStream(pc, nil)
-			}()
-		}
-	}()
+// relayStream accepts tcp connections redirected to relay ip
+func (t *Tun) relayStream(r router.Router, ip net.IP, ready chan<- error) {
+	lAddr := &net.TCPAddr{IP: ip, Port: t.relayPort}
 
-	// udp
-	go func() {
-		// nat
-		nat := nat.New()
+	l, err := net.ListenTCP("tcp", lAddr)
+	if err != nil {
+		log.Error(t.logString("failed to listen relay tcp"),
+			zap.Error(err))
+		ready <- err
+		return
+	}
+	ready <- nil
+	defer l.Close()
+	log.Info(t.logString("local tcp relay started"),
+		zap.String("Addr", lAddr.String()))
 
-		// get connection
-		lAddr := &net.UDPAddr{IP: t.relayIP, Port: t.relayPort}
-		c, err := net.ListenUDP("udp", lAddr)
+	for {
+		c, err := l.Accept()
 		if err != nil {
-			log.Error(t.logString("failed to listen udp relay"),
+			log.Error(t.logString("failed to accept connection"),
 				zap.Error(err))
-			relayErr = err
-			wg.Done()
+			continue
+		}
+
+		if t.status.Load() == ingress.Closed {
+			c.Close()
 			return
 		}
-		wg.Done()
-		tc := newTunPacket(c)
-		t.conns.Store(lAddr.String(), tc)
-		log.Info(t.logString("local udp relay started"),
-			zap.String("Addr", lAddr.String()))
-
-		defer func() {
-			t.conns.Delete(lAddr.String())
-			tc.Close()
-		}()
 
-		// handle dns query
+		// handle per connection
 		go func() {
-			ip, _ := iface.GetIP()
-			l, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
-			if err != nil {
-				log.Error(t.logString("failed to listen udp for hijacking dns"),
-					zap.Error(err))
-				relayErr = err
-				wg.Done()
-				return
-			}
-			wg.Done()
-			wgdns := sync.WaitGroup{}
-			wgdns.Add(runtime.NumCPU())
-			go func() {
-                defer wgdns.Done()
-				buf := make([]byte, 4096)
-				for {
-					msg := <-t.dnsQuery
-					cAddr := &net.UDPAddr{IP: msg.Metadata().ClientIP, Port: msg.Metadata().ClientPort}
-					l.SetDeadline(time.Now().Add(1000 * time.Millisecond))
-					_, err := l.WriteTo(msg.Payload(), t.dnsAddr)
-					if err != nil {
-						continue
-					}
-					n, err := l.Read(buf)
-					if err != nil {
-						continue
-					}
-					tc.WriteTo(buf[:n], cAddr)
-				}
-			}()
-		}()
-
-		// handle connection
-		for {
-			// try to close connection
-			if t.status.Load() == ingress.Closed {
-				return
-			}
-
-			// read msg from client
-			msg, cAddr, err := tc.ReadMsgFrom()
-			if err != nil {
-				log.Error(t.logString("failed to read message"),
-					zap.Error(err))
-				continue
-			}
-
-			m := msg.Metadata()
-			m.WithIngress(t.Name())
-			entry, _ := t.udpNat.Load(cAddr.String())
-			realDst := entry.(natEntry).from.(*net.UDPAddr)
-			realSrc := entry.(natEntry).to.(*net.UDPAddr)
-			// whether dns
-			if t.NeedHijack(realDst.IP, realDst.Port) {
-				t.dnsQuery <- msg
-				continue
-			}
+			// get applications mapped ip and port
+			cAddr := c.RemoteAddr().(*net.TCPAddr)
+			m := message.NewMetadata()
+			m.WithIngress(t.Name()).WithClientIP(cAddr.IP).
+				WithClientPort(cAddr.Port)
+
+			// get real addr
+			entry, _ := t.tcpNat.Load(cAddr.String())
+			realDst := entry.(natEntry).from.(*net.TCPAddr)
+			realSrc := entry.(natEntry).to.(*net.TCPAddr)
 			var remoteAddr string
-			// get remote address
 			if domain, exist := dns.GetDomainByIP(realDst.IP); exist {
 				domain = domain[:len(domain)-1]
 				m.WithDomain(domain).WithRemotePort(realDst.Port)
@@ -668,39 +615,158 @@ func (t *Tun) relay(r router.Router) error {
 				m.WithRemoteIP(realDst.IP).WithRemotePort(realDst.Port)
 				remoteAddr = realDst.String()
 			}
-			log.Info(t.logString("relay"),
-				zap.String("local", realSrc.String()),
-				zap.String("remote", remoteAddr))
-
-			// check whether exist in nat
-			if lc, exist := nat.Get(cAddr.String()); exist {
-				rAddr := lc.Addr
-				// proxy remote
-				if rc, ok := lc.PacketConn.(conn.ProxyPacketConn); ok {
-					if err := rc.WriteMsgTo(msg, rAddr); err != nil {
-						log.Error(t.logString("failed to write msg"),
-							zap.Error(err))
-					}
-					continue
-				}
-				// direct remote
-				if rc, ok := lc.PacketConn.(*net.UDPConn); ok {
-					if _, err := rc.WriteTo(msg.Payload(), rAddr); err != nil {
-						log.Error(t.logString("failed to write msg"),
-							zap.Error(err))
-					}
-					continue
-				}
-			}
+			log.Info(t.logString("accept connection"),
+				zap.String("remote", remoteAddr),
+				zap.String("local", realSrc.String()))
+
+			// construct proxy conn
+			pc := newTunStream(c, m)
+			t.conns.Store(pc.LocalAddr().String(), pc)
+			defer func() {
+				t.conns.Delete(pc.LocalAddr().String())
+				pc.Close()
+				log.Info(t.logString("connection closed"),
+					zap.String("remote", remoteAddr),
+					zap.String("local", realSrc.String()))
+			}()
+
+			// dispatch
 			out := r.Dispatch(*m)
 			log.Info(t.logString("connection dispatched"),
 				zap.String("egress", out.Name()))
-			out.ProcessPacket(tc, msg)
+			out.ProcessStream(pc, nil)
+		}()
+	}
+}
+
+// dnsQuery is hijacked dns query, answer is written back by reply
+type dnsQuery struct {
+	msg   message.Message
+	reply *tunPacket
+}
+
+// hijackDNS sends hijacked queries to dns server
+func (t *Tun) hijackDNS(ready chan<- error) {
+	ip, _ := iface.GetIP()
+	l, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+	if err != nil {
+		log.Error(t.logString("failed to listen udp for hijacking dns"),
+			zap.Error(err))
+		ready <- err
+		return
+	}
+	ready <- nil
+	buf := make([]byte, 4096)
+	for {
+		q := <-t.dnsQuery
+		cAddr := &net.UDPAddr{IP: q.msg.Metadata().ClientIP, Port: q.msg.Metadata().ClientPort}
+		l.SetDeadline(time.Now().Add(1000 * time.Millisecond))
+		_, err := l.WriteTo(q.msg.Payload(), t.dnsAddr)
+		if err != nil {
+			continue
+		}
+		n, err := l.Read(buf)
+		if err != nil {
+			continue
 		}
+		q.reply.WriteTo(buf[:n], cAddr)
+	}
+}
+
+// relayPacket reads udp packets redirected to relay ip
+func (t *Tun) relayPacket(r router.Router, ip net.IP, ready chan<- error) {
+	// nat
+	nat := nat.New()
+
+	// get connection
+	lAddr := &net.UDPAddr{IP: ip, Port: t.relayPort}
+	c, err := net.ListenUDP("udp", lAddr)
+	if err != nil {
+		log.Error(t.logString("failed to listen udp relay"),
+			zap.Error(err))
+		ready <- err
+		return
+	}
+	ready <- nil
+	tc := newTunPacket(c)
+	t.conns.Store(lAddr.String(), tc)
+	log.Info(t.logString("local udp relay started"),
+		zap.String("Addr", lAddr.String()))
+
+	defer func() {
+		t.conns.Delete(lAddr.String())
+		tc.Close()
 	}()
 
-	wg.Wait()
-	return relayErr
+	// handle connection
+	for {
+		// try to close connection
+		if t.status.Load() == ingress.Closed {
+			return
+		}
+
+		// read msg from client
+		msg, cAddr, err := tc.ReadMsgFrom()
+		if err != nil {
+			log.Error(t.logString("failed to read message"),
+				zap.Error(err))
+			continue
+		}
+
+		m := msg.Metadata()
+		m.WithIngress(t.Name())
+		entry, _ := t.udpNat.Load(cAddr.String())
+		realDst := entry.(natEntry).from.(*net.UDPAddr)
+		realSrc := entry.(natEntry).to.(*net.UDPAddr)
+		// whether dns
+		if t.NeedHijack(realDst.IP, realDst.Port) {
+			t.dnsQuery <- dnsQuery{msg: msg, reply: tc}
+			continue
+		}
+		var remoteAddr string
+		// get remote address
+		if domain, exist := dns.GetDomainByIP(realDst.IP); exist {
+			domain = domain[:len(domain)-1]
+			m.WithDomain(domain).WithRemotePort(realDst.Port)
+			if ip, err := dns.ResolveIP(domain); err != nil {
+				log.Error("failed to resolve", zap.Error(err))
+			} else {
+				m.WithRemoteIP(ip[0])
+			}
+			remoteAddr = domain
+		} else {
+			m.WithRemoteIP(realDst.IP).WithRemotePort(realDst.Port)
+			remoteAddr = realDst.String()
+		}
+		log.Info(t.logString("relay"),
+			zap.String("local", realSrc.String()),
+			zap.String("remote", remoteAddr))
+
+		// check whether exist in nat
+		if lc, exist := nat.Get(cAddr.String()); exist {
+			rAddr := lc.Addr
+			// proxy remote
+			if rc, ok := lc.PacketConn.(conn.ProxyPacketConn); ok {
+				if err := rc.WriteMsgTo(msg, rAddr); err != nil {
+					log.Error(t.logString("failed to write msg"),
+						zap.Error(err))
+				}
+				continue
+			}
+			// direct remote
+			if rc, ok := lc.PacketConn.(*net.UDPConn); ok {
+				if _, err := rc.WriteTo(msg.Payload(), rAddr); err != nil {
+					log.Error(t.logString("failed to write msg"),
+						zap.Error(err))
+				}
+				continue
+			}
+		}
+		out := r.Dispatch(*m)
+		log.Info(t.logString("connection dispatched"),
+			zap.String("egress", out.Name()))
+		out.ProcessPacket(tc, msg)
+	}
 }
 
 func (t *Tun) UnmarshalYAML(value *yaml.Node) error {
@@ -710,6 +776,7 @@ func (t *Tun) UnmarshalYAML(value *yaml.Node) error {
 		port   int
 		mtu    int
 		hijack []string
+		cidr6  string
 		err    error
 	)
 	temp := make(map[string]any)
@@ -730,7 +797,14 @@ func (t *Tun) UnmarshalYAML(value *yaml.Node) error {
 		return err
 	}
 
-	err = initTun(t, name, cidr, mtu, port, hijack)
+	attrMay := map[string]any{
+		"cidr6": &cidr6,
+	}
+	if err = util.MayHave(temp, attrMay); err != nil {
+		return err
+	}
+
+	err = initTun(t, name, cidr, cidr6, mtu, port, hijack)
 	if err != nil {
 		return err
 	}
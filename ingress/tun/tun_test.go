@@ -0,0 +1,113 @@
+package tun
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/intxff/rdcross/component/fakeip"
+	"github.com/intxff/rdcross/dns"
+	mdns "github.com/miekg/dns"
+)
+
+// recorder keeps the answer of dns server
+type recorder struct {
+	mdns.ResponseWriter
+	msg *mdns.Msg
+}
+
+func (r *recorder) WriteMsg(m *mdns.Msg) error {
+	r.msg = m
+	return nil
+}
+
+// newTCP6 builds ipv6 tcp syn from src to dst
+func newTCP6(src, dst *net.TCPAddr) ipPacket {
+	p := make(ipPacket, 40+20)
+	p[0] = 6 << 4
+	binary.BigEndian.PutUint16(p[4:], 20)
+	p[6], p[7] = 6, 64
+	copy(p[8:24], src.IP.To16())
+	copy(p[24:40], dst.IP.To16())
+	s := tcpPacket(p[40:])
+	s.setSrcPort(src.Port)
+	s.setDstPort(dst.Port)
+	s[12], s[13] = 5<<4, 0x02
+	s.updateChecksum(p)
+	return p
+}
+
+func readPacket(t *testing.T, r *os.File) (ipPacket, tcpPacket) {
+	buf := make([]byte, 1500)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := ipPacket(buf[:n])
+	s := tcpPacket(p[p.headerLen():])
+	if checksum(p.pseudoSum(), sum(s), 6, uint32(len(s))) != 0 {
+		t.Error("bad tcp checksum")
+	}
+	return p, s
+}
+
+func TestFakeIPv6(t *testing.T) {
+	pool, _ := fakeip.New("198.18.0.1/15")
+	pool6, err := fakeip.New("fdfe:dcba:9876::1/64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &dns.DNS{FakeIP: dns.FakeIP{Enable: true, Ttl: 30}}
+	server, err := d.NewServer(t.TempDir(), pool, pool6, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &recorder{}
+	server.Handler.ServeDNS(w, new(mdns.Msg).SetQuestion("example.com.", mdns.TypeAAAA))
+	if w.msg == nil || len(w.msg.Answer) != 1 {
+		t.Fatalf("answer %v", w.msg)
+	}
+	fake := w.msg.Answer[0].(*mdns.AAAA).AAAA
+
+	tn := &Tun{}
+	if err := initTun(tn, "tun0", "198.18.0.1/16", "fd00:1::1/64", 1400, 10000, nil); err != nil {
+		t.Fatal(err)
+	}
+	r, wr, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	tn.fd = wr
+
+	// application to fake ip is redirected to relay
+	app := &net.TCPAddr{IP: net.ParseIP("fd00:2::5"), Port: 40000}
+	tn.processStream(newTCP6(app, &net.TCPAddr{IP: fake, Port: 443}))
+	p, s := readPacket(t, r)
+	if !p.dstIP().Equal(tn.ip6) || s.dstPort() != tn.relayPort {
+		t.Fatalf("redirected to [%v]:%v", p.dstIP(), s.dstPort())
+	}
+	mapped := &net.TCPAddr{IP: p.srcIP(), Port: s.srcPort()}
+	if !tn.cidr6.Contains(mapped.IP) {
+		t.Errorf("mapped source %v out of tun range", mapped)
+	}
+
+	// relay finds domain of connection as it accepts mapped source
+	entry, ok := tn.tcpNat.Load(mapped.String())
+	if !ok {
+		t.Fatal("no nat entry of mapped source")
+	}
+	dst := entry.(natEntry).from.(*net.TCPAddr)
+	if domain, _ := dns.GetDomainByIP(dst.IP); domain != "example.com." || dst.Port != 443 {
+		t.Errorf("relay got %v:%v", domain, dst.Port)
+	}
+
+	// answer of relay goes back to application from fake ip
+	tn.processStream(newTCP6(&net.TCPAddr{IP: tn.ip6, Port: tn.relayPort}, mapped))
+	p, s = readPacket(t, r)
+	if !p.srcIP().Equal(fake) || s.srcPort() != 443 ||
+		!p.dstIP().Equal(app.IP) || s.dstPort() != app.Port {
+		t.Errorf("answer from [%v]:%v to [%v]:%v", p.srcIP(), s.srcPort(), p.dstIP(), s.dstPort())
+	}
+}
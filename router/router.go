@@ -91,6 +91,11 @@ func (d *DefaultRouter) Insert(ruleType, pattern, out, policy string, others ...
 			d.Rules["PRGPATH"] = rule.NewRulePrgPath()
 		}
 		d.Rules["PRGPATH"].Insert(pattern, rule.NewAction(out, policy))
+	case "USER":
+		if _, exist := d.Rules["USER"]; !exist {
+			d.Rules["USER"] = rule.NewRuleUser()
+		}
+		d.Rules["USER"].Insert(pattern, rule.NewAction(out, policy))
 	default:
 		log.Panic(fmt.Sprintf("invalid rule %v", ruleType))
 	}
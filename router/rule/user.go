@@ -0,0 +1,49 @@
+package rule
+
+import (
+	"errors"
+
+	"github.com/intxff/rdcross/component/message"
+)
+
+var _ Rule = (*User)(nil)
+
+type User map[string]*Action
+
+func NewRuleUser() *User {
+	r := make(User)
+	return &r
+}
+
+func (r *User) Name() string {
+	return "USER"
+}
+
+func (r *User) Match(m message.Metadata, others ...any) (*Action, bool) {
+	user := m.User
+	if user == "" {
+		return nil, false
+	}
+	action, exist := (*r)[user]
+	if !exist {
+		return nil, false
+	}
+	return action, true
+}
+
+func (r *User) Insert(a ...any) error {
+	user, ok := a[0].(string)
+	if !ok {
+		return errors.New("invalid user name to insert into USER")
+	}
+	action, ok := a[1].(*Action)
+	if !ok {
+		return errors.New("invalid action to insert into USER")
+	}
+	(*r)[user] = action
+	return nil
+}
+
+func (r *User) Empty() bool {
+	return len(*r) == 0
+}
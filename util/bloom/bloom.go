@@ -0,0 +1,124 @@
+package bloom
+
+import (
+	"hash/maphash"
+	"math"
+	"sync"
+)
+
+// Filter is a bloom filter, false positive is possible while false
+// negative is not
+type Filter struct {
+	bits []uint64
+	m    uint64
+	k    int
+	// two hashes combined into k ones
+	seed1 maphash.Seed
+	seed2 maphash.Seed
+}
+
+// New creates filter holding n items with false positive rate p
+func New(n int, p float64) *Filter {
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := int(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Filter{
+		bits:  make([]uint64, (m+63)/64),
+		m:     m,
+		k:     k,
+		seed1: maphash.MakeSeed(),
+		seed2: maphash.MakeSeed(),
+	}
+}
+
+func (f *Filter) locations(b []byte) (uint64, uint64) {
+	return maphash.Bytes(f.seed1, b), maphash.Bytes(f.seed2, b) | 1
+}
+
+// Add puts b into filter
+func (f *Filter) Add(b []byte) {
+	h1, h2 := f.locations(b)
+	for i := 0; i < f.k; i++ {
+		loc := (h1 + uint64(i)*h2) % f.m
+		f.bits[loc/64] |= 1 << (loc % 64)
+	}
+}
+
+// Test reports whether b may be in filter
+func (f *Filter) Test(b []byte) bool {
+	h1, h2 := f.locations(b)
+	for i := 0; i < f.k; i++ {
+		loc := (h1 + uint64(i)*h2) % f.m
+		if f.bits[loc/64]&(1<<(loc%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Reset empties filter
+func (f *Filter) Reset() {
+	for i := range f.bits {
+		f.bits[i] = 0
+	}
+}
+
+// Rotating keeps two filters, the older one is dropped when the newer
+// one is full, so at least the last n items are remembered
+type Rotating struct {
+	mu       sync.Mutex
+	current  *Filter
+	previous *Filter
+	count    int
+	capacity int
+}
+
+// NewRotating creates rotating filter remembering at least n items with
+// false positive rate about 2p
+func NewRotating(n int, p float64) *Rotating {
+	return &Rotating{
+		current:  New(n, p),
+		previous: New(n, p),
+		capacity: n,
+	}
+}
+
+// Test reports whether b may be seen
+func (r *Rotating) Test(b []byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current.Test(b) || r.previous.Test(b)
+}
+
+// Add remembers b
+func (r *Rotating) Add(b []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.add(b)
+}
+
+// TestAndAdd remembers b, reports whether it is seen before
+func (r *Rotating) TestAndAdd(b []byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current.Test(b) || r.previous.Test(b) {
+		return true
+	}
+	r.add(b)
+	return false
+}
+
+func (r *Rotating) add(b []byte) {
+	if r.count >= r.capacity {
+		r.current, r.previous = r.previous, r.current
+		r.current.Reset()
+		r.count = 0
+	}
+	r.current.Add(b)
+	r.count++
+}
@@ -0,0 +1,31 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestRotating(t *testing.T) {
+	r := NewRotating(1000, 1e-6)
+	key := func(i int) []byte {
+		return binary.BigEndian.AppendUint64(nil, uint64(i))
+	}
+	for i := 0; i < 1500; i++ {
+		if r.TestAndAdd(key(i)) {
+			t.Fatalf("%v seen before added", i)
+		}
+	}
+	// the last capacity items are remembered
+	for i := 500; i < 1500; i++ {
+		if !r.Test(key(i)) {
+			t.Errorf("%v forgotten", i)
+		}
+	}
+	// the first generation is dropped by the next rotation
+	for i := 1500; i < 2500; i++ {
+		r.Add(key(i))
+	}
+	if r.Test(key(0)) {
+		t.Errorf("0 remembered after rotation")
+	}
+}
@@ -56,7 +56,12 @@ func (l *LRU) Put(key, value interface{}) {
 	e := &lruElement{key, value}
 	if v, ok := l.keyToElement.Load(key); ok {
 		element := v.(*list.Element)
+		old := element.Value.(*lruElement).value
+		if t, ok := l.valueToElement.Load(old); ok && t == element {
+			l.valueToElement.Delete(old)
+		}
 		element.Value = e
+		l.valueToElement.Store(value, element)
 		l.list.MoveToFront(element)
 	} else {
 		element := l.list.PushFront(e)
@@ -66,11 +71,30 @@ func (l *LRU) Put(key, value interface{}) {
 			toBeRemove := l.list.Back()
 			l.list.Remove(toBeRemove)
 			l.keyToElement.Delete(toBeRemove.Value.(*lruElement).key)
-			l.valueToElement.Delete(toBeRemove.Value.(*lruElement).value)
+			// value may be taken over by the new element
+			if v, ok := l.valueToElement.Load(toBeRemove.Value.(*lruElement).value); ok && v == toBeRemove {
+				l.valueToElement.Delete(toBeRemove.Value.(*lruElement).value)
+			}
 		}
 	}
 }
 
+// Delete removes key and its value
+func (l *LRU) Delete(key interface{}) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	v, ok := l.keyToElement.LoadAndDelete(key)
+	if !ok {
+		return
+	}
+	element := v.(*list.Element)
+	l.list.Remove(element)
+	value := element.Value.(*lruElement).value
+	if t, ok := l.valueToElement.Load(value); ok && t == element {
+		l.valueToElement.Delete(value)
+	}
+}
+
 func (l *LRU) ReplaceLastValue(key interface{}) interface{} {
 	l.m.Lock()
 	defer l.m.Unlock()
@@ -93,6 +117,25 @@ func (l *LRU) GetLastValue() interface{} {
 	return element.Value.(*lruElement).value
 }
 
+// Range calls f for each element from least to most recently used
+// until f returns false
+func (l *LRU) Range(f func(key, value interface{}) bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	for e := l.list.Back(); e != nil; e = e.Prev() {
+		if !f(e.Value.(*lruElement).key, e.Value.(*lruElement).value) {
+			return
+		}
+	}
+}
+
+func (l *LRU) Len() int {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.list.Len()
+}
+
 func (l *LRU) IsFull() bool {
 	return l.list.Len() >= l.capacity
 }